@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+)
+
+// isAccountID reports whether s is a 12-digit AWS account ID.
+func isAccountID(s string) bool {
+	if len(s) != 12 {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// arnAccount returns the account of an ARN, or "" when value is not an ARN
+// with one, e.g. a hardware MFA serial number.
+func arnAccount(value string) string {
+	a, err := arn.Parse(value)
+	if err != nil || !isAccountID(a.AccountID) {
+		return ""
+	}
+	return a.AccountID
+}
+
+// accountFromAccessKey returns the account an access key ID belongs to,
+// which 40 bits following its four-letter prefix encode, sparing a
+// GetCallerIdentity call. Every key STS issues today encodes it;
+// "" is returned for IDs that are not keys.
+func accountFromAccessKey(id string) string {
+	if len(id) != 20 {
+		return ""
+	}
+	b, err := base32.StdEncoding.DecodeString(strings.ToUpper(id[4:]))
+	if err != nil {
+		return ""
+	}
+	v := binary.BigEndian.Uint64(append([]byte{0, 0}, b[:6]...))
+	return fmt.Sprintf("%012d", (v&0x7fffffffff80)>>7)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// errApprovalDenied is returned when the user does not approve a request
+// for cached credentials.
+var errApprovalDenied = errors.New("credentials request was not approved")
+
+// promptedOTPSource records whether source was asked for a code, which tells
+// a session minted in this run from one served from the cache.
+type promptedOTPSource struct {
+	source   OTPSource
+	prompted bool
+}
+
+func (s *promptedOTPSource) OTP(ctx context.Context, serial string) (string, error) {
+	s.prompted = true
+	return s.source.OTP(ctx, serial)
+}
+
+func (s *promptedOTPSource) NotifyInvalidOTP(ctx context.Context, err error) {
+	if n, ok := s.source.(invalidOTPNotifier); ok {
+		n.NotifyInvalidOTP(ctx, err)
+	}
+}
+
+// approve asks on the terminal whether the process pid may use the
+// credentials of the profile. Unlike the MFA prompt, it never falls back to
+// stdin, which belongs to the requesting process.
+func approve(prompt *ttyOTPSource, pid int) error {
+	r, w, closeFn, ok := prompt.open()
+	defer closeFn()
+	if !ok {
+		return errors.New("--approve requires a terminal to ask for approval on")
+	}
+
+	requester := fmt.Sprintf("Process %d", pid)
+	if exe := processExecutable(runtime.GOOS, pid); exe != "" {
+		requester += " (" + exe + ")"
+	}
+	var answer string
+	if err := prompt.ask(r, w, false, fmt.Sprintf("%s requests credentials for profile %s. Allow? [y/N] ", requester, prompt.label.profile), func(r io.Reader, w io.Writer) (err error) {
+		answer, err = bufio.NewReader(r).ReadString('\n')
+		if answer != "" {
+			return nil
+		}
+		return err
+	}); err != nil {
+		return err
+	}
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return nil
+	}
+	return errApprovalDenied
+}
+
+// processExecutable returns the executable of the process pid, or an empty
+// string when it cannot be determined.
+func processExecutable(goos string, pid int) string {
+	switch goos {
+	case "linux":
+		if exe, err := os.Readlink(fmt.Sprintf("/proc/%d/exe", pid)); err == nil {
+			return exe
+		}
+		return ""
+	case "windows":
+		return ""
+	}
+	out, err := exec.Command("ps", "-o", "comm=", "-p", strconv.Itoa(pid)).Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
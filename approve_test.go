@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestApprove(t *testing.T) {
+	tests := []struct {
+		name    string
+		answer  string
+		wantErr error
+	}{
+		{name: "approved", answer: "y\n"},
+		{name: "denied by default", answer: "\n", wantErr: errApprovalDenied},
+		{name: "denied", answer: "no\n", wantErr: errApprovalDenied},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var terminal bytes.Buffer
+			prompt := &ttyOTPSource{
+				label: mfaLabel{profile: "prod"},
+				terminal: func() (io.Reader, io.Writer, func(), error) {
+					return strings.NewReader(tt.answer), &terminal, func() {}, nil
+				},
+			}
+
+			if err := approve(prompt, os.Getpid()); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("approve() error = %v, want %v", err, tt.wantErr)
+			}
+			if !strings.Contains(terminal.String(), "requests credentials for profile prod") {
+				t.Errorf("terminal = %q, want the approval prompt", terminal.String())
+			}
+		})
+	}
+}
+
+func TestApprove_NoTerminal(t *testing.T) {
+	prompt := &ttyOTPSource{noTerminal: true, stdin: strings.NewReader("y\n"), stderr: io.Discard}
+
+	if err := approve(prompt, os.Getpid()); err == nil {
+		t.Fatal("approve() error = nil, want error")
+	}
+}
+
+func TestPromptedOTPSource(t *testing.T) {
+	source := &promptedOTPSource{source: &fakeOTPSource{otp: "123456"}}
+	if source.prompted {
+		t.Fatal("prompted = true before OTP()")
+	}
+	if _, err := source.OTP(context.Background(), ""); err != nil {
+		t.Fatalf("OTP() error = %v", err)
+	}
+	if !source.prompted {
+		t.Error("prompted = false after OTP()")
+	}
+}
+
+func TestProcessExecutable(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("reads /proc")
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := processExecutable("linux", os.Getpid()); got != exe {
+		t.Errorf("processExecutable() = %q, want %q", got, exe)
+	}
+}
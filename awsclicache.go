@@ -0,0 +1,117 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	ststypes "github.com/aws/aws-sdk-go-v2/service/sts/types"
+)
+
+// awsCLICacheDir returns where the AWS CLI caches the sessions of role
+// profiles. The CLI does not let it be moved.
+func awsCLICacheDir() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".aws", "cli", "cache"), nil
+}
+
+// awsCLICacheName returns the file the AWS CLI caches the session of the
+// role profile cfg in. Like botocore, it hashes the AssumeRole parameters
+// other than the session name, written as Python's json.dumps with sorted
+// keys would write them.
+func awsCLICacheName(cfg *config.SharedConfig) string {
+	var params []string
+	if cfg.RoleDurationSeconds != nil {
+		params = append(params, `"DurationSeconds": `+strconv.Itoa(int(cfg.RoleDurationSeconds.Seconds())))
+	}
+	for _, p := range []struct{ key, value string }{
+		{"ExternalId", cfg.ExternalID},
+		{"RoleArn", cfg.RoleARN},
+		{"SerialNumber", cfg.MFASerial},
+	} {
+		if p.value == "" {
+			continue
+		}
+		value, _ := json.Marshal(p.value)
+		params = append(params, `"`+p.key+`": `+string(value))
+	}
+	sum := sha1.Sum([]byte("{" + strings.Join(params, ", ") + "}"))
+	return hex.EncodeToString(sum[:]) + ".json"
+}
+
+// awsCLICacheFile is a session as the AWS CLI caches it: the AssumeRole
+// response, of which the CLI reads only the credentials.
+type awsCLICacheFile struct {
+	Credentials struct {
+		AccessKeyID     string `json:"AccessKeyId"`
+		SecretAccessKey string `json:"SecretAccessKey"`
+		SessionToken    string `json:"SessionToken"`
+		Expiration      string `json:"Expiration"`
+	} `json:"Credentials"`
+}
+
+// readAWSCLICache returns the session cached by the AWS CLI at path.
+func readAWSCLICache(path string) (*ststypes.Credentials, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file awsCLICacheFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+	creds := &ststypes.Credentials{
+		AccessKeyId:     aws.String(file.Credentials.AccessKeyID),
+		SecretAccessKey: aws.String(file.Credentials.SecretAccessKey),
+		SessionToken:    aws.String(file.Credentials.SessionToken),
+	}
+	if file.Credentials.Expiration != "" {
+		expires, err := parseAWSCLIExpiration(file.Credentials.Expiration)
+		if err != nil {
+			return nil, err
+		}
+		creds.Expiration = &expires
+	}
+	return creds, nil
+}
+
+// awsCLIExpirationLayout is how botocore's JSONFileCache writes the
+// expiration, with strftime('%Y-%m-%dT%H:%M:%S%Z') of a UTC time.
+const awsCLIExpirationLayout = "2006-01-02T15:04:05UTC"
+
+// parseAWSCLIExpiration parses the expiration of a session cached by the
+// AWS CLI, or in RFC 3339 as other tools write it.
+func parseAWSCLIExpiration(value string) (time.Time, error) {
+	if t, err := time.Parse(awsCLIExpirationLayout, value); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// writeAWSCLICache caches creds at path the way the AWS CLI does, readable
+// only by the user.
+func writeAWSCLICache(path string, creds *ststypes.Credentials) error {
+	var file awsCLICacheFile
+	file.Credentials.AccessKeyID = aws.ToString(creds.AccessKeyId)
+	file.Credentials.SecretAccessKey = aws.ToString(creds.SecretAccessKey)
+	file.Credentials.SessionToken = aws.ToString(creds.SessionToken)
+	file.Credentials.Expiration = aws.ToTime(creds.Expiration).UTC().Format(awsCLIExpirationLayout)
+	data, err := json.Marshal(file)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data, 0600)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+)
+
+func TestAWSCLICacheName(t *testing.T) {
+	hour := time.Hour
+	tests := map[string]struct {
+		cfg  config.SharedConfig
+		want string
+	}{
+		"role only": {
+			cfg:  config.SharedConfig{RoleARN: "arn:aws:iam::123456789012:role/Admin", RoleSessionName: "alice"},
+			want: "51e7b105b5040ce662656c8dc6ab4de70c4de7ad.json",
+		},
+		"every parameter": {
+			cfg: config.SharedConfig{
+				RoleARN:             "arn:aws:iam::123456789012:role/Admin",
+				ExternalID:          "ext-1",
+				MFASerial:           "arn:aws:iam::123456789012:mfa/alice",
+				RoleDurationSeconds: &hour,
+			},
+			want: "e4b2f49518f7d4177f19615166fd653865a2293c.json",
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := awsCLICacheName(&tt.cfg); got != tt.want {
+				t.Errorf("awsCLICacheName() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadAWSCLICache(t *testing.T) {
+	// As written by the AWS CLI.
+	path := filepath.Join(t.TempDir(), "cache.json")
+	data := `{"Credentials": {"AccessKeyId": "ASIACLI", "SecretAccessKey": "secret", "SessionToken": "token", "Expiration": "2026-10-15T10:00:00UTC"}, "AssumedRoleUser": {"AssumedRoleId": "AROA:botocore-session-1", "Arn": "arn:aws:sts::123456789012:assumed-role/Admin/botocore-session-1"}, "ResponseMetadata": {}}`
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	creds, err := readAWSCLICache(path)
+	if err != nil {
+		t.Fatalf("readAWSCLICache() error = %v", err)
+	}
+	if aws.ToString(creds.AccessKeyId) != "ASIACLI" || aws.ToString(creds.SessionToken) != "token" {
+		t.Errorf("readAWSCLICache() = %+v, want the CLI's session", creds)
+	}
+	if want := time.Date(2026, 10, 15, 10, 0, 0, 0, time.UTC); !aws.ToTime(creds.Expiration).Equal(want) {
+		t.Errorf("Expiration = %v, want %v", creds.Expiration, want)
+	}
+}
+
+func TestParseAWSCLIExpiration(t *testing.T) {
+	want := time.Date(2026, 10, 15, 10, 0, 0, 0, time.UTC)
+	for _, value := range []string{"2026-10-15T10:00:00UTC", "2026-10-15T10:00:00+00:00", "2026-10-15T19:00:00+09:00", "2026-10-15T10:00:00Z"} {
+		got, err := parseAWSCLIExpiration(value)
+		if err != nil {
+			t.Errorf("parseAWSCLIExpiration(%q) error = %v", value, err)
+			continue
+		}
+		if !got.Equal(want) {
+			t.Errorf("parseAWSCLIExpiration(%q) = %v, want %v", value, got, want)
+		}
+	}
+	if _, err := parseAWSCLIExpiration("tomorrow"); err == nil {
+		t.Error("parseAWSCLIExpiration() error = nil, want error for a malformed expiration")
+	}
+}
+
+func TestWriteAWSCLICache(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cli", "cache", "key.json")
+	expires := time.Date(2026, 10, 15, 10, 0, 0, 0, time.UTC)
+	if err := writeAWSCLICache(path, newStsCreds("ASIAHELPER", "secret", "token", expires)); err != nil {
+		t.Fatalf("writeAWSCLICache() error = %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"Credentials":{"AccessKeyId":"ASIAHELPER","SecretAccessKey":"secret","SessionToken":"token","Expiration":"2026-10-15T10:00:00UTC"}}`
+	if string(data) != want {
+		t.Errorf("cache = %s, want %s", data, want)
+	}
+}
+
+func TestCachedSessionProvider_AWSCLICache(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	newProvider := func(inner *fakeStsSessionProvider, cliCache string) *CachedSessionProvider {
+		return &CachedSessionProvider{
+			SessionProvider: inner,
+			CacheDir:        t.TempDir(),
+			Profile:         "admin",
+			ExpiryWindow:    5 * time.Minute,
+			RoleArn:         "arn:aws:iam::123456789012:role/Admin",
+			AWSCLICachePath: cliCache,
+		}
+	}
+
+	t.Run("serves the CLI's session", func(t *testing.T) {
+		cliCache := filepath.Join(t.TempDir(), "key.json")
+		if err := writeAWSCLICache(cliCache, newStsCreds("ASIACLI", "secret", "token", now.Add(time.Hour))); err != nil {
+			t.Fatal(err)
+		}
+		inner := &fakeStsSessionProvider{creds: newStsCreds("ASIAHELPER", "secret", "token", now.Add(time.Hour))}
+		creds, err := newProvider(inner, cliCache).RetrieveStsCredentials(ctx)
+		if err != nil {
+			t.Fatalf("RetrieveStsCredentials() error = %v", err)
+		}
+		if got := aws.ToString(creds.AccessKeyId); got != "ASIACLI" || inner.called != 0 {
+			t.Errorf("AccessKeyId = %q with %d mints, want the CLI's session", got, inner.called)
+		}
+	})
+
+	t.Run("shares a new session", func(t *testing.T) {
+		cliCache := filepath.Join(t.TempDir(), "key.json")
+		// The CLI's session is about to expire.
+		if err := writeAWSCLICache(cliCache, newStsCreds("ASIACLI", "secret", "token", now.Add(time.Minute))); err != nil {
+			t.Fatal(err)
+		}
+		inner := &fakeStsSessionProvider{creds: newStsCreds("ASIAHELPER", "secret", "token", now.Add(time.Hour))}
+		if _, err := newProvider(inner, cliCache).RetrieveStsCredentials(ctx); err != nil {
+			t.Fatalf("RetrieveStsCredentials() error = %v", err)
+		}
+		creds, err := readAWSCLICache(cliCache)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := aws.ToString(creds.AccessKeyId); got != "ASIAHELPER" || inner.called != 1 {
+			t.Errorf("CLI cache AccessKeyId = %q with %d mints, want the new session", got, inner.called)
+		}
+	})
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// bwCredentialSource reads the item from Bitwarden with the bw CLI. The vault
+// must be unlocked, with BW_SESSION exported, before the tool runs.
+type bwCredentialSource struct {
+	cliPath string
+	OpAwsItem
+}
+
+// bwItem is the part of bw get item output the key pair and MFA seed are
+// read from.
+type bwItem struct {
+	Login *struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+		TOTP     string `json:"totp"`
+	} `json:"login"`
+	Fields []struct {
+		Name  string `json:"name"`
+		Value string `json:"value"`
+	} `json:"fields"`
+}
+
+// run runs bw with args, adding its stderr to the error when it fails.
+func (s *bwCredentialSource) run(ctx context.Context, args ...string) ([]byte, error) {
+	out, err := exec.CommandContext(ctx, s.cliPath, args...).Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			stderr := strings.TrimSpace(string(exitErr.Stderr))
+			if strings.Contains(stderr, "locked") {
+				return nil, fmt.Errorf("bitwarden vault is locked; run bw unlock and export BW_SESSION: %w", err)
+			}
+			return nil, fmt.Errorf("%w\n%s", err, stderr)
+		}
+		return nil, err
+	}
+	return out, nil
+}
+
+func (s *bwCredentialSource) item(ctx context.Context) (*bwItem, error) {
+	out, err := s.run(ctx, "get", "item", s.Item)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get bitwarden item: %w", err)
+	}
+	var item bwItem
+	if err := json.Unmarshal(out, &item); err != nil {
+		return nil, err
+	}
+	return &item, nil
+}
+
+func (s *bwCredentialSource) Retrieve(ctx context.Context) (aws.Credentials, error) {
+	return readCredentials(ctx, s)
+}
+
+func (s *bwCredentialSource) awsItem() OpAwsItem {
+	return s.OpAwsItem
+}
+
+func (s *bwCredentialSource) withItem(vault, item string) opItem {
+	other := *s
+	other.Item = item
+	return &other
+}
+
+// details returns the custom fields of the item by name, along with the
+// username and password of its login.
+func (s *bwCredentialSource) details(ctx context.Context) (*opItemDetails, error) {
+	item, err := s.item(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	details := &opItemDetails{fields: make(map[string]string, len(item.Fields)+2)}
+	for _, field := range item.Fields {
+		if _, ok := details.fields[field.Name]; !ok {
+			details.fields[field.Name] = field.Value
+		}
+	}
+	if item.Login != nil {
+		for name, value := range map[string]string{"username": item.Login.Username, "password": item.Login.Password} {
+			if _, ok := details.fields[name]; !ok && value != "" {
+				details.fields[name] = value
+			}
+		}
+	}
+	return details, nil
+}
+
+func (s *bwCredentialSource) fields(ctx context.Context, labels ...string) (map[string]string, error) {
+	details, err := s.details(ctx)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]string, len(labels))
+	for _, label := range labels {
+		if value, ok := details.fields[label]; ok {
+			values[label] = value
+		}
+	}
+	return values, nil
+}
+
+// totp returns the current code of the login's authenticator key; Bitwarden
+// items have no other one-time password fields.
+func (s *bwCredentialSource) totp(ctx context.Context, label string) (string, error) {
+	if label != "" {
+		return "", errors.New("bitwarden items have a single TOTP; omit --op-otp-field")
+	}
+	out, err := s.run(ctx, "get", "totp", s.Item)
+	if err != nil {
+		return "", fmt.Errorf("failed to get bitwarden TOTP: %w", err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+func (s *bwCredentialSource) otpSeed(ctx context.Context, label string) (string, error) {
+	if label != "" {
+		return "", errors.New("bitwarden items have a single TOTP; omit --op-otp-field")
+	}
+	item, err := s.item(ctx)
+	if err != nil {
+		return "", err
+	}
+	if item.Login == nil || item.Login.TOTP == "" {
+		return "", fmt.Errorf("bitwarden item %s has no authenticator key", s.Item)
+	}
+	// Bitwarden also stores bare base32 secrets.
+	if !strings.HasPrefix(item.Login.TOTP, "otpauth://") {
+		return "otpauth://totp/bitwarden?secret=" + strings.ToUpper(strings.ReplaceAll(item.Login.TOTP, " ", "")), nil
+	}
+	return item.Login.TOTP, nil
+}
+
+func (s *bwCredentialSource) read(ctx context.Context, ref string) (string, error) {
+	return "", fmt.Errorf("secret reference %s cannot be read from bitwarden", ref)
+}
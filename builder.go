@@ -0,0 +1,256 @@
+package main
+
+import (
+	"cmp"
+	"path/filepath"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// sessionBuilder turns a shared config profile, including its source_profile
+// chain, into a cached STS session provider.
+type sessionBuilder struct {
+	baseCreds aws.CredentialsProvider
+	otpSource OTPSource
+	region    string
+	// fallbackRegions are tried in order when STS in region is unreachable.
+	fallbackRegions []string
+	// stsTimeout bounds each STS call.
+	stsTimeout time.Duration
+	// stsOptFns customize every STS client, e.g. the endpoint to use.
+	stsOptFns []func(*sts.Options)
+	cacheDir  string
+	// cacheCipher encrypts every cached session when set; one is shared so
+	// the keyring is read once per run.
+	cacheCipher *keyringCipher
+	// refreshMargin is how long before expiration cached sessions are
+	// replaced.
+	refreshMargin time.Duration
+	// renewAhead, when set, renews sessions inside refreshMargin in the
+	// background while they are still served.
+	renewAhead func() error
+	// maxAge caps how long after minting sessions are served.
+	maxAge time.Duration
+	// maxSessions bounds how many sessions the cache directory keeps.
+	maxSessions int
+	// awsCLICacheDir, when set, is the AWS CLI's cache, which the sessions
+	// of role profiles are shared through.
+	awsCLICacheDir string
+	// report records how each session is obtained when set.
+	report *cacheReport
+	// partition is the partition sessions are minted in.
+	partition string
+	// syncStore shares sessions with other machines when set.
+	syncStore cacheStore
+	// forceRefresh ignores cached sessions, minting each once more.
+	forceRefresh bool
+	// cacheStore keeps sessions in place of cache files when set.
+	cacheStore cacheStore
+	opAwsItem  OpAwsItem
+	mfaSerial  string
+	// mfaSerialSource discovers the MFA device when mfaSerial is empty.
+	mfaSerialSource MfaSerialSource
+	// otpAttempts is how many MFA codes are tried before giving up.
+	otpAttempts int
+	// roleSessionName overrides role_session_name of every profile when set.
+	roleSessionName string
+	// mfaSession, when set, is an MFA session that profiles are derived from
+	// instead of prompting for MFA, see loginCmd.
+	mfaSession *CachedSessionProvider
+	// credentialCommand is --credential-command, which replaces the item
+	// as the source of the long-term key pair.
+	credentialCommand string
+	// settings supplies the STS region, overriding the config, e.g. from
+	// an item field.
+	settings stsRegionSource
+	// keyDuration, when set, is the duration of sessions minted from the
+	// key pair, overriding the config, e.g. from an item field.
+	keyDuration time.Duration
+}
+
+func (b *sessionBuilder) newSTSClient(creds aws.CredentialsProvider) *fallbackSTSClient {
+	return &fallbackSTSClient{
+		Client: sts.New(sts.Options{
+			Region:      b.region,
+			Credentials: creds,
+		}, b.stsOptFns...),
+		regions:  b.fallbackRegions,
+		timeout:  b.stsTimeout,
+		settings: b.settings,
+	}
+}
+
+func (b *sessionBuilder) sessionName(cfg *config.SharedConfig) string {
+	if b.roleSessionName != "" {
+		return b.roleSessionName
+	}
+	if cfg.RoleSessionName != "" {
+		return cfg.RoleSessionName
+	}
+	return defaultRoleSessionName()
+}
+
+func (b *sessionBuilder) build(cfg *config.SharedConfig) (*CachedSessionProvider, error) {
+	if cfg.Source != nil {
+		source, err := b.build(cfg.Source)
+		if err != nil {
+			return nil, err
+		}
+		return b.shareWithAWSCLI(b.assumeRole(source, cfg.Profile, cfg.RoleARN, cfg.ExternalID, b.sessionName(cfg), profileDuration(cfg, defaultRoleDuration)), cfg), nil
+	}
+
+	if b.mfaSession != nil {
+		if cfg.RoleARN != "" {
+			return b.shareWithAWSCLI(b.assumeRole(b.mfaSession, cfg.Profile, cfg.RoleARN, cfg.ExternalID, b.sessionName(cfg), profileDuration(cfg, defaultRoleDuration)), cfg), nil
+		}
+		return b.cached(b.mfaSession, cfg.Profile, "", b.mfaSession.Duration), nil
+	}
+
+	if cfg.RoleARN == "" {
+		return b.sessionToken(cfg.Profile, cmp.Or(b.keyDuration, profileDuration(cfg, defaultSessionDuration))), nil
+	}
+	provider := &AssumeRoleProvider{
+		BaseCredsProvider: b.baseCreds,
+		OTPSource:         b.otpSource,
+		StsClient:         b.newSTSClient(b.baseCreds),
+		RoleArn:           cfg.RoleARN,
+		RoleSessionName:   b.sessionName(cfg),
+		ExternalID:        cfg.ExternalID,
+		MfaSerial:         b.mfaSerial,
+		MfaSerialSource:   b.mfaSerialSource,
+		OTPAttempts:       b.otpAttempts,
+		Duration:          cmp.Or(b.keyDuration, profileDuration(cfg, defaultRoleDuration)),
+	}
+	return b.shareWithAWSCLI(b.cached(provider, cfg.Profile, cfg.RoleARN, provider.Duration), cfg), nil
+}
+
+// shareWithAWSCLI shares the session of the role profile cfg through the
+// file the AWS CLI caches it in, when awsCLICacheDir is set.
+func (b *sessionBuilder) shareWithAWSCLI(p *CachedSessionProvider, cfg *config.SharedConfig) *CachedSessionProvider {
+	if b.awsCLICacheDir != "" {
+		p.AWSCLICachePath = filepath.Join(b.awsCLICacheDir, awsCLICacheName(cfg))
+	}
+	return p
+}
+
+// sessionToken mints an MFA session with GetSessionToken for profile.
+func (b *sessionBuilder) sessionToken(profile string, duration time.Duration) *CachedSessionProvider {
+	provider := &SessionTokenProvider{
+		BaseCredsProvider: b.baseCreds,
+		OTPSource:         b.otpSource,
+		StsClient:         b.newSTSClient(b.baseCreds),
+		MfaSerial:         b.mfaSerial,
+		MfaSerialSource:   b.mfaSerialSource,
+		OTPAttempts:       b.otpAttempts,
+		Duration:          duration,
+	}
+	return b.cached(provider, profile, "", duration)
+}
+
+// assumeRole assumes roleArn using the session minted by source. The source
+// session already carries the MFA context, so no prompt is needed.
+func (b *sessionBuilder) assumeRole(source *CachedSessionProvider, profile, roleArn, externalID, sessionName string, duration time.Duration) *CachedSessionProvider {
+	provider := &AssumeRoleProvider{
+		BaseCredsProvider: source,
+		StsClient:         b.newSTSClient(aws.NewCredentialsCache(source)),
+		RoleArn:           roleArn,
+		RoleSessionName:   sessionName,
+		ExternalID:        externalID,
+		Duration:          duration,
+	}
+	return b.cached(provider, profile, roleArn, duration)
+}
+
+// assumeRoot exchanges the management account session minted by source for
+// root credentials on the member account target.
+func (b *sessionBuilder) assumeRoot(source *CachedSessionProvider, profile, target, taskPolicyArn string, duration time.Duration) *CachedSessionProvider {
+	provider := &AssumeRootProvider{
+		BaseCredsProvider: source,
+		StsClient:         b.newSTSClient(aws.NewCredentialsCache(source)),
+		TargetPrincipal:   target,
+		TaskPolicyArn:     taskPolicyArn,
+		Duration:          duration,
+	}
+	root := b.cached(provider, profile, source.RoleArn, duration)
+	root.SessionType = "root:" + target + ":" + taskPolicyArn
+	return root
+}
+
+func (b *sessionBuilder) federate(cfg *config.SharedConfig, name, policy string, policyArns []string) *CachedSessionProvider {
+	provider := &FederationTokenProvider{
+		BaseCredsProvider: b.baseCreds,
+		StsClient:         b.newSTSClient(b.baseCreds),
+		Name:              name,
+		Policy:            policy,
+		PolicyArns:        policyArns,
+		Duration:          cmp.Or(b.keyDuration, profileDuration(cfg, defaultSessionDuration)),
+	}
+	source := b.cached(provider, cfg.Profile, "", provider.Duration)
+	source.SessionType = "federation:" + name
+	return source
+}
+
+func (b *sessionBuilder) webIdentity(cfg *config.SharedConfig, roleArn string, tokenSource WebIdentityTokenSource, policy string, policyArns []string) *CachedSessionProvider {
+	provider := &WebIdentityProvider{
+		TokenSource: tokenSource,
+		// AssumeRoleWithWebIdentity is unsigned.
+		StsClient:       b.newSTSClient(nil),
+		RoleArn:         roleArn,
+		RoleSessionName: b.sessionName(cfg),
+		Duration:        profileDuration(cfg, defaultRoleDuration),
+		Policy:          policy,
+		PolicyArns:      policyArns,
+	}
+	source := b.cached(provider, cfg.Profile, roleArn, provider.Duration)
+	source.MfaSerial = ""
+	source.SessionType = "web-identity"
+	return source
+}
+
+func (b *sessionBuilder) cached(provider StsSessionProvider, profile, roleArn string, duration time.Duration) *CachedSessionProvider {
+	return &CachedSessionProvider{
+		SessionProvider:   provider,
+		CacheDir:          b.cacheDir,
+		Cipher:            b.cacheCipher,
+		Store:             b.cacheStore,
+		ForceRefresh:      b.forceRefresh,
+		RenewAhead:        b.renewAhead,
+		MaxAge:            b.maxAge,
+		MaxSessions:       b.maxSessions,
+		Report:            b.report,
+		Partition:         b.partition,
+		SyncStore:         b.syncStore,
+		Profile:           profile,
+		ExpiryWindow:      b.refreshMargin,
+		OpAwsItem:         b.opAwsItem,
+		CredentialCommand: b.credentialCommand,
+		MfaSerial:         b.mfaSerial,
+		RoleArn:           roleArn,
+		Duration:          duration,
+	}
+}
+
+// chainMfaSerial returns the first mfa_serial found walking from the selected
+// profile down its source_profile chain. Every hop is authorized by the same
+// IAM user, so the device may be declared on any of them.
+func chainMfaSerial(cfg *config.SharedConfig) string {
+	for c := cfg; c != nil; c = c.Source {
+		if c.MFASerial != "" {
+			return c.MFASerial
+		}
+	}
+	return ""
+}
+
+// profileDuration returns duration_seconds of the profile, or fallback when
+// it is not set. Unlike the AWS CLI, duration_seconds also applies to
+// GetSessionToken and GetFederationToken sessions.
+func profileDuration(cfg *config.SharedConfig, fallback time.Duration) time.Duration {
+	if cfg.RoleDurationSeconds != nil {
+		return *cfg.RoleDurationSeconds
+	}
+	return fallback
+}
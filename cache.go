@@ -0,0 +1,357 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// cacheCmd inspects the session cache.
+type cacheCmd struct {
+	Ls     cacheLsCmd     `cmd:"" help:"List cached sessions with their expiration and remaining lifetime."`
+	Rm     cacheRmCmd     `cmd:"" help:"Delete the cached sessions of profiles."`
+	Clear  cacheClearCmd  `cmd:"" help:"Delete every cached session."`
+	Gc     cacheGcCmd     `cmd:"" help:"Delete cached sessions that expired a while ago."`
+	Status cacheStatusCmd `cmd:"" help:"Show whether the sessions of the last invocation were served from the cache, minted, or refreshed, and why."`
+}
+
+// cacheFile is a cached session, the profile it was cached for, and the
+// account, when it is named after it.
+type cacheFile struct {
+	name    string
+	profile string
+	account string
+	used    time.Time
+}
+
+// cacheFiles returns the sessions kept in store.
+func cacheFiles(ctx context.Context, store cacheStore) ([]cacheFile, error) {
+	sessions, err := store.list(ctx)
+	if err != nil {
+		return nil, err
+	}
+	files := make([]cacheFile, 0, len(sessions))
+	for _, session := range sessions {
+		f := cacheFile{name: session.name, profile: strings.TrimSuffix(session.name, ".json"), used: session.used}
+		// The name ends in the hash of the parameters, 16 hex digits, and
+		// then the account, when known, which is 12 digits.
+		if i := strings.LastIndex(f.profile, "-"); i > 0 && isAccountID(f.profile[i+1:]) {
+			f.profile, f.account = f.profile[:i], f.profile[i+1:]
+		}
+		if i := strings.LastIndex(f.profile, "-"); i > 0 {
+			f.profile = f.profile[:i]
+		}
+		files = append(files, f)
+	}
+	slices.SortFunc(files, func(a, b cacheFile) int { return strings.Compare(a.name, b.name) })
+	return files, nil
+}
+
+// cacheCommandStore returns the store of backend the cache commands read.
+func cacheCommandStore(backend, dir, secretToolPath string) cacheStore {
+	if backend == "keyring" {
+		return newKeyringCacheStore(dir, secretToolPath)
+	}
+	return &fileCacheStore{dir: dir}
+}
+
+// cacheLsCmd lists the cached sessions, decoding what each one
+// holds so users need not map the hashed file names back to profiles.
+type cacheLsCmd struct {
+	Account        []string `help:"Only list sessions of this AWS account (repeatable)." name:"account"`
+	CacheDir       string   `help:"Directory cached sessions are kept in. Defaults to op-aws-credential-process under $XDG_CACHE_HOME or ~/.cache." name:"cache-dir" env:"OP_AWS_HELPER_CACHE_DIR" type:"path"`
+	CacheBackend   string   `help:"Where sessions are cached: files in the cache directory, or the OS keyring (file, keyring)." name:"cache-backend" env:"OP_AWS_CACHE_BACKEND" enum:"file,keyring" default:"file"`
+	EncryptCache   bool     `help:"Decrypt sessions cached with --encrypt-cache." name:"encrypt-cache" env:"OP_AWS_ENCRYPT_CACHE"`
+	SecretToolPath string   `default:"secret-tool" help:"Path to secret-tool." name:"secret-tool-path"`
+
+	now func() time.Time
+}
+
+func (c *cacheLsCmd) Run() error {
+	dir, err := cacheDir(c.CacheDir)
+	if err != nil {
+		return err
+	}
+	var cipher *keyringCipher
+	if c.EncryptCache {
+		cipher = &keyringCipher{keyring: newKeyring(runtime.GOOS, c.SecretToolPath), account: cacheKeyAccount}
+	}
+	return c.list(context.Background(), cacheCommandStore(c.CacheBackend, dir, c.SecretToolPath), cipher, os.Stdout)
+}
+
+func (c *cacheLsCmd) list(ctx context.Context, store cacheStore, cipher *keyringCipher, w io.Writer) error {
+	files, err := cacheFiles(ctx, store)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	if c.now != nil {
+		now = c.now()
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	if _, err := fmt.Fprintln(tw, "PROFILE\tACCOUNT\tSESSION\tROLE\tSOURCE\tEXPIRES\tREMAINING"); err != nil {
+		return err
+	}
+	for _, f := range files {
+		data, err := store.load(ctx, f.name)
+		if err != nil {
+			return err
+		}
+		entry, encrypted, err := decodeCacheEntry(ctx, data, f.name, cipher)
+		account := cmp.Or(entry.AccountID, f.account)
+		if len(c.Account) > 0 && !slices.Contains(c.Account, account) {
+			continue
+		}
+		if err != nil || entry.Credentials == nil || entry.Credentials.Expiration == nil {
+			status := "unreadable"
+			if err != nil {
+				debugLog.Printf("%s: %v", f.name, err)
+			}
+			if encrypted && cipher == nil {
+				status = "encrypted"
+			}
+			if _, err := fmt.Fprintf(tw, "%s\t%s\t%s\t-\t-\t-\t-\n", f.profile, orDash(account), status); err != nil {
+				return err
+			}
+			continue
+		}
+
+		expires := aws.ToTime(entry.Credentials.Expiration)
+		remaining := "expired"
+		if d := expires.Sub(now); d > 0 {
+			remaining = d.Truncate(time.Second).String()
+		}
+		if _, err := fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			f.profile, orDash(account), cachedSessionKind(entry), orDash(entry.RoleArn), cachedSessionSource(entry),
+			expires.Local().Format(time.RFC3339), remaining); err != nil {
+			return err
+		}
+	}
+	return tw.Flush()
+}
+
+// cacheRmCmd deletes the sessions cached for profiles or accounts, whatever
+// item, role, or duration they were minted with.
+type cacheRmCmd struct {
+	Profiles       []string `arg:"" optional:"" help:"Profiles to delete the cached sessions of."`
+	Account        []string `help:"AWS account to delete the cached sessions of (repeatable). With profiles, only their sessions of the account are deleted." name:"account"`
+	CacheDir       string   `help:"Directory cached sessions are kept in. Defaults to op-aws-credential-process under $XDG_CACHE_HOME or ~/.cache." name:"cache-dir" env:"OP_AWS_HELPER_CACHE_DIR" type:"path"`
+	CacheBackend   string   `help:"Where sessions are cached: files in the cache directory, or the OS keyring (file, keyring)." name:"cache-backend" env:"OP_AWS_CACHE_BACKEND" enum:"file,keyring" default:"file"`
+	EncryptCache   bool     `help:"Decrypt sessions cached with --encrypt-cache to read their account." name:"encrypt-cache" env:"OP_AWS_ENCRYPT_CACHE"`
+	SecretToolPath string   `default:"secret-tool" help:"Path to secret-tool." name:"secret-tool-path"`
+}
+
+func (c *cacheRmCmd) Run() error {
+	if len(c.Profiles) == 0 && len(c.Account) == 0 {
+		return errors.New("give the profiles or --account to delete the cached sessions of")
+	}
+	dir, err := cacheDir(c.CacheDir)
+	if err != nil {
+		return err
+	}
+	var cipher *keyringCipher
+	if c.EncryptCache {
+		cipher = &keyringCipher{keyring: newKeyring(runtime.GOOS, c.SecretToolPath), account: cacheKeyAccount}
+	}
+	return removeCacheFiles(context.Background(), cacheCommandStore(c.CacheBackend, dir, c.SecretToolPath), c.Profiles, c.Account, cipher, os.Stdout)
+}
+
+// cacheClearCmd deletes every cached session. The offline mirror is kept in
+// the state directory, since it is the fallback for when the backend cannot
+// mint a new one.
+type cacheClearCmd struct {
+	CacheDir       string `help:"Directory cached sessions are kept in. Defaults to op-aws-credential-process under $XDG_CACHE_HOME or ~/.cache." name:"cache-dir" env:"OP_AWS_HELPER_CACHE_DIR" type:"path"`
+	CacheBackend   string `help:"Where sessions are cached: files in the cache directory, or the OS keyring (file, keyring)." name:"cache-backend" env:"OP_AWS_CACHE_BACKEND" enum:"file,keyring" default:"file"`
+	SecretToolPath string `default:"secret-tool" help:"Path to secret-tool." name:"secret-tool-path"`
+}
+
+func (c *cacheClearCmd) Run() error {
+	dir, err := cacheDir(c.CacheDir)
+	if err != nil {
+		return err
+	}
+	return removeCacheFiles(context.Background(), cacheCommandStore(c.CacheBackend, dir, c.SecretToolPath), nil, nil, nil, os.Stdout)
+}
+
+// removeCacheFiles deletes the sessions in store of profiles and of
+// accounts, either matching all when empty, and reports each one deleted.
+// The account of a session not named after it is read from the session,
+// decrypting it with cipher when set.
+func removeCacheFiles(ctx context.Context, store cacheStore, profiles, accounts []string, cipher *keyringCipher, w io.Writer) error {
+	files, err := cacheFiles(ctx, store)
+	if err != nil {
+		return err
+	}
+	removedProfiles, removedAccounts := make(map[string]bool), make(map[string]bool)
+	for _, f := range files {
+		if len(profiles) > 0 && !slices.Contains(profiles, f.profile) {
+			continue
+		}
+		account := f.account
+		if account == "" && len(accounts) > 0 {
+			if data, err := store.load(ctx, f.name); err == nil {
+				entry, _, _ := decodeCacheEntry(ctx, data, f.name, cipher)
+				account = entry.AccountID
+			}
+		}
+		if len(accounts) > 0 && !slices.Contains(accounts, account) {
+			continue
+		}
+		if err := store.delete(ctx, f.name); err != nil {
+			return err
+		}
+		removedProfiles[f.profile] = true
+		removedAccounts[account] = true
+		if _, err := fmt.Fprintf(w, "%s: removed %s\n", f.profile, f.name); err != nil {
+			return err
+		}
+	}
+	for _, profile := range profiles {
+		if !removedProfiles[profile] {
+			return fmt.Errorf("no cached sessions for profile %s", profile)
+		}
+	}
+	for _, account := range accounts {
+		if !removedAccounts[account] {
+			return fmt.Errorf("no cached sessions for account %s", account)
+		}
+	}
+	return nil
+}
+
+const (
+	// cacheGracePeriod is how long expired sessions are kept, so cache ls
+	// still shows what recently lapsed.
+	cacheGracePeriod = 24 * time.Hour
+	// maxSessionLifetime is the longest session STS issues, that of
+	// GetSessionToken and GetFederationToken.
+	maxSessionLifetime = 36 * time.Hour
+)
+
+// cacheGcCmd deletes expired sessions. Minting a session does the same, so
+// this is only needed to clean up right away.
+type cacheGcCmd struct {
+	Grace          time.Duration `default:"24h" help:"How long after expiration sessions are kept."`
+	CacheDir       string        `help:"Directory cached sessions are kept in. Defaults to op-aws-credential-process under $XDG_CACHE_HOME or ~/.cache." name:"cache-dir" env:"OP_AWS_HELPER_CACHE_DIR" type:"path"`
+	CacheBackend   string        `help:"Where sessions are cached: files in the cache directory, or the OS keyring (file, keyring)." name:"cache-backend" env:"OP_AWS_CACHE_BACKEND" enum:"file,keyring" default:"file"`
+	EncryptCache   bool          `help:"Decrypt sessions cached with --encrypt-cache to read their expiration." name:"encrypt-cache" env:"OP_AWS_ENCRYPT_CACHE"`
+	SecretToolPath string        `default:"secret-tool" help:"Path to secret-tool." name:"secret-tool-path"`
+}
+
+func (c *cacheGcCmd) Run() error {
+	dir, err := cacheDir(c.CacheDir)
+	if err != nil {
+		return err
+	}
+	var cipher *keyringCipher
+	if c.EncryptCache {
+		cipher = &keyringCipher{keyring: newKeyring(runtime.GOOS, c.SecretToolPath), account: cacheKeyAccount}
+	}
+	removed, err := purgeExpiredCache(context.Background(), cacheCommandStore(c.CacheBackend, dir, c.SecretToolPath), cipher, time.Now(), c.Grace)
+	for _, f := range removed {
+		if _, err := fmt.Fprintf(os.Stdout, "%s: removed %s\n", f.profile, f.name); err != nil {
+			return err
+		}
+	}
+	return err
+}
+
+// purgeExpiredCache deletes the sessions in store that expired more than
+// grace before now, and returns those deleted. A session that cannot be
+// decoded, e.g. one encrypted when cipher is nil, is judged by when it was
+// last saved or used, against the longest session STS issues, and kept when
+// the store does not record that.
+func purgeExpiredCache(ctx context.Context, store cacheStore, cipher *keyringCipher, now time.Time, grace time.Duration) ([]cacheFile, error) {
+	files, err := cacheFiles(ctx, store)
+	if err != nil {
+		return nil, err
+	}
+	var removed []cacheFile
+	for _, f := range files {
+		var expires time.Time
+		if !f.used.IsZero() {
+			expires = f.used.Add(maxSessionLifetime)
+		}
+		if data, err := store.load(ctx, f.name); err == nil {
+			entry, _, err := decodeCacheEntry(ctx, data, f.name, cipher)
+			if err == nil && entry.Credentials != nil && entry.Credentials.Expiration != nil {
+				expires = *entry.Credentials.Expiration
+			}
+		}
+		if expires.IsZero() || !now.After(expires.Add(grace)) {
+			continue
+		}
+		if err := store.delete(ctx, f.name); err != nil {
+			return removed, err
+		}
+		removed = append(removed, f)
+	}
+	return removed, nil
+}
+
+// evictCache deletes the least recently used sessions in store until at
+// most limit are left, and returns those deleted.
+func evictCache(ctx context.Context, store cacheStore, limit int) ([]cacheFile, error) {
+	files, err := cacheFiles(ctx, store)
+	if err != nil || len(files) <= limit {
+		return nil, err
+	}
+	slices.SortStableFunc(files, func(a, b cacheFile) int {
+		return a.used.Compare(b.used)
+	})
+
+	var removed []cacheFile
+	for _, f := range files[:len(files)-limit] {
+		if err := store.delete(ctx, f.name); err != nil {
+			return removed, err
+		}
+		removed = append(removed, f)
+	}
+	return removed, nil
+}
+
+// cachedSessionKind names the STS call that minted the session.
+func cachedSessionKind(entry cachedEntry) string {
+	switch {
+	case entry.SessionType != "":
+		kind, _, _ := strings.Cut(entry.SessionType, ":")
+		return kind
+	case entry.RoleArn != "":
+		return "assume-role"
+	default:
+		return "session-token"
+	}
+}
+
+// cachedSessionSource names where the long-term key pair behind the session
+// was read from.
+func cachedSessionSource(entry cachedEntry) string {
+	if entry.CredentialCommand != "" {
+		return "command"
+	}
+	if entry.AccessKeyIDRef != "" {
+		return entry.AccessKeyIDRef
+	}
+	if entry.Vault == "" && entry.Item == "" {
+		return "-"
+	}
+	return entry.Vault + "/" + entry.Item
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCacheLsCmd_List(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	now := time.Now()
+	write := func(p *CachedSessionProvider, entry cachedEntry) {
+		t.Helper()
+		p.CacheDir = dir
+		if err := p.writeCache(ctx, entry); err != nil {
+			t.Fatalf("failed to write cache: %v", err)
+		}
+	}
+	item := defaultOpAwsItem()
+	write(&CachedSessionProvider{Profile: "my-dev", OpAwsItem: item}, cachedEntry{
+		Credentials: newStsCreds("KEY", "SECRET", "TOKEN", now.Add(2*time.Hour)),
+		Vault:       item.Vault,
+		Item:        item.Item,
+	})
+	write(&CachedSessionProvider{Profile: "prod", RoleArn: "arn:aws:iam::222222222222:role/Admin"}, cachedEntry{
+		Credentials:       newStsCreds("KEY", "SECRET", "TOKEN", now.Add(-time.Minute)),
+		RoleArn:           "arn:aws:iam::222222222222:role/Admin",
+		CredentialCommand: "pass aws",
+	})
+	write(&CachedSessionProvider{Profile: "sealed", Cipher: &keyringCipher{keyring: newFakeKeyring(), account: cacheKeyAccount}}, cachedEntry{
+		Credentials: newStsCreds("KEY", "SECRET", "TOKEN", now.Add(time.Hour)),
+	})
+	// Neither the lock files nor the offline mirror are sessions.
+	if err := os.WriteFile(filepath.Join(dir, "prod-0123456789abcdef.json.lock"), nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "mirror"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	cmd := &cacheLsCmd{now: func() time.Time { return now }}
+	if err := cmd.list(ctx, &fileCacheStore{dir: dir}, nil, &out); err != nil {
+		t.Fatalf("list() error = %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("list() printed %d lines, want 4:\n%s", len(lines), out.String())
+	}
+	for i, want := range [][]string{
+		{"PROFILE", "ACCOUNT", "SESSION", "ROLE", "SOURCE", "EXPIRES", "REMAINING"},
+		{"my-dev", "session-token", "-", item.Vault + "/" + item.Item, "2h0m0s"},
+		{"prod", "222222222222", "assume-role", "arn:aws:iam::222222222222:role/Admin", "command", "expired"},
+		{"sealed", "encrypted"},
+	} {
+		for _, field := range want {
+			if !strings.Contains(lines[i], field) {
+				t.Errorf("line %d = %q, want it to contain %q", i, lines[i], field)
+			}
+		}
+	}
+}
+
+func TestCacheLsCmd_ListAccount(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	now := time.Now()
+	for _, p := range []*CachedSessionProvider{
+		{CacheDir: dir, Profile: "dev", RoleArn: "arn:aws:iam::111111111111:role/Dev"},
+		{CacheDir: dir, Profile: "prod", RoleArn: "arn:aws:iam::222222222222:role/Admin"},
+	} {
+		if err := p.writeCache(ctx, cachedEntry{Credentials: newStsCreds("KEY", "SECRET", "TOKEN", now.Add(time.Hour)), RoleArn: p.RoleArn}); err != nil {
+			t.Fatalf("failed to write cache: %v", err)
+		}
+	}
+	// The account of a session-token session is only in the session.
+	base := &CachedSessionProvider{CacheDir: dir, Profile: "base"}
+	if err := base.writeCache(ctx, cachedEntry{Credentials: newStsCreds("KEY", "SECRET", "TOKEN", now.Add(time.Hour)), AccountID: "222222222222"}); err != nil {
+		t.Fatalf("failed to write cache: %v", err)
+	}
+
+	var out bytes.Buffer
+	cmd := &cacheLsCmd{Account: []string{"222222222222"}, now: func() time.Time { return now }}
+	if err := cmd.list(ctx, &fileCacheStore{dir: dir}, nil, &out); err != nil {
+		t.Fatalf("list() error = %v", err)
+	}
+	if strings.Contains(out.String(), "dev") || !strings.Contains(out.String(), "prod") || !strings.Contains(out.String(), "base") {
+		t.Errorf("list() = %q, want only the sessions of 222222222222", out.String())
+	}
+}
+
+func TestCachedSessionKind(t *testing.T) {
+	tests := []struct {
+		entry cachedEntry
+		want  string
+	}{
+		{cachedEntry{}, "session-token"},
+		{cachedEntry{RoleArn: "arn:aws:iam::222222222222:role/Admin"}, "assume-role"},
+		{cachedEntry{SessionType: "federation:alice"}, "federation"},
+		{cachedEntry{SessionType: "web-identity", RoleArn: "arn:aws:iam::222222222222:role/Web"}, "web-identity"},
+		{cachedEntry{SessionType: "root:111111111111:arn:aws:iam::aws:policy/root-task/IAMAuditRootUserCredentials"}, "root"},
+	}
+	for _, tt := range tests {
+		if got := cachedSessionKind(tt.entry); got != tt.want {
+			t.Errorf("cachedSessionKind(%+v) = %q, want %q", tt.entry, got, tt.want)
+		}
+	}
+}
+
+func TestRemoveCacheFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"dev-0123456789abcdef.json", "dev-fedcba9876543210.json", "my-prod-0123456789abcdef.json", "dev-0123456789abcdef.json.lock"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("{}"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var out bytes.Buffer
+	if err := removeCacheFiles(context.Background(), &fileCacheStore{dir: dir}, []string{"dev"}, nil, nil, &out); err != nil {
+		t.Fatalf("removeCacheFiles() error = %v", err)
+	}
+	if got := strings.Count(out.String(), "removed"); got != 2 {
+		t.Errorf("removeCacheFiles() reported %d removals, want 2:\n%s", got, out.String())
+	}
+	files, err := cacheFiles(context.Background(), &fileCacheStore{dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].profile != "my-prod" {
+		t.Errorf("cacheFiles() = %+v, want only my-prod left", files)
+	}
+
+	if err := removeCacheFiles(context.Background(), &fileCacheStore{dir: dir}, []string{"dev"}, nil, nil, &out); err == nil {
+		t.Error("removeCacheFiles() error = nil, want error for a profile without cached sessions")
+	}
+
+	if err := removeCacheFiles(context.Background(), &fileCacheStore{dir: dir}, nil, nil, nil, &out); err != nil {
+		t.Fatalf("removeCacheFiles() error = %v", err)
+	}
+	if files, _ := cacheFiles(context.Background(), &fileCacheStore{dir: dir}); len(files) != 0 {
+		t.Errorf("cacheFiles() = %+v, want none left", files)
+	}
+}
+
+func TestCacheFiles(t *testing.T) {
+	dir := t.TempDir()
+	want := map[string]cacheFile{
+		"dev-0123456789abcdef.json":                            {profile: "dev"},
+		"dev-0123456789abcdef-111111111111.json":               {profile: "dev", account: "111111111111"},
+		"prod-123456789012-0123456789abcdef.json":              {profile: "prod-123456789012"},
+		"prod-123456789012-0123456789abcdef-222222222222.json": {profile: "prod-123456789012", account: "222222222222"},
+	}
+	for name := range want {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("{}"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files, err := cacheFiles(context.Background(), &fileCacheStore{dir: dir})
+	if err != nil {
+		t.Fatalf("cacheFiles() error = %v", err)
+	}
+	if len(files) != len(want) {
+		t.Fatalf("cacheFiles() = %+v, want %d files", files, len(want))
+	}
+	for _, f := range files {
+		if w := want[f.name]; f.profile != w.profile || f.account != w.account {
+			t.Errorf("%s: profile, account = %q, %q, want %q, %q", f.name, f.profile, f.account, w.profile, w.account)
+		}
+	}
+}
+
+func TestRemoveCacheFiles_Account(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	files := map[string]string{
+		"dev-0123456789abcdef-111111111111.json":  "{}",
+		"prod-0123456789abcdef-222222222222.json": "{}",
+		"base-fedcba9876543210.json":              `{"account_id":"222222222222"}`,
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var out bytes.Buffer
+	if err := removeCacheFiles(ctx, &fileCacheStore{dir: dir}, nil, []string{"222222222222"}, nil, &out); err != nil {
+		t.Fatalf("removeCacheFiles() error = %v", err)
+	}
+	left, err := cacheFiles(context.Background(), &fileCacheStore{dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(left) != 1 || left[0].profile != "dev" || left[0].account != "111111111111" {
+		t.Errorf("cacheFiles() = %+v, want only dev of 111111111111 left", left)
+	}
+	if err := removeCacheFiles(ctx, &fileCacheStore{dir: dir}, []string{"dev"}, []string{"222222222222"}, nil, &out); err == nil {
+		t.Error("removeCacheFiles() error = nil, want error for a profile without sessions of the account")
+	}
+}
+
+func TestPurgeExpiredCache(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	now := time.Now()
+	write := func(p *CachedSessionProvider, expires time.Time) string {
+		t.Helper()
+		p.CacheDir = dir
+		if err := p.writeCache(ctx, cachedEntry{Credentials: newStsCreds("KEY", "SECRET", "TOKEN", expires)}); err != nil {
+			t.Fatalf("failed to write cache: %v", err)
+		}
+		return p.cachePath()
+	}
+	write(&CachedSessionProvider{Profile: "fresh"}, now.Add(time.Hour))
+	write(&CachedSessionProvider{Profile: "lapsed"}, now.Add(-time.Hour))
+	stale := write(&CachedSessionProvider{Profile: "stale"}, now.Add(-48*time.Hour))
+	if err := os.WriteFile(stale+".lock", nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	// Without the key, encrypted sessions are judged by when they were
+	// written.
+	ring := newFakeKeyring()
+	write(&CachedSessionProvider{Profile: "sealed", Cipher: &keyringCipher{keyring: ring, account: cacheKeyAccount}}, now.Add(-48*time.Hour))
+	old := write(&CachedSessionProvider{Profile: "old-sealed", Cipher: &keyringCipher{keyring: ring, account: cacheKeyAccount}}, now.Add(-48*time.Hour))
+	if err := os.Chtimes(old, now.Add(-72*time.Hour), now.Add(-72*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	removed, err := purgeExpiredCache(ctx, &fileCacheStore{dir: dir}, nil, now, cacheGracePeriod)
+	if err != nil {
+		t.Fatalf("purgeExpiredCache() error = %v", err)
+	}
+	var got []string
+	for _, f := range removed {
+		got = append(got, f.profile)
+	}
+	if strings.Join(got, ",") != "old-sealed,stale" {
+		t.Errorf("purgeExpiredCache() removed %v, want [old-sealed stale]", got)
+	}
+	if _, err := os.Stat(stale + ".lock"); !os.IsNotExist(err) {
+		t.Errorf("lock file of a purged session exists (err = %v)", err)
+	}
+	files, err := cacheFiles(context.Background(), &fileCacheStore{dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 3 {
+		t.Errorf("cacheFiles() = %+v, want fresh, lapsed, and sealed left", files)
+	}
+}
+
+func TestEvictCache(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	for i, name := range []string{"a-0123456789abcdef.json", "b-0123456789abcdef.json", "c-0123456789abcdef.json", "d-0123456789abcdef.json"} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte("{}"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path+".lock", nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+		used := now.Add(time.Duration(i-4) * time.Hour)
+		if err := os.Chtimes(path, used, used); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Loading a session makes it the most recently used.
+	if _, err := (&fileCacheStore{dir: dir, touch: true}).load(context.Background(), "a-0123456789abcdef.json"); err != nil {
+		t.Fatal(err)
+	}
+
+	removed, err := evictCache(context.Background(), &fileCacheStore{dir: dir}, 2)
+	if err != nil {
+		t.Fatalf("evictCache() error = %v", err)
+	}
+	var got []string
+	for _, f := range removed {
+		got = append(got, f.profile)
+	}
+	if strings.Join(got, ",") != "b,c" {
+		t.Errorf("evictCache() removed %v, want [b c]", got)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "b-0123456789abcdef.json.lock")); !os.IsNotExist(err) {
+		t.Errorf("lock file of an evicted session exists (err = %v)", err)
+	}
+
+	if removed, err := evictCache(context.Background(), &fileCacheStore{dir: dir}, 2); err != nil || len(removed) != 0 {
+		t.Errorf("evictCache() = %v, %v, want nothing removed within the limit", removed, err)
+	}
+}
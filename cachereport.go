@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// How a session was obtained.
+const (
+	// sessionCached is a session served from the cache.
+	sessionCached = "cached"
+	// sessionMinted is a new session minted when none was cached.
+	sessionMinted = "minted"
+	// sessionRefreshed is a new session minted in place of a cached one.
+	sessionRefreshed = "refreshed"
+)
+
+// lastRunFile is the file in the state directory the last invocation is
+// reported in.
+const lastRunFile = "last-run.json"
+
+// cacheEvent is how one session of an invocation was obtained.
+type cacheEvent struct {
+	Profile   string `json:"profile"`
+	Session   string `json:"session"`
+	RoleArn   string `json:"role_arn,omitempty"`
+	Outcome   string `json:"outcome"`
+	Reason    string `json:"reason,omitempty"`
+	CacheFile string `json:"cache_file"`
+}
+
+// lastRun is the report of an invocation.
+type lastRun struct {
+	At       time.Time    `json:"at"`
+	Sessions []cacheEvent `json:"sessions"`
+}
+
+// cacheReport records how each session of an invocation was obtained in a
+// file that cache status prints, so it can be told afterwards why the
+// invocation prompted for MFA. The file is rewritten as each session is
+// obtained, so it is complete even when a later one fails.
+type cacheReport struct {
+	path string
+
+	mu  sync.Mutex
+	run lastRun
+}
+
+func newCacheReport(path string, now time.Time) *cacheReport {
+	return &cacheReport{path: path, run: lastRun{At: now.UTC()}}
+}
+
+func (r *cacheReport) add(event cacheEvent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.run.Sessions = append(r.run.Sessions, event)
+	data, err := json.Marshal(r.run)
+	if err != nil {
+		debugLog.Printf("failed to report the run: %v", err)
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(r.path), 0700); err != nil {
+		debugLog.Printf("failed to report the run: %v", err)
+		return
+	}
+	if err := writeFileAtomic(r.path, data, 0600); err != nil {
+		debugLog.Printf("failed to report the run: %v", err)
+	}
+}
+
+// event describes obtaining the session of c with outcome for reason.
+func (c *CachedSessionProvider) event(outcome, reason string) cacheEvent {
+	return cacheEvent{
+		Profile:   c.Profile,
+		Session:   cachedSessionKind(cachedEntry{SessionType: c.SessionType, RoleArn: c.RoleArn}),
+		RoleArn:   c.RoleArn,
+		Outcome:   outcome,
+		Reason:    reason,
+		CacheFile: c.cachePath(),
+	}
+}
+
+// record logs event and adds it to c.Report.
+func (c *CachedSessionProvider) record(event cacheEvent) {
+	if event.Reason == "" {
+		debugLog.Printf("%s: %s session %s from %s", event.Profile, event.Session, event.Outcome, event.CacheFile)
+	} else {
+		debugLog.Printf("%s: %s session %s, as %s (%s)", event.Profile, event.Session, event.Outcome, event.Reason, event.CacheFile)
+	}
+	if c.Report != nil {
+		c.Report.add(event)
+	}
+}
+
+// cacheStatusCmd prints how the sessions of the last invocation were
+// obtained.
+type cacheStatusCmd struct {
+	StateDir string `help:"Directory the last invocation was reported in. Defaults to op-aws-credential-process under $XDG_STATE_HOME or ~/.local/state." name:"state-dir" env:"OP_AWS_HELPER_STATE_DIR" type:"path"`
+}
+
+func (c *cacheStatusCmd) Run() error {
+	dir, err := stateDir(c.StateDir)
+	if err != nil {
+		return err
+	}
+	return printLastRun(filepath.Join(dir, lastRunFile), time.Now(), os.Stdout)
+}
+
+func printLastRun(path string, now time.Time, w io.Writer) error {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		_, err := fmt.Fprintln(w, "No invocation has been reported yet.")
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	var run lastRun
+	if err := json.Unmarshal(data, &run); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	if _, err := fmt.Fprintf(w, "Last run at %s (%s ago)\n\n", run.At.Local().Format(time.RFC3339), now.Sub(run.At).Truncate(time.Second)); err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	if _, err := fmt.Fprintln(tw, "PROFILE\tSESSION\tROLE\tOUTCOME\tREASON\tCACHE FILE"); err != nil {
+		return err
+	}
+	for _, e := range run.Sessions {
+		if _, err := fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			e.Profile, e.Session, orDash(e.RoleArn), e.Outcome, orDash(e.Reason), e.CacheFile); err != nil {
+			return err
+		}
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCachedSessionProvider_Report(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2026, 10, 15, 9, 0, 0, 0, time.UTC)
+	path := filepath.Join(t.TempDir(), lastRunFile)
+	inner := &fakeStsSessionProvider{creds: newStsCreds("KEY", "SECRET", "TOKEN", now.Add(time.Hour))}
+	cacheDir := t.TempDir()
+	retrieve := func(forceRefresh bool) []cacheEvent {
+		t.Helper()
+		provider := &CachedSessionProvider{
+			SessionProvider: inner,
+			CacheDir:        cacheDir,
+			Profile:         "admin",
+			ExpiryWindow:    5 * time.Minute,
+			OpAwsItem:       defaultOpAwsItem(),
+			RoleArn:         "arn:aws:iam::123456789012:role/Admin",
+			ForceRefresh:    forceRefresh,
+			Now:             func() time.Time { return now },
+			Report:          newCacheReport(path, now),
+		}
+		if _, err := provider.RetrieveStsCredentials(ctx); err != nil {
+			t.Fatalf("RetrieveStsCredentials() error = %v", err)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var run lastRun
+		if err := json.Unmarshal(data, &run); err != nil {
+			t.Fatal(err)
+		}
+		if len(run.Sessions) != 1 || run.Sessions[0].CacheFile != provider.cachePath() || run.Sessions[0].Session != "assume-role" {
+			t.Fatalf("reported %+v, want the one session and its cache file", run.Sessions)
+		}
+		return run.Sessions
+	}
+
+	tests := []struct {
+		name         string
+		advance      time.Duration
+		forceRefresh bool
+		outcome      string
+		reason       string
+	}{
+		{name: "first run", outcome: sessionMinted, reason: "no session was cached"},
+		{name: "cache hit", advance: 10 * time.Minute, outcome: sessionCached},
+		{name: "inside the margin", advance: 48 * time.Minute, outcome: sessionRefreshed, reason: "within the refresh margin"},
+		{name: "force refresh", outcome: sessionRefreshed, forceRefresh: true, reason: "--force-refresh"},
+		{name: "expired", advance: 2 * time.Hour, outcome: sessionRefreshed, reason: "it expired at"},
+	}
+	for _, tt := range tests {
+		now = now.Add(tt.advance)
+		inner.creds = newStsCreds("KEY", "SECRET", "TOKEN", now.Add(time.Hour))
+		got := retrieve(tt.forceRefresh)[0]
+		if got.Outcome != tt.outcome || !strings.Contains(got.Reason, tt.reason) {
+			t.Errorf("%s: reported %s (%s), want %s (%s)", tt.name, got.Outcome, got.Reason, tt.outcome, tt.reason)
+		}
+	}
+}
+
+func TestPrintLastRun(t *testing.T) {
+	path := filepath.Join(t.TempDir(), lastRunFile)
+	var out bytes.Buffer
+	if err := printLastRun(path, time.Now(), &out); err != nil {
+		t.Fatalf("printLastRun() error = %v", err)
+	}
+	if !strings.Contains(out.String(), "No invocation") {
+		t.Errorf("printLastRun() = %q, want no invocation reported", out.String())
+	}
+
+	at := time.Date(2026, 10, 15, 9, 0, 0, 0, time.UTC)
+	report := newCacheReport(path, at)
+	report.add(cacheEvent{Profile: "base", Session: "session-token", Outcome: sessionCached, CacheFile: "/cache/base-1.json"})
+	report.add(cacheEvent{Profile: "admin", Session: "assume-role", RoleArn: "arn:aws:iam::123456789012:role/Admin", Outcome: sessionRefreshed, Reason: "it expired at 2026-10-15T08:59:00Z", CacheFile: "/cache/admin-2.json"})
+	out.Reset()
+	if err := printLastRun(path, at.Add(3*time.Minute), &out); err != nil {
+		t.Fatalf("printLastRun() error = %v", err)
+	}
+	for _, want := range []string{
+		"(3m0s ago)",
+		"PROFILE  SESSION        ROLE",
+		"base     session-token  -",
+		"admin    assume-role    arn:aws:iam::123456789012:role/Admin  refreshed  it expired at 2026-10-15T08:59:00Z  /cache/admin-2.json",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("printLastRun() = %q, want it to contain %q", out.String(), want)
+		}
+	}
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cacheStore keeps cached sessions by the name of their cache file.
+type cacheStore interface {
+	// load returns the session stored as name, or an error matching
+	// os.ErrNotExist when there is none.
+	load(ctx context.Context, name string) ([]byte, error)
+	save(ctx context.Context, name string, data []byte) error
+	// list returns the sessions stored, for cache ls, rm, and gc.
+	list(ctx context.Context) ([]storedSession, error)
+	// delete deletes the session stored as name, if any.
+	delete(ctx context.Context, name string) error
+}
+
+// storedSession is a session kept in a cacheStore.
+type storedSession struct {
+	name string
+	// used is when the session was last saved or used, or zero when the
+	// store does not record it.
+	used time.Time
+}
+
+// fileCacheStore keeps sessions as files in dir.
+type fileCacheStore struct {
+	dir string
+	// touch makes loading a session touch its file, so the modification
+	// times order the sessions by last use for evictCache. It is left
+	// unset where sessions are read without being used, as by cache ls.
+	touch bool
+}
+
+func (s *fileCacheStore) load(ctx context.Context, name string) ([]byte, error) {
+	path := filepath.Join(s.dir, name)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if !s.touch {
+		return data, nil
+	}
+	now := time.Now()
+	if err := os.Chtimes(path, now, now); err != nil {
+		debugLog.Printf("failed to touch %s: %v", path, err)
+	}
+	return data, nil
+}
+
+func (s *fileCacheStore) save(ctx context.Context, name string, data []byte) error {
+	if err := os.MkdirAll(s.dir, 0700); err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(s.dir, name), data, 0600)
+}
+
+// list returns the cache files in dir, which may not exist, with their
+// modification times.
+func (s *fileCacheStore) list(ctx context.Context) ([]storedSession, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	var sessions []storedSession
+	for _, e := range entries {
+		name := e.Name()
+		// Temporary files being renamed into place start with a dot.
+		if e.IsDir() || strings.HasPrefix(name, ".") || filepath.Ext(name) != ".json" {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		sessions = append(sessions, storedSession{name: name, used: info.ModTime()})
+	}
+	return sessions, nil
+}
+
+// delete deletes the cache file name along with its lock file.
+func (s *fileCacheStore) delete(ctx context.Context, name string) error {
+	path := filepath.Join(s.dir, name)
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	_ = os.Remove(path + ".lock")
+	return nil
+}
+
+// keyringChunkSize is the most bytes of a session kept in one keyring entry.
+// Encoded in base64 and then UTF-16, it stays within the 2560-byte limit of
+// the Windows Credential Manager, which a session token alone can exceed.
+const keyringChunkSize = 900
+
+// keyringCacheStore keeps sessions in the OS keyring rather than on disk.
+// A session is split into chunks under "session/<name>/<i>", and
+// "session/<name>" holds the number of chunks. The keyrings cannot be
+// searched alike, so "sessions" indexes the names stored with when each was
+// saved, under lockPath while it is updated.
+type keyringCacheStore struct {
+	keyring  keyring
+	lockPath string
+}
+
+// keyringIndexLock is the file in the cache directory locked while the
+// index of a keyringCacheStore is updated.
+const keyringIndexLock = "keyring-sessions.lock"
+
+// newKeyringCacheStore returns the store of --cache-backend keyring, locking
+// its index in the cache directory dir.
+func newKeyringCacheStore(dir, secretToolPath string) *keyringCacheStore {
+	return &keyringCacheStore{
+		keyring:  newKeyring(runtime.GOOS, secretToolPath),
+		lockPath: filepath.Join(dir, keyringIndexLock),
+	}
+}
+
+// keyringIndexAccount is the account of the index of a keyringCacheStore.
+const keyringIndexAccount = "sessions"
+
+func (s *keyringCacheStore) load(ctx context.Context, name string) ([]byte, error) {
+	return s.loadChunks(ctx, "session/"+name)
+}
+
+func (s *keyringCacheStore) save(ctx context.Context, name string, data []byte) error {
+	if err := s.saveChunks(ctx, "session/"+name, data); err != nil {
+		return err
+	}
+	return s.updateIndex(ctx, func(index map[string]time.Time) {
+		index[name] = time.Now().UTC()
+	})
+}
+
+func (s *keyringCacheStore) list(ctx context.Context) ([]storedSession, error) {
+	index, err := s.index(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sessions := make([]storedSession, 0, len(index))
+	for name, saved := range index {
+		sessions = append(sessions, storedSession{name: name, used: saved})
+	}
+	return sessions, nil
+}
+
+func (s *keyringCacheStore) delete(ctx context.Context, name string) error {
+	if err := s.deleteChunks(ctx, "session/"+name); err != nil {
+		return err
+	}
+	return s.updateIndex(ctx, func(index map[string]time.Time) {
+		delete(index, name)
+	})
+}
+
+// index returns the names of the sessions stored, with when each was saved.
+func (s *keyringCacheStore) index(ctx context.Context) (map[string]time.Time, error) {
+	index := make(map[string]time.Time)
+	data, err := s.loadChunks(ctx, keyringIndexAccount)
+	if errors.Is(err, os.ErrNotExist) {
+		return index, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, fmt.Errorf("invalid index of cached sessions: %w", err)
+	}
+	return index, nil
+}
+
+// updateIndex applies update to the index, holding lockPath so concurrent
+// invocations do not drop each other's sessions from it.
+func (s *keyringCacheStore) updateIndex(ctx context.Context, update func(map[string]time.Time)) error {
+	if s.lockPath != "" {
+		unlock, err := lockFile(ctx, s.lockPath)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+	}
+	index, err := s.index(ctx)
+	if err != nil {
+		return err
+	}
+	update(index)
+	data, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	return s.saveChunks(ctx, keyringIndexAccount, data)
+}
+
+func (s *keyringCacheStore) loadChunks(ctx context.Context, account string) ([]byte, error) {
+	head, ok, err := s.keyring.secret(ctx, account)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	n, err := strconv.Atoi(string(head))
+	if err != nil {
+		return nil, fmt.Errorf("invalid cached session %s: %w", account, err)
+	}
+	var data []byte
+	for i := range n {
+		chunk, ok, err := s.keyring.secret(ctx, account+"/"+strconv.Itoa(i))
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, os.ErrNotExist
+		}
+		data = append(data, chunk...)
+	}
+	return data, nil
+}
+
+func (s *keyringCacheStore) saveChunks(ctx context.Context, account string, data []byte) error {
+	n := 0
+	for ; len(data) > 0; n++ {
+		chunk := data[:min(len(data), keyringChunkSize)]
+		if err := s.keyring.storeSecret(ctx, account+"/"+strconv.Itoa(n), chunk); err != nil {
+			return err
+		}
+		data = data[len(chunk):]
+	}
+	// The count goes last, so a session is never read before all its
+	// chunks are stored.
+	return s.keyring.storeSecret(ctx, account, []byte(strconv.Itoa(n)))
+}
+
+// deleteChunks deletes the count first, so a session is never read with
+// some of its chunks gone.
+func (s *keyringCacheStore) deleteChunks(ctx context.Context, account string) error {
+	head, ok, err := s.keyring.secret(ctx, account)
+	if err != nil || !ok {
+		return err
+	}
+	if err := s.keyring.deleteSecret(ctx, account); err != nil {
+		return err
+	}
+	n, _ := strconv.Atoi(string(head))
+	for i := range n {
+		if err := s.keyring.deleteSecret(ctx, account+"/"+strconv.Itoa(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+func TestFileCacheStore(t *testing.T) {
+	ctx := context.Background()
+	dir := filepath.Join(t.TempDir(), "op-aws-credential-process")
+	store := &fileCacheStore{dir: dir}
+	if _, err := store.load(ctx, "dev.json"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("load() error = %v, want os.ErrNotExist", err)
+	}
+	if err := store.save(ctx, "dev.json", []byte("session")); err != nil {
+		t.Fatalf("save() error = %v", err)
+	}
+	got, err := store.load(ctx, "dev.json")
+	if err != nil {
+		t.Fatalf("load() error = %v", err)
+	}
+	if string(got) != "session" {
+		t.Errorf("load() = %q, want %q", got, "session")
+	}
+}
+
+func TestKeyringCacheStore(t *testing.T) {
+	ctx := context.Background()
+	ring := newFakeKeyring()
+	write := ring.write
+	ring.write = func(target, user, password string) error {
+		// The Credential Manager stores at most 2560 bytes of UTF-16.
+		if 2*len(password) > 2560 {
+			t.Errorf("stored %d bytes under %s, more than the Credential Manager allows", 2*len(password), target)
+		}
+		return write(target, user, password)
+	}
+	store := &keyringCacheStore{keyring: ring}
+
+	if _, err := store.load(ctx, "dev.json"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("load() error = %v, want os.ErrNotExist", err)
+	}
+	for _, size := range []int{1, keyringChunkSize, 3*keyringChunkSize + 1} {
+		data := bytes.Repeat([]byte("x"), size)
+		if err := store.save(ctx, "dev.json", data); err != nil {
+			t.Fatalf("save() error = %v", err)
+		}
+		got, err := store.load(ctx, "dev.json")
+		if err != nil {
+			t.Fatalf("load() error = %v", err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("load() returned %d bytes, want %d", len(got), size)
+		}
+	}
+
+	if err := store.save(ctx, "prod.json", []byte("session")); err != nil {
+		t.Fatalf("save() error = %v", err)
+	}
+	if err := store.delete(ctx, "dev.json"); err != nil {
+		t.Fatalf("delete() error = %v", err)
+	}
+	if _, err := store.load(ctx, "dev.json"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("load() error = %v, want os.ErrNotExist after delete()", err)
+	}
+	sessions, err := store.list(ctx)
+	if err != nil {
+		t.Fatalf("list() error = %v", err)
+	}
+	if len(sessions) != 1 || sessions[0].name != "prod.json" || sessions[0].used.IsZero() {
+		t.Errorf("list() = %+v, want only prod.json with when it was saved", sessions)
+	}
+}
+
+func TestKeyringCacheStore_RemoveCacheFiles(t *testing.T) {
+	ctx := context.Background()
+	store := &keyringCacheStore{keyring: newFakeKeyring(), lockPath: filepath.Join(t.TempDir(), keyringIndexLock)}
+	for _, name := range []string{"dev-0123456789abcdef.json", "prod-0123456789abcdef.json"} {
+		if err := store.save(ctx, name, []byte("{}")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var out bytes.Buffer
+	if err := removeCacheFiles(ctx, store, []string{"dev"}, nil, nil, &out); err != nil {
+		t.Fatalf("removeCacheFiles() error = %v", err)
+	}
+	files, err := cacheFiles(ctx, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].profile != "prod" {
+		t.Errorf("cacheFiles() = %+v, want only prod left in the keyring", files)
+	}
+	if _, err := store.load(ctx, "dev-0123456789abcdef.json"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("load() error = %v, want the session of dev deleted", err)
+	}
+}
+
+func TestCachedSessionProvider_Store(t *testing.T) {
+	ctx := context.Background()
+	exp := time.Now().Add(1 * time.Hour)
+	inner := &fakeStsSessionProvider{creds: newStsCreds("FRESH_KEY", "FRESH_SECRET", "FRESH_TOKEN", exp)}
+	provider := &CachedSessionProvider{
+		SessionProvider: inner,
+		CacheDir:        t.TempDir(),
+		Profile:         "test-profile",
+		ExpiryWindow:    5 * time.Minute,
+		OpAwsItem:       defaultOpAwsItem(),
+		Store:           &keyringCacheStore{keyring: newFakeKeyring()},
+	}
+	for range 2 {
+		creds, err := provider.RetrieveStsCredentials(ctx)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got := aws.ToString(creds.AccessKeyId); got != "FRESH_KEY" {
+			t.Errorf("AccessKeyId = %q, want %q", got, "FRESH_KEY")
+		}
+	}
+	if inner.called != 1 {
+		t.Errorf("inner.called = %d, want 1", inner.called)
+	}
+	if _, err := os.Stat(provider.cachePath()); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("cache file exists (err = %v), want the session only in the keyring", err)
+	}
+}
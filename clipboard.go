@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// errClipboardDeclined is returned when the user does not confirm the code
+// found in the clipboard.
+var errClipboardDeclined = errors.New("MFA code from the clipboard was declined")
+
+// clipboardCommand returns the command that prints the clipboard on goos, or
+// nil when no clipboard tool is available.
+func clipboardCommand(goos string) []string {
+	switch goos {
+	case "darwin":
+		return []string{"pbpaste"}
+	case "windows":
+		return []string{"powershell", "-NoProfile", "-Command", "Get-Clipboard"}
+	}
+	candidates := [][]string{
+		{"xclip", "-selection", "clipboard", "-o"},
+		{"xsel", "--clipboard", "--output"},
+	}
+	if os.Getenv("WAYLAND_DISPLAY") != "" {
+		candidates = append([][]string{{"wl-paste", "--no-newline"}}, candidates...)
+	}
+	for _, c := range candidates {
+		if _, err := exec.LookPath(c[0]); err == nil {
+			return c
+		}
+	}
+	return nil
+}
+
+// clipboardOTPSource reads the MFA code from the system clipboard, for codes
+// copied from another device or password manager. The code is used only after
+// the user confirms it on the prompt.
+type clipboardOTPSource struct {
+	command []string
+	prompt  *ttyOTPSource
+}
+
+func (s *clipboardOTPSource) OTP(ctx context.Context, serial string) (string, error) {
+	if len(s.command) == 0 {
+		return "", errors.New("no clipboard tool found; install xclip, xsel, or wl-paste")
+	}
+	out, err := exec.CommandContext(ctx, s.command[0], s.command[1:]...).Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to read the clipboard: %w", err)
+	}
+	code := strings.TrimSpace(string(out))
+	if validateOTP(code) != nil {
+		return "", errors.New("clipboard does not contain an MFA code")
+	}
+
+	r, w, closeFn, ok := s.prompt.open()
+	defer closeFn()
+	var answer string
+	prompt := fmt.Sprintf("Use %s from the clipboard as the %s? [Y/n] ", code, s.prompt.label.prompt(serial))
+	if err := s.prompt.ask(r, w, ok, prompt, func(r io.Reader, w io.Writer) (err error) {
+		answer, err = bufio.NewReader(r).ReadString('\n')
+		if answer != "" {
+			return nil
+		}
+		return err
+	}); err != nil {
+		return "", err
+	}
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "", "y", "yes":
+		return code, nil
+	}
+	return "", errClipboardDeclined
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestClipboardOTPSource(t *testing.T) {
+	if f, err := os.OpenFile("/dev/tty", os.O_RDWR, 0); err == nil {
+		_ = f.Close()
+		t.Skip("a controlling terminal is available")
+	}
+
+	tests := []struct {
+		name      string
+		clipboard string
+		answer    string
+		want      string
+		wantErr   error
+	}{
+		{name: "confirmed", clipboard: "123456", answer: "y\n", want: "123456"},
+		{name: "confirmed by default", clipboard: " 123456\n", answer: "\n", want: "123456"},
+		{name: "declined", clipboard: "123456", answer: "n\n", wantErr: errClipboardDeclined},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stderr bytes.Buffer
+			source := &clipboardOTPSource{
+				command: []string{"printf", "%s", tt.clipboard},
+				prompt:  &ttyOTPSource{label: mfaLabel{profile: "prod"}, stdin: strings.NewReader(tt.answer), stderr: &stderr},
+			}
+
+			got, err := source.OTP(context.Background(), "arn:aws:iam::123456789012:mfa/alice")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("OTP() error = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("OTP() = %q, want %q", got, tt.want)
+			}
+			if !strings.Contains(stderr.String(), "from the clipboard") {
+				t.Errorf("stderr = %q, want a confirmation prompt", stderr.String())
+			}
+		})
+	}
+}
+
+func TestClipboardOTPSource_NotACode(t *testing.T) {
+	source := &clipboardOTPSource{
+		command: []string{"printf", "%s", "hello"},
+		prompt:  &ttyOTPSource{stdin: strings.NewReader("y\n"), stderr: &bytes.Buffer{}},
+	}
+
+	if _, err := source.OTP(context.Background(), "arn:aws:iam::123456789012:mfa/alice"); err == nil {
+		t.Fatal("OTP() error = nil, want error")
+	}
+}
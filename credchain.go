@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// chainCredentialSource tries each backend of --backend-fallback in order
+// until one returns the key pair. Later reads, such as the MFA seed, go to the
+// backend that did, or to the first one before any has.
+type chainCredentialSource struct {
+	names   []string
+	sources []opItem
+	used    int
+}
+
+func (s *chainCredentialSource) Retrieve(ctx context.Context) (aws.Credentials, error) {
+	var errs []error
+	for i, source := range s.sources {
+		creds, err := source.Retrieve(ctx)
+		if err == nil {
+			debugLog.Printf("read the key pair with the %s backend", s.names[i])
+			s.used = i
+			return creds, nil
+		}
+		if ctx.Err() != nil {
+			return aws.Credentials{}, err
+		}
+		debugLog.Printf("the %s backend failed: %v", s.names[i], err)
+		errs = append(errs, fmt.Errorf("%s: %w", s.names[i], err))
+	}
+	return aws.Credentials{}, fmt.Errorf("no backend returned the key pair: %w", errors.Join(errs...))
+}
+
+func (s *chainCredentialSource) active() opItem {
+	return s.sources[s.used]
+}
+
+// awsItem returns the item of the first backend, so sessions are cached
+// under the same key whichever backend supplied the key pair.
+func (s *chainCredentialSource) awsItem() OpAwsItem {
+	return s.sources[0].awsItem()
+}
+
+func (s *chainCredentialSource) withItem(vault, item string) opItem {
+	return s.active().withItem(vault, item)
+}
+
+func (s *chainCredentialSource) fields(ctx context.Context, labels ...string) (map[string]string, error) {
+	return s.active().fields(ctx, labels...)
+}
+
+func (s *chainCredentialSource) details(ctx context.Context) (*opItemDetails, error) {
+	return s.active().details(ctx)
+}
+
+func (s *chainCredentialSource) totp(ctx context.Context, label string) (string, error) {
+	return s.active().totp(ctx, label)
+}
+
+func (s *chainCredentialSource) otpSeed(ctx context.Context, label string) (string, error) {
+	return s.active().otpSeed(ctx, label)
+}
+
+func (s *chainCredentialSource) read(ctx context.Context, ref string) (string, error) {
+	return s.active().read(ctx, ref)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestChainCredentialSource(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIA")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("OP_AWS_MFA_SEED", "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ")
+	item := OpAwsItem{
+		Item:                 "aws/iam-user",
+		AccessKeyIDField:     "Access key ID",
+		SecretAccessKeyField: "Secret access key",
+	}
+	missing := &wincredCredentialSource{
+		lookup: func(target string) (string, string, bool, error) {
+			return "", "", false, nil
+		},
+		OpAwsItem: item,
+	}
+	env := &envCredentialSource{OpAwsItem: item, now: func() time.Time { return time.Unix(59, 0) }}
+	chain := &chainCredentialSource{names: []string{"wincred", "env"}, sources: []opItem{missing, env}}
+	ctx := context.Background()
+
+	creds, err := chain.Retrieve(ctx)
+	if err != nil {
+		t.Fatalf("Retrieve() error = %v", err)
+	}
+	if creds.AccessKeyID != "AKIA" || creds.SecretAccessKey != "secret" {
+		t.Errorf("Retrieve() = %q, %q, want %q, %q", creds.AccessKeyID, creds.SecretAccessKey, "AKIA", "secret")
+	}
+	if got, err := chain.totp(ctx, ""); err != nil || got != "287082" {
+		t.Errorf("totp() = %q, %v, want %q from the backend that returned the key pair", got, err, "287082")
+	}
+	if got := chain.awsItem().Item; got != "aws/iam-user" {
+		t.Errorf("awsItem().Item = %q, want %q", got, "aws/iam-user")
+	}
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	chain = &chainCredentialSource{names: []string{"wincred", "env"}, sources: []opItem{missing, env}}
+	if _, err := chain.Retrieve(ctx); err == nil {
+		t.Error("Retrieve() error = nil, want error when every backend fails")
+	}
+}
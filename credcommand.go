@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// commandCredentialSource runs a shell command that prints the long-term key
+// pair as JSON in the credential_process format, so secret stores other than
+// 1Password can supply it:
+//
+//	{"Version": 1, "AccessKeyId": "AKIA...", "SecretAccessKey": "..."}
+type commandCredentialSource struct {
+	command string
+}
+
+func (s *commandCredentialSource) Retrieve(ctx context.Context) (aws.Credentials, error) {
+	cmd := shellCommand(ctx, s.command)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	// Do not wait for grandchildren holding stdout once the shell is killed.
+	cmd.WaitDelay = time.Second
+	out, err := cmd.Output()
+	if err != nil {
+		return aws.Credentials{}, fmt.Errorf("credential command failed: %w\n%s", err, stderr.Bytes())
+	}
+
+	var keys struct {
+		AccessKeyID     string `json:"AccessKeyId"`
+		SecretAccessKey string `json:"SecretAccessKey"`
+	}
+	if err := json.Unmarshal(out, &keys); err != nil {
+		return aws.Credentials{}, fmt.Errorf("credential command printed invalid JSON: %w", err)
+	}
+	if keys.AccessKeyID == "" || keys.SecretAccessKey == "" {
+		return aws.Credentials{}, errors.New("credential command printed no AccessKeyId or SecretAccessKey")
+	}
+	return aws.Credentials{
+		AccessKeyID:     keys.AccessKeyID,
+		SecretAccessKey: keys.SecretAccessKey,
+	}, nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestCommandCredentialSource(t *testing.T) {
+	tests := []struct {
+		name       string
+		command    string
+		wantKey    string
+		wantSecret string
+		wantErr    string
+	}{
+		{name: "credential_process JSON", command: `echo '{"Version":1,"AccessKeyId":"AKIA","SecretAccessKey":"secret"}'`, wantKey: "AKIA", wantSecret: "secret"},
+		{name: "failure surfaces stderr", command: "echo 'vault is sealed' >&2; exit 1", wantErr: "vault is sealed"},
+		{name: "invalid JSON", command: "echo AKIA", wantErr: "invalid JSON"},
+		{name: "missing secret", command: `echo '{"AccessKeyId":"AKIA"}'`, wantErr: "no AccessKeyId or SecretAccessKey"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := &commandCredentialSource{command: tt.command}
+
+			creds, err := source.Retrieve(context.Background())
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Retrieve() error = %v, want containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Retrieve() error = %v", err)
+			}
+			if creds.AccessKeyID != tt.wantKey || creds.SecretAccessKey != tt.wantSecret {
+				t.Errorf("Retrieve() = %q, %q, want %q, %q", creds.AccessKeyID, creds.SecretAccessKey, tt.wantKey, tt.wantSecret)
+			}
+		})
+	}
+}
@@ -2,10 +2,16 @@ package main
 
 import (
 	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -22,28 +28,38 @@ type SessionTokenProvider struct {
 	OTPSource         OTPSource
 	StsClient         GetSessionTokenAPIClient
 	MfaSerial         string
-	Duration          time.Duration
+	// MfaSerialSource discovers the MFA device when MfaSerial is empty.
+	MfaSerialSource MfaSerialSource
+	// OTPAttempts is how many token codes are tried before giving up.
+	OTPAttempts int
+	Duration    time.Duration
 }
 
 func (p *SessionTokenProvider) RetrieveStsCredentials(ctx context.Context) (*ststypes.Credentials, error) {
-	if p.MfaSerial == "" {
-		return nil, errors.New("mfa_serial is not set; this tool requires an MFA device")
-	}
-
 	if _, err := p.BaseCredsProvider.Retrieve(ctx); err != nil {
 		return nil, err
 	}
 
-	otp, err := p.OTPSource.OTP(ctx)
+	input := &sts.GetSessionTokenInput{
+		DurationSeconds: aws.Int32(int32(p.Duration.Seconds())),
+	}
+	serial, err := resolveMfaSerial(ctx, p.MfaSerial, p.MfaSerialSource)
 	if err != nil {
 		return nil, err
 	}
 
-	out, err := p.StsClient.GetSessionToken(ctx, &sts.GetSessionTokenInput{
-		DurationSeconds: aws.Int32(int32(p.Duration.Seconds())),
-		SerialNumber:    aws.String(p.MfaSerial),
-		TokenCode:       aws.String(otp),
-	})
+	var out *sts.GetSessionTokenOutput
+	// Without an MFA device the session is minted without a token code.
+	if serial == "" {
+		out, err = p.StsClient.GetSessionToken(ctx, input)
+	} else {
+		input.SerialNumber = aws.String(serial)
+		err = withOTP(ctx, p.OTPSource, serial, p.OTPAttempts, func(code string) error {
+			input.TokenCode = aws.String(code)
+			out, err = p.StsClient.GetSessionToken(ctx, input)
+			return err
+		})
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -60,13 +76,17 @@ func (p *SessionTokenProvider) Retrieve(ctx context.Context) (aws.Credentials, e
 		return aws.Credentials{}, err
 	}
 
+	return toAwsCredentials(creds), nil
+}
+
+func toAwsCredentials(creds *ststypes.Credentials) aws.Credentials {
 	return aws.Credentials{
 		AccessKeyID:     aws.ToString(creds.AccessKeyId),
 		SecretAccessKey: aws.ToString(creds.SecretAccessKey),
 		SessionToken:    aws.ToString(creds.SessionToken),
 		CanExpire:       true,
 		Expires:         aws.ToTime(creds.Expiration),
-	}, nil
+	}
 }
 
 type StsSessionProvider interface {
@@ -74,18 +94,164 @@ type StsSessionProvider interface {
 	RetrieveStsCredentials(ctx context.Context) (*ststypes.Credentials, error)
 }
 
+// cacheKeyAccount is the keyring account of the session cache key.
+const cacheKeyAccount = "session-cache"
+
 type CachedSessionProvider struct {
 	SessionProvider StsSessionProvider
-	CacheDir        string
-	Profile         string
-	ExpiryWindow    time.Duration
-	OpAwsItem       OpAwsItem
-	MfaSerial       string
-	Now             func() time.Time
+	// CacheDir is the directory the cache file is kept in.
+	CacheDir     string
+	Profile      string
+	ExpiryWindow time.Duration
+	OpAwsItem    OpAwsItem
+	MfaSerial    string
+	RoleArn      string
+	// SessionType distinguishes sessions not minted by GetSessionToken or
+	// AssumeRole, e.g. "federation:<name>" for GetFederationToken.
+	SessionType string
+	Now         func() time.Time
+
+	// CredentialCommand keeps sessions minted from --credential-command
+	// apart from those minted from the item.
+	CredentialCommand string
+	// Duration is the requested session duration, so changing it mints a
+	// new session rather than serving one of the old length.
+	Duration time.Duration
+	// Cipher, when set, encrypts the cache file with a key kept in the OS
+	// keyring. Plaintext files left from before are rewritten encrypted.
+	Cipher *keyringCipher
+	// Store keeps the session in place of the cache file when set, e.g. in
+	// the OS keyring. The lock is still taken next to the cache file.
+	Store cacheStore
+	// ForceRefresh mints a new session even when a valid one is cached. It
+	// is cleared once one is minted, so a session shared by several
+	// profiles is refreshed only once.
+	ForceRefresh bool
+	// RenewAhead, when set, lets a session inside ExpiryWindow be served
+	// until it expires, and is called to renew it in the background.
+	RenewAhead func() error
+	// MaxAge, when set, caps how long after it was minted a session is
+	// served, below the lifetime STS gave it.
+	MaxAge time.Duration
+	// MaxSessions, when set, bounds how many sessions are kept in CacheDir
+	// or Store, the least recently used ones giving way to new ones.
+	MaxSessions int
+	// SyncStore, when set, keeps sessions where other machines can reuse
+	// them, e.g. in a 1Password item. It is read when no valid session is
+	// cached, and each new session is saved to it too. Sessions are kept
+	// there unencrypted by Cipher, which is of this machine only.
+	SyncStore cacheStore
+	// Partition is the partition the session is minted in. It is recorded
+	// with the session, and sessions of different partitions are kept
+	// apart.
+	Partition string
+	// Report, when set, records how the session was obtained, for cache
+	// status.
+	Report *cacheReport
+	// AWSCLICachePath, when set, is the file the AWS CLI caches the same
+	// role session in. A session found there is served, and a new one is
+	// written there too, so the CLI and this helper share sessions.
+	AWSCLICachePath string
 }
 
+// cachePath derives the cache file from every parameter that shapes the
+// session, so profiles used with different items, roles, or durations each
+// keep their own session instead of overwriting each other's. The file is
+// named after the account too when the parameters tell it.
 func (c *CachedSessionProvider) cachePath() string {
-	return filepath.Join(c.CacheDir, "op-aws-credential-process", c.Profile+".json")
+	params := []string{
+		c.Partition,
+		c.SessionType,
+		c.RoleArn,
+		c.MfaSerial,
+		c.OpAwsItem.Account,
+		c.OpAwsItem.Vault,
+		c.OpAwsItem.Item,
+		c.OpAwsItem.AccessKeyIDField,
+		c.OpAwsItem.SecretAccessKeyField,
+		c.OpAwsItem.AccessKeyIDRef,
+		c.OpAwsItem.SecretAccessKeyRef,
+		c.CredentialCommand,
+		c.Duration.String(),
+	}
+	if hash := c.paramsHash(); hash != "" {
+		params = append(params, hash)
+	}
+	sum := sha1.Sum([]byte(strings.Join(params, "\x00")))
+	// The account follows the hash, so neither can be mistaken for the end
+	// of a profile name.
+	name := c.Profile + "-" + hex.EncodeToString(sum[:8])
+	if account := c.accountID(); account != "" {
+		name += "-" + account
+	}
+	return filepath.Join(c.CacheDir, name+".json")
+}
+
+// accountID returns the account the session is of as far as its parameters
+// tell: the member account of a root session, that of the role, or that of
+// the MFA device the long-term key pair is used with.
+func (c *CachedSessionProvider) accountID() string {
+	if target, ok := strings.CutPrefix(c.SessionType, "root:"); ok {
+		if target, _, _ = strings.Cut(target, ":"); isAccountID(target) {
+			return target
+		}
+	}
+	if c.RoleArn != "" {
+		return arnAccount(c.RoleArn)
+	}
+	// The role is read from the item when the session is minted.
+	if c.SessionType == opRoleSessionType {
+		return ""
+	}
+	return arnAccount(c.MfaSerial)
+}
+
+// sessionParams are the parameters of a session that CachedSessionProvider
+// has no field for, e.g. session policies and tags, which are set on the
+// session provider.
+type sessionParams struct {
+	RoleSessionName   string            `json:"role_session_name,omitempty"`
+	ExternalID        string            `json:"external_id,omitempty"`
+	Policy            string            `json:"policy,omitempty"`
+	PolicyArns        []string          `json:"policy_arns,omitempty"`
+	Tags              map[string]string `json:"tags,omitempty"`
+	TransitiveTagKeys []string          `json:"transitive_tag_keys,omitempty"`
+	TagFields         []string          `json:"tag_fields,omitempty"`
+	RoleFields        []string          `json:"role_fields,omitempty"`
+}
+
+// sessionParamsProvider is implemented by session providers taking
+// sessionParams, so sessions minted with different ones are kept apart.
+type sessionParamsProvider interface {
+	sessionParams() sessionParams
+}
+
+// paramsHash returns a hash of the sessionParams of SessionProvider, or ""
+// when it takes none. Lists are sorted first, since their order does not
+// change the session.
+func (c *CachedSessionProvider) paramsHash() string {
+	provider, ok := c.SessionProvider.(sessionParamsProvider)
+	if !ok {
+		return ""
+	}
+	params := provider.sessionParams()
+	for _, list := range []*[]string{&params.PolicyArns, &params.TransitiveTagKeys, &params.TagFields} {
+		*list = slices.Sorted(slices.Values(*list))
+	}
+	// Maps are written with their keys sorted.
+	data, err := json.Marshal(params)
+	if err != nil || string(data) == "{}" {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func (c *CachedSessionProvider) store() cacheStore {
+	if c.Store == nil {
+		return &fileCacheStore{dir: filepath.Dir(c.cachePath()), touch: true}
+	}
+	return c.Store
 }
 
 func (c *CachedSessionProvider) now() time.Time {
@@ -96,7 +262,55 @@ func (c *CachedSessionProvider) now() time.Time {
 }
 
 func (c *CachedSessionProvider) isValidEntry(entry cachedEntry) bool {
-	if entry.Credentials == nil || entry.Credentials.Expiration == nil {
+	return c.matchesEntry(entry) && c.now().Add(c.ExpiryWindow).Before(c.expiration(entry))
+}
+
+// checkEntry rejects cache files that are truncated, edited by hand, or
+// written under a clock far off from the current one, so they are replaced by
+// a new session rather than served as a half-empty response.
+func (c *CachedSessionProvider) checkEntry(entry cachedEntry) error {
+	creds := entry.Credentials
+	switch {
+	case creds == nil:
+		return errors.New("no credentials")
+	case aws.ToString(creds.AccessKeyId) == "" || aws.ToString(creds.SecretAccessKey) == "" || aws.ToString(creds.SessionToken) == "":
+		return errors.New("incomplete credentials")
+	case creds.Expiration == nil || creds.Expiration.IsZero():
+		return errors.New("no expiration")
+	case creds.Expiration.After(c.now().Add(maxSessionLifetime)):
+		return fmt.Errorf("expiration %s is further ahead than STS issues sessions for", creds.Expiration.Format(time.RFC3339))
+	case entry.MintedAt.After(c.now()):
+		return fmt.Errorf("minted in the future at %s", entry.MintedAt.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// expiration returns when the session of entry stops being served.
+func (c *CachedSessionProvider) expiration(entry cachedEntry) time.Time {
+	expires := aws.ToTime(entry.Credentials.Expiration)
+	if capped := entry.MintedAt.Add(c.MaxAge); c.MaxAge > 0 && capped.Before(expires) {
+		return capped
+	}
+	return expires
+}
+
+// served returns the session of entry, expiring when it stops being served
+// so that callers come back for a new one then.
+func (c *CachedSessionProvider) served(entry cachedEntry) *ststypes.Credentials {
+	expires := c.expiration(entry)
+	if expires.Equal(aws.ToTime(entry.Credentials.Expiration)) {
+		return entry.Credentials
+	}
+	creds := *entry.Credentials
+	creds.Expiration = &expires
+	return &creds
+}
+
+// matchesEntry reports whether entry is a session of the parameters of c,
+// however long it has left.
+func (c *CachedSessionProvider) matchesEntry(entry cachedEntry) bool {
+	if err := c.checkEntry(entry); err != nil {
+		debugLog.Printf("ignoring the cached session: %v", err)
 		return false
 	}
 	if entry.Vault != c.OpAwsItem.Vault {
@@ -108,58 +322,348 @@ func (c *CachedSessionProvider) isValidEntry(entry cachedEntry) bool {
 	if entry.MfaSerial != c.MfaSerial {
 		return false
 	}
+	if entry.RoleArn != c.RoleArn {
+		return false
+	}
+	if entry.SessionType != c.SessionType {
+		return false
+	}
+	if entry.Partition != c.Partition {
+		return false
+	}
 	if entry.AccessKeyIDField != c.OpAwsItem.AccessKeyIDField {
 		return false
 	}
 	if entry.SecretAccessKeyField != c.OpAwsItem.SecretAccessKeyField {
 		return false
 	}
+	if entry.CredentialCommand != c.CredentialCommand {
+		return false
+	}
+	if entry.Account != c.OpAwsItem.Account {
+		return false
+	}
+	if entry.AccessKeyIDRef != c.OpAwsItem.AccessKeyIDRef {
+		return false
+	}
+	if entry.SecretAccessKeyRef != c.OpAwsItem.SecretAccessKeyRef {
+		return false
+	}
+	if entry.DurationSeconds != int64(c.Duration.Seconds()) {
+		return false
+	}
+	if entry.ParamsHash != c.paramsHash() {
+		return false
+	}
+	// Sessions cached before minted_at was recorded are of unknown age.
+	if c.MaxAge > 0 && entry.MintedAt.IsZero() {
+		return false
+	}
+	return true
+}
+
+// readCache returns the cached session, or nil when there is no valid one,
+// along with how the session is obtained. With renewAhead, a session inside
+// ExpiryWindow is returned while c.RenewAhead renews it.
+func (c *CachedSessionProvider) readCache(ctx context.Context, renewAhead bool) (*ststypes.Credentials, cacheEvent) {
+	data, err := c.store().load(ctx, filepath.Base(c.cachePath()))
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			debugLog.Printf("ignoring the cached session: %v", err)
+			return nil, c.event(sessionMinted, "the cached session was unreadable: "+err.Error())
+		}
+		return nil, c.event(sessionMinted, "no session was cached")
+	}
+	cached, encrypted, err := decodeCacheEntry(ctx, data, filepath.Base(c.cachePath()), c.Cipher)
+	if err != nil {
+		// Without the cipher an encrypted session is of no use; it is
+		// replaced by the next one minted.
+		debugLog.Printf("ignoring the cached session: %v", err)
+		return nil, c.event(sessionMinted, "the cached session was unreadable: "+err.Error())
+	}
+	if err := c.checkEntry(cached); err != nil {
+		debugLog.Printf("ignoring the cached session: %v", err)
+		return nil, c.event(sessionMinted, "the cached session was unusable: "+err.Error())
+	}
+	if !c.matchesEntry(cached) {
+		return nil, c.event(sessionMinted, "the cached session was minted with other parameters")
+	}
+	if !c.isValidEntry(cached) {
+		expires := c.expiration(cached)
+		if !renewAhead || c.RenewAhead == nil || !c.now().Before(expires) {
+			return nil, c.event(sessionRefreshed, c.staleReason(cached))
+		}
+		if err := c.RenewAhead(); err != nil {
+			debugLog.Printf("renewing in the foreground: %v", err)
+			return nil, c.event(sessionRefreshed, c.staleReason(cached))
+		}
+		return c.served(cached), c.event(sessionCached, "renewing it in the background")
+	}
+	if !encrypted && c.Cipher != nil {
+		if err := c.writeCache(ctx, cached); err != nil {
+			warnLog.Printf("failed to encrypt the cached session: %v", err)
+		}
+	}
+	return c.served(cached), c.event(sessionCached, "")
+}
 
-	return c.now().Add(c.ExpiryWindow).Before(*entry.Credentials.Expiration)
+// staleReason says why the cached session of entry is replaced.
+func (c *CachedSessionProvider) staleReason(entry cachedEntry) string {
+	expires := c.expiration(entry)
+	switch {
+	case c.now().Before(expires):
+		return "it expires at " + expires.Format(time.RFC3339) + ", within the refresh margin"
+	case !expires.Equal(aws.ToTime(entry.Credentials.Expiration)):
+		return "it reached --cache-ttl at " + expires.Format(time.RFC3339)
+	default:
+		return "it expired at " + expires.Format(time.RFC3339)
+	}
+}
+
+// decodeCacheEntry parses the cache file called name, decrypting it with
+// cipher when it is encrypted. The file name is authenticated along with an
+// encrypted session, so it cannot be moved to stand in for the session of
+// another profile.
+func decodeCacheEntry(ctx context.Context, data []byte, name string, cipher *keyringCipher) (entry cachedEntry, encrypted bool, err error) {
+	var file sealedCache
+	if err := json.Unmarshal(data, &file); err != nil {
+		return cachedEntry{}, false, err
+	}
+	if file.Sealed != nil {
+		if cipher == nil {
+			return cachedEntry{}, true, errors.New("the session is encrypted; set --encrypt-cache to read it")
+		}
+		if data, err = cipher.open(ctx, file.Sealed, []byte(name)); err != nil {
+			return cachedEntry{}, true, err
+		}
+	}
+	err = json.Unmarshal(data, &entry)
+	return entry, file.Sealed != nil, err
 }
 
 func (c *CachedSessionProvider) RetrieveStsCredentials(ctx context.Context) (*ststypes.Credentials, error) {
-	data, err := os.ReadFile(c.cachePath())
-	if err == nil {
-		var cached cachedEntry
-		if err := json.Unmarshal(data, &cached); err == nil && c.isValidEntry(cached) {
-			return cached.Credentials, nil
+	event := c.event(sessionRefreshed, "--force-refresh was set")
+	if !c.ForceRefresh {
+		var creds *ststypes.Credentials
+		if creds, event = c.readCache(ctx, true); creds != nil {
+			c.record(event)
+			return creds, nil
+		}
+		if creds := c.readSyncStore(ctx); creds != nil {
+			c.record(c.event(sessionCached, "another machine minted it"))
+			return creds, nil
+		}
+		if creds := c.readAWSCLICache(); creds != nil {
+			cached := c.event(sessionCached, "the AWS CLI cached it")
+			cached.CacheFile = c.AWSCLICachePath
+			c.record(cached)
+			return creds, nil
+		}
+	}
+
+	// Only one invocation mints the session; the others wait for it and
+	// read the fresh cache rather than prompting for MFA themselves.
+	unlock, err := lockFile(ctx, c.cachePath()+".lock")
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		debugLog.Printf("minting without the cache lock: %v", err)
+	} else {
+		defer unlock()
+		if !c.ForceRefresh {
+			creds, locked := c.readCache(ctx, false)
+			if creds != nil {
+				locked.Reason = "another invocation minted it meanwhile"
+				c.record(locked)
+				return creds, nil
+			}
 		}
 	}
 
 	creds, err := c.SessionProvider.RetrieveStsCredentials(ctx)
 	if err != nil {
+		// An outage is no reason to fail while the cached session still
+		// works.
+		if (isSTSUnreachable(err) || errors.Is(err, context.DeadlineExceeded)) && !c.ForceRefresh {
+			if stale := c.readStaleCache(ctx); stale != nil {
+				warnLog.Printf("serving the cached session until it expires at %s, as STS is unreachable: %v", stale.Expiration.Format(time.RFC3339), err)
+				c.record(c.event(sessionCached, "STS was unreachable"))
+				return stale, nil
+			}
+		}
 		return nil, err
 	}
+	c.ForceRefresh = false
 
 	entry := cachedEntry{
 		Credentials:          creds,
 		Vault:                c.OpAwsItem.Vault,
 		Item:                 c.OpAwsItem.Item,
 		MfaSerial:            c.MfaSerial,
+		RoleArn:              c.RoleArn,
+		SessionType:          c.SessionType,
 		AccessKeyIDField:     c.OpAwsItem.AccessKeyIDField,
 		SecretAccessKeyField: c.OpAwsItem.SecretAccessKeyField,
+		AccessKeyIDRef:       c.OpAwsItem.AccessKeyIDRef,
+		SecretAccessKeyRef:   c.OpAwsItem.SecretAccessKeyRef,
+		Account:              c.OpAwsItem.Account,
+		CredentialCommand:    c.CredentialCommand,
+		DurationSeconds:      int64(c.Duration.Seconds()),
+		MintedAt:             c.now().UTC(),
+		Partition:            c.Partition,
+		AccountID:            c.accountID(),
+		ParamsHash:           c.paramsHash(),
+	}
+	if entry.AccountID == "" {
+		entry.AccountID = accountFromAccessKey(aws.ToString(creds.AccessKeyId))
+	}
+	// An unwritable cache file only costs a new session next time, but the
+	// keyring is opted in, so its failures are reported.
+	if err := c.writeCache(ctx, entry); err != nil && (c.Cipher != nil || c.Store != nil) {
+		warnLog.Printf("failed to cache the session: %v", err)
+	}
+	if c.SyncStore != nil {
+		if data, err := json.Marshal(entry); err != nil {
+			warnLog.Printf("failed to share the session: %v", err)
+		} else if err := c.SyncStore.save(ctx, filepath.Base(c.cachePath()), data); err != nil {
+			warnLog.Printf("failed to share the session: %v", err)
+		}
+	}
+	if c.AWSCLICachePath != "" {
+		if err := writeAWSCLICache(c.AWSCLICachePath, creds); err != nil {
+			warnLog.Printf("failed to share the session with the AWS CLI: %v", err)
+		}
+	}
+	// Sessions of other parameters are otherwise never cleaned up. Reading
+	// them to do so does not count as using them.
+	swept := c.Store
+	if swept == nil {
+		swept = &fileCacheStore{dir: filepath.Dir(c.cachePath())}
+	}
+	if _, err := purgeExpiredCache(ctx, swept, c.Cipher, c.now(), cacheGracePeriod); err != nil {
+		debugLog.Printf("failed to purge expired sessions: %v", err)
+	}
+	if c.MaxSessions > 0 {
+		if _, err := evictCache(ctx, swept, c.MaxSessions); err != nil {
+			debugLog.Printf("failed to evict cached sessions: %v", err)
+		}
 	}
-	_ = c.writeCache(entry)
 
-	return creds, nil
+	c.record(event)
+	return c.served(entry), nil
 }
 
-func (c *CachedSessionProvider) writeCache(entry cachedEntry) error {
-	if err := os.MkdirAll(filepath.Dir(c.cachePath()), 0700); err != nil {
-		return err
+// readStaleCache returns the cached session until it expires, even inside
+// ExpiryWindow, or nil when there is none.
+func (c *CachedSessionProvider) readStaleCache(ctx context.Context) *ststypes.Credentials {
+	name := filepath.Base(c.cachePath())
+	data, err := c.store().load(ctx, name)
+	if err != nil {
+		return nil
+	}
+	entry, _, err := decodeCacheEntry(ctx, data, name, c.Cipher)
+	if err != nil || !c.matchesEntry(entry) || !c.now().Before(c.expiration(entry)) {
+		return nil
+	}
+	return c.served(entry)
+}
+
+// readSyncStore returns the session kept in SyncStore, or nil when there is
+// no valid one. A session found there is cached here too, so the next
+// invocation does not look for it again.
+func (c *CachedSessionProvider) readSyncStore(ctx context.Context) *ststypes.Credentials {
+	if c.SyncStore == nil {
+		return nil
+	}
+	name := filepath.Base(c.cachePath())
+	data, err := c.SyncStore.load(ctx, name)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			debugLog.Printf("ignoring the shared session: %v", err)
+		}
+		return nil
+	}
+	entry, _, err := decodeCacheEntry(ctx, data, name, nil)
+	if err != nil {
+		debugLog.Printf("ignoring the shared session: %v", err)
+		return nil
+	}
+	if !c.isValidEntry(entry) {
+		return nil
 	}
+	if err := c.writeCache(ctx, entry); err != nil {
+		debugLog.Printf("failed to cache the shared session: %v", err)
+	}
+	return c.served(entry)
+}
 
+// readAWSCLICache returns the session the AWS CLI cached at AWSCLICachePath,
+// or nil when there is no valid one. The CLI does not record when a session
+// was minted, so none is served under MaxAge.
+func (c *CachedSessionProvider) readAWSCLICache() *ststypes.Credentials {
+	if c.AWSCLICachePath == "" || c.MaxAge > 0 {
+		return nil
+	}
+	creds, err := readAWSCLICache(c.AWSCLICachePath)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			debugLog.Printf("ignoring the AWS CLI cached session: %v", err)
+		}
+		return nil
+	}
+	if err := c.checkEntry(cachedEntry{Credentials: creds}); err != nil {
+		debugLog.Printf("ignoring the AWS CLI cached session: %v", err)
+		return nil
+	}
+	if !c.now().Add(c.ExpiryWindow).Before(*creds.Expiration) {
+		return nil
+	}
+	debugLog.Printf("read the session from the AWS CLI cache %s", c.AWSCLICachePath)
+	return creds
+}
+
+func (c *CachedSessionProvider) writeCache(ctx context.Context, entry cachedEntry) error {
 	data, err := json.Marshal(entry)
 	if err != nil {
 		return err
 	}
+	if c.Cipher != nil {
+		sealed, err := c.Cipher.seal(ctx, data, []byte(filepath.Base(c.cachePath())))
+		if err != nil {
+			return err
+		}
+		if data, err = json.Marshal(sealedCache{Sealed: sealed}); err != nil {
+			return err
+		}
+	}
 
-	if err := os.WriteFile(c.cachePath(), data, 0600); err != nil {
+	return c.store().save(ctx, filepath.Base(c.cachePath()), data)
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames it
+// into place, so a crash or a concurrent writer never leaves a truncated file
+// behind for the next run to trip over.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
 		return err
 	}
-
-	return nil
+	defer func() {
+		_ = os.Remove(tmp.Name())
+	}()
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
 }
 
 func (c *CachedSessionProvider) Retrieve(ctx context.Context) (aws.Credentials, error) {
@@ -168,13 +672,7 @@ func (c *CachedSessionProvider) Retrieve(ctx context.Context) (aws.Credentials,
 		return aws.Credentials{}, err
 	}
 
-	return aws.Credentials{
-		AccessKeyID:     aws.ToString(creds.AccessKeyId),
-		SecretAccessKey: aws.ToString(creds.SecretAccessKey),
-		SessionToken:    aws.ToString(creds.SessionToken),
-		CanExpire:       true,
-		Expires:         aws.ToTime(creds.Expiration),
-	}, nil
+	return toAwsCredentials(creds), nil
 }
 
 type cachedEntry struct {
@@ -182,6 +680,24 @@ type cachedEntry struct {
 	Vault                string                `json:"vault"`
 	Item                 string                `json:"item"`
 	MfaSerial            string                `json:"mfa_serial"`
+	RoleArn              string                `json:"role_arn"`
+	SessionType          string                `json:"session_type"`
 	AccessKeyIDField     string                `json:"access_key_id_field"`
 	SecretAccessKeyField string                `json:"secret_access_key_field"`
+	AccessKeyIDRef       string                `json:"access_key_id_ref,omitempty"`
+	SecretAccessKeyRef   string                `json:"secret_access_key_ref,omitempty"`
+	Account              string                `json:"account,omitempty"`
+	CredentialCommand    string                `json:"credential_command,omitempty"`
+	DurationSeconds      int64                 `json:"duration_seconds,omitempty"`
+	MintedAt             time.Time             `json:"minted_at,omitzero"`
+	Partition            string                `json:"partition,omitempty"`
+	AccountID            string                `json:"account_id,omitempty"`
+	// ParamsHash is the paramsHash the session was minted with.
+	ParamsHash string `json:"params_hash,omitempty"`
+}
+
+// sealedCache is a cache file encrypted with CachedSessionProvider.Cipher;
+// Sealed holds the encrypted cachedEntry.
+type sealedCache struct {
+	Sealed *sealed `json:"sealed,omitempty"`
 }
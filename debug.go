@@ -0,0 +1,15 @@
+package main
+
+import (
+	"io"
+	"log"
+	"os"
+)
+
+// debugLog receives diagnostics enabled by --debug. It discards them by
+// default, since stderr is shown to users of SDKs and the AWS CLI.
+var debugLog = log.New(io.Discard, "op-aws-credential-process: ", 0)
+
+// warnLog receives warnings the user should act on, such as an access key
+// about to expire.
+var warnLog = log.New(os.Stderr, "op-aws-credential-process: warning: ", 0)
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+const dialogTitle = "op-aws-credential-process"
+
+// dialogOTPSource asks for the MFA code with a graphical dialog, for SDKs
+// invoked by GUI apps or editors without a terminal. The answer is hidden like
+// a password so it does not linger on screen.
+type dialogOTPSource struct {
+	cliPath string
+	// args returns the arguments that show prompt.
+	args func(prompt string) []string
+	// label is shown in the prompt.
+	label mfaLabel
+}
+
+// newDialogOTPSource returns the native dialog for goos: osascript on macOS,
+// and zenity or kdialog in Linux desktop sessions. It returns nil when no
+// dialog is available.
+func newDialogOTPSource(goos string, label mfaLabel) *dialogOTPSource {
+	switch goos {
+	case "darwin":
+		return &dialogOTPSource{cliPath: "osascript", label: label, args: func(prompt string) []string {
+			return []string{"-e", fmt.Sprintf(`text returned of (display dialog %q default answer "" with title %q with hidden answer)`, prompt, dialogTitle)}
+		}}
+	case "linux":
+		if os.Getenv("DISPLAY") == "" && os.Getenv("WAYLAND_DISPLAY") == "" {
+			return nil
+		}
+		if path, err := exec.LookPath("zenity"); err == nil {
+			return &dialogOTPSource{cliPath: path, label: label, args: func(prompt string) []string {
+				return []string{"--entry", "--hide-text", "--title", dialogTitle, "--text", prompt}
+			}}
+		}
+		if path, err := exec.LookPath("kdialog"); err == nil {
+			return &dialogOTPSource{cliPath: path, label: label, args: func(prompt string) []string {
+				return []string{"--title", dialogTitle, "--password", prompt}
+			}}
+		}
+	}
+	return nil
+}
+
+// newAskpassOTPSource returns a dialog backed by an askpass program, which
+// following the SSH_ASKPASS convention takes the prompt as its argument and
+// prints the answer.
+func newAskpassOTPSource(path string, label mfaLabel) *dialogOTPSource {
+	return &dialogOTPSource{cliPath: path, label: label, args: func(prompt string) []string {
+		return []string{prompt + ": "}
+	}}
+}
+
+// envAskpass returns SSH_ASKPASS and whether SSH_ASKPASS_REQUIRE asks to use
+// it even when a terminal is available.
+func envAskpass() (path string, prefer bool) {
+	path = os.Getenv("SSH_ASKPASS")
+	switch os.Getenv("SSH_ASKPASS_REQUIRE") {
+	case "never":
+		return "", false
+	case "prefer", "force":
+		return path, path != ""
+	}
+	return path, false
+}
+
+func (s *dialogOTPSource) OTP(ctx context.Context, serial string) (string, error) {
+	out, err := exec.CommandContext(ctx, s.cliPath, s.args(s.label.prompt(serial))...).Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			// zenity and kdialog exit with 1 when cancelled, and osascript
+			// reports it as error -128.
+			stderr := strings.TrimSpace(string(exitErr.Stderr))
+			if (exitErr.ExitCode() == 1 && stderr == "") || strings.Contains(stderr, "(-128)") {
+				return "", errors.New("MFA code dialog was cancelled")
+			}
+			return "", fmt.Errorf("failed to show MFA code dialog: %w\n%s", err, stderr)
+		}
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// stdinIsPipe reports whether stdin is a pipe or a file that can supply the
+// code, as opposed to e.g. /dev/null inherited from a GUI app.
+func stdinIsPipe() bool {
+	info, err := os.Stdin.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeNamedPipe != 0 || info.Mode().IsRegular()
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeFakeDialog(t *testing.T, script string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "dialog")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+script+"\n"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestDialogOTPSource(t *testing.T) {
+	tests := []struct {
+		name    string
+		script  string
+		want    string
+		wantErr string
+	}{
+		{name: "code", script: "echo 123456", want: "123456"},
+		{name: "cancelled", script: "echo 'execution error: User canceled. (-128)' >&2; exit 1", wantErr: "cancelled"},
+		{name: "cancelled silently", script: "exit 1", wantErr: "cancelled"},
+		{name: "failure", script: "echo 'syntax error' >&2; exit 1", wantErr: "syntax error"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := &dialogOTPSource{cliPath: writeFakeDialog(t, tt.script), args: func(prompt string) []string { return nil }}
+
+			got, err := source.OTP(context.Background(), "arn:aws:iam::123456789012:mfa/alice")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("OTP() error = %v, want containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("OTP() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("OTP() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewDialogOTPSource(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "kdialog"), []byte("#!/bin/sh\n"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir)
+
+	tests := []struct {
+		name     string
+		goos     string
+		display  string
+		wantPath string
+	}{
+		{name: "macOS", goos: "darwin", wantPath: "osascript"},
+		{name: "Linux desktop", goos: "linux", display: ":0", wantPath: filepath.Join(dir, "kdialog")},
+		{name: "Linux without display", goos: "linux"},
+		{name: "other", goos: "freebsd", display: ":0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("DISPLAY", tt.display)
+			t.Setenv("WAYLAND_DISPLAY", "")
+
+			got := newDialogOTPSource(tt.goos, mfaLabel{profile: "prod"})
+			var gotPath string
+			if got != nil {
+				gotPath = got.cliPath
+			}
+			if gotPath != tt.wantPath {
+				t.Errorf("newDialogOTPSource(%q, %q) path = %q, want %q", tt.goos, "prod", gotPath, tt.wantPath)
+			}
+		})
+	}
+}
+
+func TestNewAskpassOTPSource(t *testing.T) {
+	source := newAskpassOTPSource(writeFakeDialog(t, `[ "$1" = "MFA code for profile prod (arn:aws:iam::123456789012:mfa/alice): " ] && echo 123456`), mfaLabel{profile: "prod"})
+
+	got, err := source.OTP(context.Background(), "arn:aws:iam::123456789012:mfa/alice")
+	if err != nil {
+		t.Fatalf("OTP() error = %v", err)
+	}
+	if got != "123456" {
+		t.Errorf("OTP() = %q, want %q", got, "123456")
+	}
+}
+
+func TestEnvAskpass(t *testing.T) {
+	tests := []struct {
+		name       string
+		askpass    string
+		require    string
+		wantPath   string
+		wantPrefer bool
+	}{
+		{name: "unset"},
+		{name: "fallback", askpass: "/usr/bin/ssh-askpass", wantPath: "/usr/bin/ssh-askpass"},
+		{name: "prefer", askpass: "/usr/bin/ssh-askpass", require: "prefer", wantPath: "/usr/bin/ssh-askpass", wantPrefer: true},
+		{name: "force", askpass: "/usr/bin/ssh-askpass", require: "force", wantPath: "/usr/bin/ssh-askpass", wantPrefer: true},
+		{name: "never", askpass: "/usr/bin/ssh-askpass", require: "never"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("SSH_ASKPASS", tt.askpass)
+			t.Setenv("SSH_ASKPASS_REQUIRE", tt.require)
+
+			path, prefer := envAskpass()
+			if path != tt.wantPath || prefer != tt.wantPrefer {
+				t.Errorf("envAskpass() = (%q, %v), want (%q, %v)", path, prefer, tt.wantPath, tt.wantPrefer)
+			}
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	smithyendpoints "github.com/aws/smithy-go/endpoints"
+)
+
+// resolveRegion returns the first region set among the flag, the profile,
+// AWS_REGION, and AWS_DEFAULT_REGION.
+func resolveRegion(flag, profile string) string {
+	for _, region := range []string{flag, profile, os.Getenv("AWS_REGION"), os.Getenv("AWS_DEFAULT_REGION")} {
+		if region != "" {
+			return region
+		}
+	}
+	return ""
+}
+
+// useGlobalSTSEndpoint resolves sts_regional_endpoints, with
+// AWS_STS_REGIONAL_ENDPOINTS taking precedence over the profile. Regional
+// endpoints are the default.
+func useGlobalSTSEndpoint(profile string) (bool, error) {
+	value := os.Getenv("AWS_STS_REGIONAL_ENDPOINTS")
+	if value == "" {
+		v, err := sharedConfigValue(profile, "sts_regional_endpoints")
+		if err != nil {
+			return false, err
+		}
+		value = v
+	}
+
+	switch value {
+	case "", "regional":
+		return false, nil
+	case "legacy":
+		return true, nil
+	default:
+		return false, fmt.Errorf("invalid sts_regional_endpoints %q; must be regional or legacy", value)
+	}
+}
+
+// useFIPSEndpoint resolves whether to use the FIPS endpoint. The flag takes
+// precedence over AWS_USE_FIPS_ENDPOINT, which takes precedence over
+// use_fips_endpoint of the profile.
+func useFIPSEndpoint(flag bool, cfg *config.SharedConfig) (bool, error) {
+	if flag {
+		return true, nil
+	}
+	env, err := config.NewEnvConfig()
+	if err != nil {
+		return false, err
+	}
+	if env.UseFIPSEndpoint != aws.FIPSEndpointStateUnset {
+		return env.UseFIPSEndpoint == aws.FIPSEndpointStateEnabled, nil
+	}
+	return cfg.UseFIPSEndpoint == aws.FIPSEndpointStateEnabled, nil
+}
+
+// globalEndpointResolver sends requests from legacy regions to the global
+// sts.amazonaws.com endpoint.
+type globalEndpointResolver struct {
+	sts.EndpointResolverV2
+}
+
+func (r *globalEndpointResolver) ResolveEndpoint(ctx context.Context, params sts.EndpointParameters) (smithyendpoints.Endpoint, error) {
+	params.UseGlobalEndpoint = aws.Bool(true)
+	return r.EndpointResolverV2.ResolveEndpoint(ctx, params)
+}
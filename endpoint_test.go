@@ -0,0 +1,110 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+)
+
+func TestResolveRegion(t *testing.T) {
+	tests := []struct {
+		name          string
+		flag          string
+		profile       string
+		awsRegion     string
+		defaultRegion string
+		want          string
+	}{
+		{name: "flag", flag: "eu-west-1", profile: "ap-northeast-1", awsRegion: "us-west-2", want: "eu-west-1"},
+		{name: "profile", profile: "ap-northeast-1", awsRegion: "us-west-2", want: "ap-northeast-1"},
+		{name: "AWS_REGION", awsRegion: "us-west-2", defaultRegion: "us-east-2", want: "us-west-2"},
+		{name: "AWS_DEFAULT_REGION", defaultRegion: "us-east-2", want: "us-east-2"},
+		{name: "unset", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("AWS_REGION", tt.awsRegion)
+			t.Setenv("AWS_DEFAULT_REGION", tt.defaultRegion)
+			if got := resolveRegion(tt.flag, tt.profile); got != tt.want {
+				t.Errorf("resolveRegion(%q, %q) = %q, want %q", tt.flag, tt.profile, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUseGlobalSTSEndpoint(t *testing.T) {
+	writeSharedConfig(t, `
+[profile legacy]
+sts_regional_endpoints = legacy
+
+[profile regional]
+sts_regional_endpoints = regional
+
+[profile invalid]
+sts_regional_endpoints = global
+`)
+
+	tests := []struct {
+		name    string
+		profile string
+		env     string
+		want    bool
+		wantErr bool
+	}{
+		{name: "default", profile: "unset", want: false},
+		{name: "profile legacy", profile: "legacy", want: true},
+		{name: "profile regional", profile: "regional", want: false},
+		{name: "env overrides profile", profile: "legacy", env: "regional", want: false},
+		{name: "invalid", profile: "invalid", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("AWS_STS_REGIONAL_ENDPOINTS", tt.env)
+			got, err := useGlobalSTSEndpoint(tt.profile)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("useGlobalSTSEndpoint(%q) = %v, want %v", tt.profile, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUseFIPSEndpoint(t *testing.T) {
+	enabled := &config.SharedConfig{UseFIPSEndpoint: aws.FIPSEndpointStateEnabled}
+	unset := &config.SharedConfig{}
+
+	tests := []struct {
+		name string
+		flag bool
+		env  string
+		cfg  *config.SharedConfig
+		want bool
+	}{
+		{name: "default", cfg: unset, want: false},
+		{name: "flag", flag: true, cfg: unset, want: true},
+		{name: "env", env: "true", cfg: unset, want: true},
+		{name: "profile", cfg: enabled, want: true},
+		{name: "env overrides profile", env: "false", cfg: enabled, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("AWS_USE_FIPS_ENDPOINT", tt.env)
+			got, err := useFIPSEndpoint(tt.flag, tt.cfg)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("useFIPSEndpoint = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
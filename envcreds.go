@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// envCredentialSource reads the key pair from AWS_ACCESS_KEY_ID and
+// AWS_SECRET_ACCESS_KEY, or from the files named by AWS_ACCESS_KEY_ID_FILE
+// and AWS_SECRET_ACCESS_KEY_FILE as CI secrets are often mounted, so the
+// same credential_process line works where no password manager is present.
+// An MFA seed is read from OP_AWS_MFA_SEED likewise.
+type envCredentialSource struct {
+	OpAwsItem
+	now func() time.Time
+}
+
+// envCredentialItem is the item name sessions minted from the environment
+// are cached under.
+const envCredentialItem = "environment"
+
+// env returns the value of the variable name, or the trimmed contents of the
+// file named by name_FILE.
+func (s *envCredentialSource) env(name string) (string, error) {
+	if value := os.Getenv(name); value != "" {
+		return value, nil
+	}
+	path := os.Getenv(name + "_FILE")
+	if path == "" {
+		return "", nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s_FILE: %w", name, err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+func (s *envCredentialSource) Retrieve(ctx context.Context) (aws.Credentials, error) {
+	return readCredentials(ctx, s)
+}
+
+func (s *envCredentialSource) awsItem() OpAwsItem {
+	return s.OpAwsItem
+}
+
+func (s *envCredentialSource) withItem(vault, item string) opItem {
+	return s
+}
+
+// details presents the variables as the access key ID and secret access key
+// fields.
+func (s *envCredentialSource) details(ctx context.Context) (*opItemDetails, error) {
+	fields := make(map[string]string, 2)
+	for label, name := range map[string]string{
+		s.AccessKeyIDField:     "AWS_ACCESS_KEY_ID",
+		s.SecretAccessKeyField: "AWS_SECRET_ACCESS_KEY",
+	} {
+		value, err := s.env(name)
+		if err != nil {
+			return nil, err
+		}
+		if value == "" {
+			return nil, fmt.Errorf("%s is not set", name)
+		}
+		fields[label] = value
+	}
+	return &opItemDetails{fields: fields}, nil
+}
+
+func (s *envCredentialSource) fields(ctx context.Context, labels ...string) (map[string]string, error) {
+	details, err := s.details(ctx)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]string, len(labels))
+	for _, label := range labels {
+		if value, ok := details.fields[label]; ok {
+			values[label] = value
+		}
+	}
+	return values, nil
+}
+
+func (s *envCredentialSource) totp(ctx context.Context, label string) (string, error) {
+	seed, err := s.otpSeed(ctx, label)
+	if err != nil {
+		return "", err
+	}
+	now := s.now
+	if now == nil {
+		now = time.Now
+	}
+	return totpCode(seed, now())
+}
+
+func (s *envCredentialSource) otpSeed(ctx context.Context, label string) (string, error) {
+	seed, err := s.env("OP_AWS_MFA_SEED")
+	if err != nil {
+		return "", err
+	}
+	if seed == "" {
+		return "", fmt.Errorf("OP_AWS_MFA_SEED is not set; pass the code in %s or use --no-mfa", mfaCodeEnv)
+	}
+	return seed, nil
+}
+
+func (s *envCredentialSource) read(ctx context.Context, ref string) (string, error) {
+	return "", fmt.Errorf("secret reference %s cannot be read from the environment", ref)
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestEnvCredentialSource(t *testing.T) {
+	secretFile := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(secretFile, []byte("secret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIA")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "")
+	t.Setenv("AWS_SECRET_ACCESS_KEY_FILE", secretFile)
+	t.Setenv("OP_AWS_MFA_SEED", "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ")
+	source := &envCredentialSource{
+		OpAwsItem: OpAwsItem{
+			Item:                 envCredentialItem,
+			AccessKeyIDField:     "Access key ID",
+			SecretAccessKeyField: "Secret access key",
+		},
+		now: func() time.Time { return time.Unix(59, 0) },
+	}
+	ctx := context.Background()
+
+	creds, err := source.Retrieve(ctx)
+	if err != nil {
+		t.Fatalf("Retrieve() error = %v", err)
+	}
+	if creds.AccessKeyID != "AKIA" || creds.SecretAccessKey != "secret" {
+		t.Errorf("Retrieve() = %q, %q, want %q, %q", creds.AccessKeyID, creds.SecretAccessKey, "AKIA", "secret")
+	}
+	if got, err := source.totp(ctx, ""); err != nil || got != "287082" {
+		t.Errorf("totp() = %q, %v, want %q", got, err, "287082")
+	}
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	if _, err := source.Retrieve(ctx); err == nil {
+		t.Error("Retrieve() error = nil, want error for an unset AWS_ACCESS_KEY_ID")
+	}
+}
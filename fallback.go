@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+// fallbackSTSClient retries calls that cannot reach STS in the client's
+// region against each fallback region in order. GetSessionToken and
+// AssumeRole work from any region, so a regional STS incident need not fail
+// the whole command.
+type fallbackSTSClient struct {
+	*sts.Client
+	regions []string
+	// timeout bounds each attempt so a hung network cannot block the
+	// caller indefinitely. Zero means no timeout.
+	timeout time.Duration
+	// settings, when set, supplies a region for every call.
+	settings stsRegionSource
+}
+
+// stsRegionSource supplies the STS region that overrides the config when a
+// session is minted. An empty region leaves the config in place.
+type stsRegionSource interface {
+	stsRegion(ctx context.Context) (string, error)
+}
+
+// withSettings returns optFns preceded by the region of settings, so
+// fallback regions still take its place.
+func (c *fallbackSTSClient) withSettings(ctx context.Context, optFns []func(*sts.Options)) ([]func(*sts.Options), error) {
+	if c.settings == nil {
+		return optFns, nil
+	}
+	region, err := c.settings.stsRegion(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if region != "" {
+		optFns = append([]func(*sts.Options){func(o *sts.Options) {
+			o.Region = region
+		}}, optFns...)
+	}
+	return optFns, nil
+}
+
+func (c *fallbackSTSClient) GetSessionToken(ctx context.Context, params *sts.GetSessionTokenInput, optFns ...func(*sts.Options)) (*sts.GetSessionTokenOutput, error) {
+	optFns, err := c.withSettings(ctx, optFns)
+	if err != nil {
+		return nil, err
+	}
+	return withRegionFallback(ctx, c.timeout, c.regions, optFns, func(ctx context.Context, optFns ...func(*sts.Options)) (*sts.GetSessionTokenOutput, error) {
+		return c.Client.GetSessionToken(ctx, params, optFns...)
+	})
+}
+
+func (c *fallbackSTSClient) AssumeRole(ctx context.Context, params *sts.AssumeRoleInput, optFns ...func(*sts.Options)) (*sts.AssumeRoleOutput, error) {
+	optFns, err := c.withSettings(ctx, optFns)
+	if err != nil {
+		return nil, err
+	}
+	return withRegionFallback(ctx, c.timeout, c.regions, optFns, func(ctx context.Context, optFns ...func(*sts.Options)) (*sts.AssumeRoleOutput, error) {
+		return c.Client.AssumeRole(ctx, params, optFns...)
+	})
+}
+
+func (c *fallbackSTSClient) AssumeRoot(ctx context.Context, params *sts.AssumeRootInput, optFns ...func(*sts.Options)) (*sts.AssumeRootOutput, error) {
+	optFns, err := c.withSettings(ctx, optFns)
+	if err != nil {
+		return nil, err
+	}
+	return withRegionFallback(ctx, c.timeout, c.regions, optFns, func(ctx context.Context, optFns ...func(*sts.Options)) (*sts.AssumeRootOutput, error) {
+		return c.Client.AssumeRoot(ctx, params, optFns...)
+	})
+}
+
+func (c *fallbackSTSClient) GetFederationToken(ctx context.Context, params *sts.GetFederationTokenInput, optFns ...func(*sts.Options)) (*sts.GetFederationTokenOutput, error) {
+	optFns, err := c.withSettings(ctx, optFns)
+	if err != nil {
+		return nil, err
+	}
+	return withRegionFallback(ctx, c.timeout, c.regions, optFns, func(ctx context.Context, optFns ...func(*sts.Options)) (*sts.GetFederationTokenOutput, error) {
+		return c.Client.GetFederationToken(ctx, params, optFns...)
+	})
+}
+
+func (c *fallbackSTSClient) AssumeRoleWithWebIdentity(ctx context.Context, params *sts.AssumeRoleWithWebIdentityInput, optFns ...func(*sts.Options)) (*sts.AssumeRoleWithWebIdentityOutput, error) {
+	optFns, err := c.withSettings(ctx, optFns)
+	if err != nil {
+		return nil, err
+	}
+	return withRegionFallback(ctx, c.timeout, c.regions, optFns, func(ctx context.Context, optFns ...func(*sts.Options)) (*sts.AssumeRoleWithWebIdentityOutput, error) {
+		return c.Client.AssumeRoleWithWebIdentity(ctx, params, optFns...)
+	})
+}
+
+func (c *fallbackSTSClient) GetCallerIdentity(ctx context.Context, params *sts.GetCallerIdentityInput, optFns ...func(*sts.Options)) (*sts.GetCallerIdentityOutput, error) {
+	optFns, err := c.withSettings(ctx, optFns)
+	if err != nil {
+		return nil, err
+	}
+	return withRegionFallback(ctx, c.timeout, c.regions, optFns, func(ctx context.Context, optFns ...func(*sts.Options)) (*sts.GetCallerIdentityOutput, error) {
+		return c.Client.GetCallerIdentity(ctx, params, optFns...)
+	})
+}
+
+// withRegionFallback calls call, then calls it again for each region as long
+// as STS is unreachable. Each call is bounded by timeout when it is set.
+func withRegionFallback[T any](ctx context.Context, timeout time.Duration, regions []string, optFns []func(*sts.Options), call func(context.Context, ...func(*sts.Options)) (T, error)) (T, error) {
+	attempt := func(optFns ...func(*sts.Options)) (T, error) {
+		if timeout <= 0 {
+			return call(ctx, optFns...)
+		}
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		out, err := call(ctx, optFns...)
+		if errors.Is(err, context.DeadlineExceeded) && ctx.Err() != nil {
+			err = fmt.Errorf("sts call timed out after %s: %w", timeout, err)
+		}
+		return out, err
+	}
+
+	out, err := attempt(optFns...)
+	for _, region := range regions {
+		if err == nil || !isSTSUnreachable(err) {
+			break
+		}
+		out, err = attempt(append(slices.Clone(optFns), func(o *sts.Options) {
+			o.Region = region
+		})...)
+	}
+	return out, err
+}
+
+// isSTSUnreachable reports whether err means the request never reached STS
+// or STS failed on its side, as opposed to STS rejecting the request.
+func isSTSUnreachable(err error) bool {
+	var sendErr *smithyhttp.RequestSendError
+	if errors.As(err, &sendErr) {
+		return true
+	}
+	var respErr *awshttp.ResponseError
+	if errors.As(err, &respErr) {
+		return respErr.HTTPStatusCode() >= 500
+	}
+	return false
+}
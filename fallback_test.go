@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+func newResponseError(status int) error {
+	return &awshttp.ResponseError{
+		ResponseError: &smithyhttp.ResponseError{
+			Response: &smithyhttp.Response{Response: &http.Response{StatusCode: status}},
+			Err:      errors.New("status"),
+		},
+	}
+}
+
+func TestIsSTSUnreachable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "send error", err: &smithyhttp.RequestSendError{Err: errors.New("connection refused")}, want: true},
+		{name: "service unavailable", err: newResponseError(http.StatusServiceUnavailable), want: true},
+		{name: "access denied", err: newResponseError(http.StatusForbidden), want: false},
+		{name: "api error", err: &smithy.GenericAPIError{Code: "AccessDenied"}, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isSTSUnreachable(tt.err); got != tt.want {
+				t.Errorf("isSTSUnreachable() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithRegionFallback(t *testing.T) {
+	unreachable := &smithyhttp.RequestSendError{Err: errors.New("connection refused")}
+	denied := &smithy.GenericAPIError{Code: "AccessDenied"}
+
+	tests := []struct {
+		name        string
+		regions     []string
+		errs        map[string]error
+		wantRegions []string
+		wantErr     bool
+	}{
+		{
+			name:        "primary succeeds",
+			regions:     []string{"us-west-2"},
+			wantRegions: []string{"us-east-1"},
+		},
+		{
+			name:        "falls back when unreachable",
+			regions:     []string{"us-west-2", "eu-west-1"},
+			errs:        map[string]error{"us-east-1": unreachable},
+			wantRegions: []string{"us-east-1", "us-west-2"},
+		},
+		{
+			name:        "all regions unreachable",
+			regions:     []string{"us-west-2"},
+			errs:        map[string]error{"us-east-1": unreachable, "us-west-2": unreachable},
+			wantRegions: []string{"us-east-1", "us-west-2"},
+			wantErr:     true,
+		},
+		{
+			name:        "rejected requests are not retried",
+			regions:     []string{"us-west-2"},
+			errs:        map[string]error{"us-east-1": denied},
+			wantRegions: []string{"us-east-1"},
+			wantErr:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var called []string
+			_, err := withRegionFallback(context.Background(), 0, tt.regions, nil, func(ctx context.Context, optFns ...func(*sts.Options)) (struct{}, error) {
+				o := sts.Options{Region: "us-east-1"}
+				for _, fn := range optFns {
+					fn(&o)
+				}
+				called = append(called, o.Region)
+				return struct{}{}, tt.errs[o.Region]
+			})
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("withRegionFallback() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !slices.Equal(called, tt.wantRegions) {
+				t.Errorf("regions = %v, want %v", called, tt.wantRegions)
+			}
+		})
+	}
+}
+
+func TestFallbackSTSClient_Timeout(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	t.Cleanup(srv.Close)
+	t.Cleanup(func() { close(release) })
+
+	client := &fallbackSTSClient{
+		Client:  newRetryingSTSClient(srv.URL, 1),
+		timeout: 50 * time.Millisecond,
+	}
+
+	start := time.Now()
+	_, err := client.GetSessionToken(context.Background(), &sts.GetSessionTokenInput{})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("GetSessionToken() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("GetSessionToken() took %v, want it to time out", elapsed)
+	}
+}
+
+type fakeSTSRegionSource struct {
+	region string
+}
+
+func (f *fakeSTSRegionSource) stsRegion(ctx context.Context) (string, error) {
+	return f.region, nil
+}
+
+func TestFallbackSTSClient_WithSettings(t *testing.T) {
+	client := &fallbackSTSClient{settings: &fakeSTSRegionSource{region: "eu-west-1"}}
+	optFns, err := client.withSettings(context.Background(), []func(*sts.Options){func(o *sts.Options) {
+		o.Region = "ap-northeast-1"
+	}})
+	if err != nil {
+		t.Fatalf("withSettings() error = %v", err)
+	}
+	var o sts.Options
+	for _, fn := range optFns[:1] {
+		fn(&o)
+	}
+	if o.Region != "eu-west-1" {
+		t.Errorf("Region = %q, want %q", o.Region, "eu-west-1")
+	}
+	for _, fn := range optFns {
+		fn(&o)
+	}
+	if o.Region != "ap-northeast-1" {
+		t.Errorf("Region = %q, want later options such as fallback regions to win", o.Region)
+	}
+}
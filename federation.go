@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	ststypes "github.com/aws/aws-sdk-go-v2/service/sts/types"
+)
+
+type GetFederationTokenAPIClient interface {
+	GetFederationToken(ctx context.Context, params *sts.GetFederationTokenInput, optFns ...func(*sts.Options)) (*sts.GetFederationTokenOutput, error)
+}
+
+// FederationTokenProvider issues federated user credentials without MFA.
+// The session is limited to the intersection of the IAM user's permissions
+// and the supplied policies.
+type FederationTokenProvider struct {
+	BaseCredsProvider aws.CredentialsProvider
+	StsClient         GetFederationTokenAPIClient
+	Name              string
+	Policy            string
+	PolicyArns        []string
+	Duration          time.Duration
+}
+
+func (p *FederationTokenProvider) sessionParams() sessionParams {
+	return sessionParams{Policy: p.Policy, PolicyArns: p.PolicyArns}
+}
+
+func (p *FederationTokenProvider) RetrieveStsCredentials(ctx context.Context) (*ststypes.Credentials, error) {
+	if p.Policy == "" && len(p.PolicyArns) == 0 {
+		return nil, errors.New("federation token requires a session policy; the session would have no permissions")
+	}
+
+	if _, err := p.BaseCredsProvider.Retrieve(ctx); err != nil {
+		return nil, err
+	}
+
+	input := &sts.GetFederationTokenInput{
+		Name:            aws.String(p.Name),
+		DurationSeconds: aws.Int32(int32(p.Duration.Seconds())),
+	}
+	if p.Policy != "" {
+		input.Policy = aws.String(p.Policy)
+	}
+	for _, arn := range p.PolicyArns {
+		input.PolicyArns = append(input.PolicyArns, ststypes.PolicyDescriptorType{Arn: aws.String(arn)})
+	}
+
+	out, err := p.StsClient.GetFederationToken(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+	if out == nil || out.Credentials == nil {
+		return nil, errors.New("sts credentials were empty")
+	}
+
+	return out.Credentials, nil
+}
+
+func (p *FederationTokenProvider) Retrieve(ctx context.Context) (aws.Credentials, error) {
+	creds, err := p.RetrieveStsCredentials(ctx)
+	if err != nil {
+		return aws.Credentials{}, err
+	}
+
+	return toAwsCredentials(creds), nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+type fakeFederationClient struct {
+	output    *sts.GetFederationTokenOutput
+	err       error
+	lastInput *sts.GetFederationTokenInput
+}
+
+func (f *fakeFederationClient) GetFederationToken(ctx context.Context, params *sts.GetFederationTokenInput, optFns ...func(*sts.Options)) (*sts.GetFederationTokenOutput, error) {
+	f.lastInput = params
+	return f.output, f.err
+}
+
+func TestFederationTokenProvider_Retrieve(t *testing.T) {
+	expiration := time.Now().Add(12 * time.Hour)
+	stsClient := &fakeFederationClient{
+		output: &sts.GetFederationTokenOutput{Credentials: newStsCreds("ASIA", "SECRET", "TOKEN", expiration)},
+	}
+	provider := &FederationTokenProvider{
+		BaseCredsProvider: &fakeCredsProvider{},
+		StsClient:         stsClient,
+		Name:              "deploy-bot",
+		Policy:            `{"Version":"2012-10-17","Statement":[]}`,
+		PolicyArns:        []string{"arn:aws:iam::aws:policy/ReadOnlyAccess"},
+		Duration:          12 * time.Hour,
+	}
+
+	got, err := provider.Retrieve(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.AccessKeyID != "ASIA" {
+		t.Errorf("AccessKeyID = %q, want %q", got.AccessKeyID, "ASIA")
+	}
+	if got := aws.ToString(stsClient.lastInput.Name); got != "deploy-bot" {
+		t.Errorf("Name = %q, want %q", got, "deploy-bot")
+	}
+	if got := aws.ToString(stsClient.lastInput.Policy); got != provider.Policy {
+		t.Errorf("Policy = %q, want %q", got, provider.Policy)
+	}
+	if len(stsClient.lastInput.PolicyArns) != 1 {
+		t.Errorf("len(PolicyArns) = %d, want 1", len(stsClient.lastInput.PolicyArns))
+	}
+	if got := aws.ToInt32(stsClient.lastInput.DurationSeconds); got != int32((12 * time.Hour).Seconds()) {
+		t.Errorf("DurationSeconds = %d, want %d", got, int32((12 * time.Hour).Seconds()))
+	}
+}
+
+func TestFederationTokenProvider_PolicyRequired(t *testing.T) {
+	baseCreds := &fakeCredsProvider{}
+	stsClient := &fakeFederationClient{}
+	provider := &FederationTokenProvider{
+		BaseCredsProvider: baseCreds,
+		StsClient:         stsClient,
+		Name:              "deploy-bot",
+		Duration:          12 * time.Hour,
+	}
+
+	if _, err := provider.RetrieveStsCredentials(context.Background()); err == nil {
+		t.Fatal("expected error, got nil")
+	}
+	if baseCreds.called != 0 {
+		t.Errorf("baseCreds.called = %d, want 0", baseCreds.called)
+	}
+	if stsClient.lastInput != nil {
+		t.Error("StsClient.GetFederationToken should not have been called")
+	}
+}
+
+func TestFederationTokenProvider_CachePath(t *testing.T) {
+	session := func(provider StsSessionProvider) string {
+		return (&CachedSessionProvider{SessionProvider: provider, CacheDir: "/tmp/cache", Profile: "dev", SessionType: "federation:deploy-bot"}).cachePath()
+	}
+	readOnly := session(&FederationTokenProvider{Name: "deploy-bot", PolicyArns: []string{"arn:aws:iam::aws:policy/ReadOnlyAccess"}})
+	admin := session(&FederationTokenProvider{Name: "deploy-bot", PolicyArns: []string{"arn:aws:iam::aws:policy/AdministratorAccess"}})
+	if readOnly == admin {
+		t.Errorf("cachePath should differ per session policy, got %q for both", admin)
+	}
+	webReadOnly := session(&WebIdentityProvider{Policy: `{"Statement":[{"Effect":"Allow","Action":"s3:Get*","Resource":"*"}]}`})
+	webAdmin := session(&WebIdentityProvider{Policy: `{"Statement":[{"Effect":"Allow","Action":"*","Resource":"*"}]}`})
+	if webReadOnly == webAdmin {
+		t.Errorf("cachePath should differ per web identity session policy, got %q for both", webAdmin)
+	}
+}
+
+var _ StsSessionProvider = (*FederationTokenProvider)(nil)
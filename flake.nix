@@ -23,7 +23,7 @@
           pname = "op-aws-credential-process";
           version = "0.1.1";
           src = ./.;
-          vendorHash = "sha256-UF0NkoWKLoODdcq+mwgcFatEaLeF+ee+wa+/dwot2RM=";
+          vendorHash = "sha256-ht47+TC5r1xqQ3AUlyi64EtJVq+cHVPg1pC/cL+1aoY=";
           ldflags = [
             "-s"
             "-w"
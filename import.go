@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// importCmd moves long-term credentials kept by other tools into 1Password.
+type importCmd struct {
+	AwsVault importAwsVaultCmd `cmd:"" name:"aws-vault" help:"Import credentials from the aws-vault keychain backend into 1Password items and point the profiles at them."`
+}
+
+// importAwsVaultCmd reads credentials stored by aws-vault's keychain backend,
+// creates an API Credential item for each, and sets credential_process of the
+// profile of the same name to read it.
+type importAwsVaultCmd struct {
+	Profiles     []string `arg:"" optional:"" help:"aws-vault credentials to import. Defaults to every one listed by aws-vault list --credentials."`
+	OpVault      string   `required:"" help:"1Password vault to create the items in." name:"op-vault"`
+	OpItemPrefix string   `default:"AWS " help:"Prefix of the titles of the created items, followed by the profile name." name:"op-item-prefix"`
+	OpAccount    string   `help:"1Password account to create the items in." name:"op-account" env:"OP_ACCOUNT"`
+	OpCLIPath    string   `default:"op" help:"Path to 1Password CLI." name:"op-cli-path"`
+	AwsVaultPath string   `default:"aws-vault" help:"Path to aws-vault." name:"aws-vault-path"`
+	SecurityPath string   `default:"/usr/bin/security" help:"Path to security(1)." name:"security-path"`
+	Keychain     string   `default:"aws-vault" help:"Name of the keychain aws-vault stores credentials in." env:"AWS_VAULT_KEYCHAIN_NAME"`
+	NoConfig     bool     `help:"Create the items without rewriting credential_process in the shared config file." name:"no-config"`
+}
+
+func (c *importAwsVaultCmd) Run() error {
+	return c.importAll(context.Background(), os.Stdout)
+}
+
+func (c *importAwsVaultCmd) importAll(ctx context.Context, w io.Writer) error {
+	profiles := c.Profiles
+	if len(profiles) == 0 {
+		out, err := exec.CommandContext(ctx, c.AwsVaultPath, "list", "--credentials").Output()
+		if err != nil {
+			return fmt.Errorf("failed to list aws-vault credentials: %w", err)
+		}
+		profiles = strings.Fields(string(out))
+		if len(profiles) == 0 {
+			return errors.New("aws-vault has no credentials to import")
+		}
+	}
+
+	for _, profile := range profiles {
+		creds, err := c.read(ctx, profile)
+		if err != nil {
+			return fmt.Errorf("profile %s: %w", profile, err)
+		}
+		title := c.OpItemPrefix + profile
+		if err := c.createItem(ctx, title, creds); err != nil {
+			return fmt.Errorf("profile %s: %w", profile, err)
+		}
+		if _, err := fmt.Fprintf(w, "%s: created %s/%s\n", profile, c.OpVault, title); err != nil {
+			return err
+		}
+		if c.NoConfig {
+			continue
+		}
+		if err := setSharedConfigValue(profile, "credential_process", c.credentialProcess(profile, title)); err != nil {
+			return fmt.Errorf("profile %s: %w", profile, err)
+		}
+	}
+	return nil
+}
+
+// read returns the key pair aws-vault stored for profile, as the JSON
+// encoding of aws.Credentials.
+func (c *importAwsVaultCmd) read(ctx context.Context, profile string) (aws.Credentials, error) {
+	keychain := c.Keychain
+	if filepath.Ext(keychain) == "" {
+		keychain += ".keychain-db"
+	}
+	out, err := exec.CommandContext(ctx, c.SecurityPath, "find-generic-password", "-s", "aws-vault", "-a", profile, "-w", keychain).Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == keychainNotFound {
+			return aws.Credentials{}, fmt.Errorf("no aws-vault credentials in %s", keychain)
+		}
+		return aws.Credentials{}, fmt.Errorf("failed to read the aws-vault keychain: %w", err)
+	}
+	var creds aws.Credentials
+	if err := json.Unmarshal(bytes.TrimSpace(out), &creds); err != nil {
+		return aws.Credentials{}, fmt.Errorf("failed to parse aws-vault credentials: %w", err)
+	}
+	if creds.AccessKeyID == "" || creds.SecretAccessKey == "" {
+		return aws.Credentials{}, errors.New("aws-vault credentials have no key pair")
+	}
+	return creds, nil
+}
+
+// createItem creates an API Credential item holding creds. The item is piped
+// to op as a JSON template so the secret never appears in its arguments.
+func (c *importAwsVaultCmd) createItem(ctx context.Context, title string, creds aws.Credentials) error {
+	template, err := json.Marshal(map[string]any{
+		"title":    title,
+		"category": "API_CREDENTIAL",
+		"fields": []map[string]string{
+			{"id": "username", "label": "username", "type": "STRING", "value": creds.AccessKeyID},
+			{"id": "credential", "label": "credential", "type": "CONCEALED", "value": creds.SecretAccessKey},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	args := []string{"item", "create", "--vault", c.OpVault, "--format", "json"}
+	if c.OpAccount != "" {
+		args = append([]string{"--account", c.OpAccount}, args...)
+	}
+	cmd := exec.CommandContext(ctx, c.OpCLIPath, args...)
+	cmd.Stdin = bytes.NewReader(template)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to create 1Password item %s: %w\n%s", title, err, stderr.Bytes())
+	}
+	return nil
+}
+
+// credentialProcess returns the credential_process line reading the item.
+func (c *importAwsVaultCmd) credentialProcess(profile, title string) string {
+	args := []string{"op-aws-credential-process", "--profile", profile, "--op-vault", c.OpVault, "--op-item", title}
+	if c.OpAccount != "" {
+		args = append(args, "--op-account", c.OpAccount)
+	}
+	for i, arg := range args {
+		if strings.ContainsAny(arg, " \t\"'") {
+			args[i] = `"` + strings.ReplaceAll(arg, `"`, `\"`) + `"`
+		}
+	}
+	return strings.Join(args, " ")
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeFakeImportCLIs writes scripts standing in for security(1), which
+// knows the aws-vault credentials of dev, and op, which saves the template
+// piped to item create in templatePath.
+func writeFakeImportCLIs(t *testing.T) (securityPath, opPath, templatePath string) {
+	t.Helper()
+	dir := t.TempDir()
+	securityPath = filepath.Join(dir, "security")
+	opPath = filepath.Join(dir, "op")
+	templatePath = filepath.Join(dir, "template.json")
+	security := `#!/bin/sh
+[ "$1" = find-generic-password ] && [ "$3" = aws-vault ] && [ "$7" = aws-vault.keychain-db ] || exit 1
+[ "$5" = dev ] || exit 44
+echo '{"AccessKeyID":"AKIA","SecretAccessKey":"secret","SessionToken":"","Source":"","CanExpire":false,"Expires":"0001-01-01T00:00:00Z"}'
+`
+	op := "#!/bin/sh\n[ \"$1 $2\" = \"item create\" ] || exit 1\ncat > " + templatePath + "\n"
+	for path, script := range map[string]string{securityPath: security, opPath: op} {
+		if err := os.WriteFile(path, []byte(script), 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return securityPath, opPath, templatePath
+}
+
+func TestImportAwsVaultCmd(t *testing.T) {
+	writeSharedConfig(t, "[profile dev]\nregion = ap-northeast-1\ncredential_process = aws-vault exec dev --json\n\n[profile prod]\nregion = us-east-1\n")
+	securityPath, opPath, templatePath := writeFakeImportCLIs(t)
+	cmd := &importAwsVaultCmd{
+		Profiles:     []string{"dev"},
+		OpVault:      "Private",
+		OpItemPrefix: "AWS ",
+		OpCLIPath:    opPath,
+		SecurityPath: securityPath,
+		Keychain:     "aws-vault",
+	}
+
+	var out bytes.Buffer
+	if err := cmd.importAll(context.Background(), &out); err != nil {
+		t.Fatalf("importAll() error = %v", err)
+	}
+	if got, want := out.String(), "dev: created Private/AWS dev\n"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+	template, err := os.ReadFile(templatePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`"title":"AWS dev"`, `"value":"AKIA"`, `"value":"secret"`, `"category":"API_CREDENTIAL"`} {
+		if !strings.Contains(string(template), want) {
+			t.Errorf("template = %s, want it to contain %s", template, want)
+		}
+	}
+	got, err := sharedConfigValue("dev", "credential_process")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `op-aws-credential-process --profile dev --op-vault Private --op-item "AWS dev"`; got != want {
+		t.Errorf("credential_process = %q, want %q", got, want)
+	}
+
+	cmd.Profiles = []string{"prod"}
+	if err := cmd.importAll(context.Background(), &out); err == nil {
+		t.Error("importAll() error = nil, want error for credentials missing from aws-vault")
+	}
+}
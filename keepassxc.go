@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// keepassxcOTPLabel is the attribute KeePassXC stores an entry's TOTP seed
+// in.
+const keepassxcOTPLabel = "otp"
+
+// keepassxcCredentialSource reads the key pair from an entry of a KeePassXC
+// database with keepassxc-cli. Vault is the database file and Item the entry
+// path. The database is unlocked with the password in KEEPASSXC_PASSWORD, the
+// key file, or both; without either, keepassxc-cli prompts on the terminal.
+type keepassxcCredentialSource struct {
+	cliPath string
+	keyFile string
+	OpAwsItem
+	// attributes holds the entry once read, so the database is unlocked
+	// once per run.
+	attributes map[string]string
+	now        func() time.Time
+}
+
+// entry returns all attributes of the entry, including custom and protected
+// ones such as the password, keyed by name. --all needs keepassxc-cli 2.7.
+func (s *keepassxcCredentialSource) entry(ctx context.Context) (map[string]string, error) {
+	if s.attributes != nil {
+		return s.attributes, nil
+	}
+
+	args := []string{"show", "--all", "--show-protected"}
+	password, hasPassword := os.LookupEnv("KEEPASSXC_PASSWORD")
+	if s.keyFile != "" {
+		args = append(args, "--key-file", s.keyFile)
+		if !hasPassword {
+			args = append(args, "--no-password")
+		}
+	}
+	args = append(args, s.Vault, s.Item)
+
+	cmd := exec.CommandContext(ctx, s.cliPath, args...)
+	if hasPassword {
+		cmd.Stdin = strings.NewReader(password + "\n")
+	} else {
+		cmd.Stdin = os.Stdin
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to show keepassxc entry: %w\n%s", err, stderr.Bytes())
+	}
+
+	attributes := make(map[string]string)
+	for _, line := range strings.Split(string(out), "\n") {
+		name, value, ok := strings.Cut(strings.TrimRight(line, "\r"), ": ")
+		if !ok {
+			continue
+		}
+		if _, dup := attributes[name]; !dup {
+			attributes[name] = value
+		}
+	}
+	if len(attributes) == 0 {
+		return nil, errors.New("keepassxc-cli printed no attributes")
+	}
+	s.attributes = attributes
+	return attributes, nil
+}
+
+func (s *keepassxcCredentialSource) Retrieve(ctx context.Context) (aws.Credentials, error) {
+	return readCredentials(ctx, s)
+}
+
+func (s *keepassxcCredentialSource) awsItem() OpAwsItem {
+	return s.OpAwsItem
+}
+
+func (s *keepassxcCredentialSource) withItem(vault, item string) opItem {
+	other := *s
+	other.Item = item
+	other.attributes = nil
+	return &other
+}
+
+func (s *keepassxcCredentialSource) details(ctx context.Context) (*opItemDetails, error) {
+	attributes, err := s.entry(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &opItemDetails{fields: attributes}, nil
+}
+
+func (s *keepassxcCredentialSource) fields(ctx context.Context, labels ...string) (map[string]string, error) {
+	attributes, err := s.entry(ctx)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]string, len(labels))
+	for _, label := range labels {
+		if value, ok := attributes[label]; ok {
+			values[label] = value
+		}
+	}
+	return values, nil
+}
+
+// totp computes the current code from the entry's seed rather than running
+// keepassxc-cli show --totp, which would unlock the database again.
+func (s *keepassxcCredentialSource) totp(ctx context.Context, label string) (string, error) {
+	seed, err := s.otpSeed(ctx, label)
+	if err != nil {
+		return "", err
+	}
+	now := s.now
+	if now == nil {
+		now = time.Now
+	}
+	return totpCode(seed, now())
+}
+
+func (s *keepassxcCredentialSource) otpSeed(ctx context.Context, label string) (string, error) {
+	if label == "" {
+		label = keepassxcOTPLabel
+	}
+	attributes, err := s.entry(ctx)
+	if err != nil {
+		return "", err
+	}
+	seed := attributes[label]
+	if seed == "" {
+		return "", fmt.Errorf("keepassxc entry %s has no TOTP in attribute %q", s.Item, label)
+	}
+	return seed, nil
+}
+
+func (s *keepassxcCredentialSource) read(ctx context.Context, ref string) (string, error) {
+	return "", fmt.Errorf("secret reference %s cannot be read from keepassxc", ref)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeFakeKeepassxcCLI writes a shell script standing in for keepassxc-cli.
+// It prints the entry when unlocked with the password hunter2 on stdin, or
+// with a key file and --no-password, and records its arguments in argsPath.
+func writeFakeKeepassxcCLI(t *testing.T, entry string) (cliPath, argsPath string) {
+	t.Helper()
+	dir := t.TempDir()
+	cliPath = filepath.Join(dir, "keepassxc-cli")
+	argsPath = filepath.Join(dir, "args")
+	script := "#!/bin/sh\necho \"$@\" > " + argsPath + "\n" +
+		"case \"$*\" in *--no-password*) ;; *) read -r password; [ \"$password\" = hunter2 ] || { echo 'Invalid credentials' >&2; exit 1; } ;; esac\n" +
+		"cat <<'EOF'\n" + entry + "\nEOF\n"
+	if err := os.WriteFile(cliPath, []byte(script), 0700); err != nil {
+		t.Fatal(err)
+	}
+	return cliPath, argsPath
+}
+
+func TestKeepassxcCredentialSource(t *testing.T) {
+	const entry = "Title: iam-user\nUserName: alice\nPassword: hunter3\nURL: \nNotes: \nAccess key ID: AKIA\nSecret access key: secret\notp: otpauth://totp/aws?secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+	tests := []struct {
+		name     string
+		password string
+		keyFile  string
+		wantArgs string
+		wantErr  bool
+	}{
+		{name: "password", password: "hunter2", wantArgs: "show --all --show-protected aws.kdbx AWS/iam-user"},
+		{name: "key file", keyFile: "aws.keyx", wantArgs: "show --all --show-protected --key-file aws.keyx --no-password aws.kdbx AWS/iam-user"},
+		{name: "wrong password", password: "hunter3", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.password != "" {
+				t.Setenv("KEEPASSXC_PASSWORD", tt.password)
+			}
+			cliPath, argsPath := writeFakeKeepassxcCLI(t, entry)
+			source := &keepassxcCredentialSource{
+				cliPath: cliPath,
+				keyFile: tt.keyFile,
+				OpAwsItem: OpAwsItem{
+					Vault:                "aws.kdbx",
+					Item:                 "AWS/iam-user",
+					AccessKeyIDField:     "Access key ID",
+					SecretAccessKeyField: "Secret access key",
+				},
+				now: func() time.Time { return time.Unix(59, 0) },
+			}
+
+			creds, err := source.Retrieve(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Retrieve() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if creds.AccessKeyID != "AKIA" || creds.SecretAccessKey != "secret" {
+				t.Errorf("Retrieve() = %q, %q, want %q, %q", creds.AccessKeyID, creds.SecretAccessKey, "AKIA", "secret")
+			}
+			args, err := os.ReadFile(argsPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.TrimSpace(string(args)); got != tt.wantArgs {
+				t.Errorf("keepassxc-cli args = %q, want %q", got, tt.wantArgs)
+			}
+
+			// The TOTP is computed from the entry already read.
+			source.cliPath = "/nonexistent"
+			if got, err := source.totp(context.Background(), ""); err != nil || got != "287082" {
+				t.Errorf("totp() = %q, %v, want %q", got, err, "287082")
+			}
+		})
+	}
+}
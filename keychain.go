@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// keychainNotFound is the exit status of security when no item matches.
+const keychainNotFound = 44
+
+// keychainCredentialSource reads the key pair from generic passwords in the
+// macOS login keychain with security(1). Item is the service, and each field
+// is the item of that service whose account is the field label, e.g.
+// "Access key ID". macOS asks before releasing an item to a program that is
+// not on its access list.
+type keychainCredentialSource struct {
+	cliPath string
+	OpAwsItem
+	now func() time.Time
+}
+
+// password returns the password of the item with account label, and false
+// when there is none.
+func (s *keychainCredentialSource) password(ctx context.Context, label string) (string, bool, error) {
+	out, err := exec.CommandContext(ctx, s.cliPath, "find-generic-password", "-s", s.Item, "-a", label, "-w").Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			if exitErr.ExitCode() == keychainNotFound {
+				return "", false, nil
+			}
+			return "", false, fmt.Errorf("failed to read keychain item %s/%s: %w\n%s", s.Item, label, err, exitErr.Stderr)
+		}
+		return "", false, err
+	}
+	return strings.TrimSuffix(string(out), "\n"), true, nil
+}
+
+func (s *keychainCredentialSource) Retrieve(ctx context.Context) (aws.Credentials, error) {
+	return readCredentials(ctx, s)
+}
+
+func (s *keychainCredentialSource) awsItem() OpAwsItem {
+	return s.OpAwsItem
+}
+
+func (s *keychainCredentialSource) withItem(vault, item string) opItem {
+	other := *s
+	other.Item = item
+	return &other
+}
+
+// details returns nil since the items of a service cannot be listed without
+// unlocking each of them.
+func (s *keychainCredentialSource) details(ctx context.Context) (*opItemDetails, error) {
+	return nil, nil
+}
+
+func (s *keychainCredentialSource) fields(ctx context.Context, labels ...string) (map[string]string, error) {
+	values := make(map[string]string, len(labels))
+	for _, label := range labels {
+		value, ok, err := s.password(ctx, label)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			values[label] = value
+		}
+	}
+	return values, nil
+}
+
+// keychainOTPLabel is the account the MFA seed is stored under when no label
+// is given.
+const keychainOTPLabel = "totp"
+
+func (s *keychainCredentialSource) totp(ctx context.Context, label string) (string, error) {
+	seed, err := s.otpSeed(ctx, label)
+	if err != nil {
+		return "", err
+	}
+	now := s.now
+	if now == nil {
+		now = time.Now
+	}
+	return totpCode(seed, now())
+}
+
+func (s *keychainCredentialSource) otpSeed(ctx context.Context, label string) (string, error) {
+	if label == "" {
+		label = keychainOTPLabel
+	}
+	seed, ok, err := s.password(ctx, label)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", fmt.Errorf("missing keychain item %s/%s", s.Item, label)
+	}
+	return seed, nil
+}
+
+func (s *keychainCredentialSource) read(ctx context.Context, ref string) (string, error) {
+	return "", fmt.Errorf("secret reference %s cannot be read from the keychain", ref)
+}
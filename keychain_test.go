@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeFakeSecurityCLI writes a shell script standing in for security(1)
+// that knows the accounts of the aws/iam-user service.
+func writeFakeSecurityCLI(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "security")
+	script := `#!/bin/sh
+[ "$1" = find-generic-password ] && [ "$3" = aws/iam-user ] || exit 1
+case "$5" in
+"Access key ID") echo AKIA ;;
+"Secret access key") echo secret ;;
+totp) echo GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ ;;
+*) echo 'security: SecKeychainSearchCopyNext: The specified item could not be found in the keychain.' >&2; exit 44 ;;
+esac
+`
+	if err := os.WriteFile(path, []byte(script), 0700); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestKeychainCredentialSource(t *testing.T) {
+	source := &keychainCredentialSource{
+		cliPath: writeFakeSecurityCLI(t),
+		OpAwsItem: OpAwsItem{
+			Item:                 "aws/iam-user",
+			AccessKeyIDField:     "Access key ID",
+			SecretAccessKeyField: "Secret access key",
+		},
+		now: func() time.Time { return time.Unix(59, 0) },
+	}
+	ctx := context.Background()
+
+	creds, err := source.Retrieve(ctx)
+	if err != nil {
+		t.Fatalf("Retrieve() error = %v", err)
+	}
+	if creds.AccessKeyID != "AKIA" || creds.SecretAccessKey != "secret" {
+		t.Errorf("Retrieve() = %q, %q, want %q, %q", creds.AccessKeyID, creds.SecretAccessKey, "AKIA", "secret")
+	}
+	if got, err := source.totp(ctx, ""); err != nil || got != "287082" {
+		t.Errorf("totp() = %q, %v, want %q", got, err, "287082")
+	}
+
+	values, err := source.fields(ctx, "missing")
+	if err != nil {
+		t.Fatalf("fields() error = %v", err)
+	}
+	if _, ok := values["missing"]; ok {
+		t.Errorf("fields() = %v, want the missing item absent", values)
+	}
+	if _, err := source.withItem("", "other").fields(ctx, "Access key ID"); err == nil {
+		t.Error("fields() error = nil, want error from security")
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// keyringService is the service secrets of op-aws-credential-process are
+// kept under in the OS keyring, one account each.
+const keyringService = "op-aws-credential-process"
+
+// keyring keeps secrets in the user's OS keyring, such as the keys that
+// encrypt files at rest.
+type keyring interface {
+	// secret returns the secret stored for account, and false when there
+	// is none yet.
+	secret(ctx context.Context, account string) ([]byte, bool, error)
+	storeSecret(ctx context.Context, account string, secret []byte) error
+	// deleteSecret deletes the secret stored for account, if any.
+	deleteSecret(ctx context.Context, account string) error
+}
+
+// newKeyring returns the keyring of the OS, through the same tools the
+// keychain, wincred, and secret-service backends read.
+func newKeyring(goos, secretToolPath string) keyring {
+	switch goos {
+	case "darwin":
+		return &securityKeyring{cliPath: "/usr/bin/security"}
+	case "windows":
+		return &wincredKeyring{read: readWincred, write: writeWincred, remove: deleteWincred}
+	default:
+		return &secretToolKeyring{cliPath: secretToolPath}
+	}
+}
+
+// securityKeyring keeps keys in the macOS login keychain.
+type securityKeyring struct {
+	cliPath string
+}
+
+func (k *securityKeyring) secret(ctx context.Context, account string) ([]byte, bool, error) {
+	source := &keychainCredentialSource{cliPath: k.cliPath, OpAwsItem: OpAwsItem{Item: keyringService}}
+	encoded, ok, err := source.password(ctx, account)
+	if err != nil || !ok {
+		return nil, ok, err
+	}
+	secret, err := base64.StdEncoding.DecodeString(encoded)
+	return secret, true, err
+}
+
+func (k *securityKeyring) storeSecret(ctx context.Context, account string, secret []byte) error {
+	// security takes the secret only as an argument, so the command goes to
+	// its interactive mode on stdin, keeping the secret out of ps.
+	cmd := exec.CommandContext(ctx, k.cliPath, "-i")
+	cmd.Stdin = strings.NewReader(fmt.Sprintf("add-generic-password -U -s %q -a %q -w %q\n", keyringService, account, base64.StdEncoding.EncodeToString(secret)))
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to store %s in the keychain: %w\n%s", account, err, out)
+	}
+	return nil
+}
+
+func (k *securityKeyring) deleteSecret(ctx context.Context, account string) error {
+	out, err := exec.CommandContext(ctx, k.cliPath, "delete-generic-password", "-s", keyringService, "-a", account).CombinedOutput()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == keychainNotFound {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete %s from the keychain: %w\n%s", account, err, out)
+	}
+	return nil
+}
+
+// secretToolKeyring keeps keys with the Secret Service.
+type secretToolKeyring struct {
+	cliPath string
+}
+
+func (k *secretToolKeyring) secret(ctx context.Context, account string) ([]byte, bool, error) {
+	source := &secretServiceCredentialSource{cliPath: k.cliPath, OpAwsItem: OpAwsItem{Item: keyringService}}
+	encoded, ok, err := source.secret(ctx, account)
+	if err != nil || !ok {
+		return nil, ok, err
+	}
+	secret, err := base64.StdEncoding.DecodeString(encoded)
+	return secret, true, err
+}
+
+func (k *secretToolKeyring) storeSecret(ctx context.Context, account string, secret []byte) error {
+	cmd := exec.CommandContext(ctx, k.cliPath, "store", "--label", "op-aws-credential-process "+account, "service", keyringService, "account", account)
+	cmd.Stdin = bytes.NewReader([]byte(base64.StdEncoding.EncodeToString(secret)))
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to store %s with secret-tool: %w\n%s", account, err, out)
+	}
+	return nil
+}
+
+func (k *secretToolKeyring) deleteSecret(ctx context.Context, account string) error {
+	if out, err := exec.CommandContext(ctx, k.cliPath, "clear", "service", keyringService, "account", account).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to delete %s with secret-tool: %w\n%s", account, err, out)
+	}
+	return nil
+}
+
+// wincredKeyring keeps keys in the Windows Credential Manager.
+type wincredKeyring struct {
+	read   func(target string) (user, password string, ok bool, err error)
+	write  func(target, user, password string) error
+	remove func(target string) error
+}
+
+func (k *wincredKeyring) secret(ctx context.Context, account string) ([]byte, bool, error) {
+	_, encoded, ok, err := k.read(keyringService + "/" + account)
+	if err != nil || !ok {
+		return nil, ok, err
+	}
+	secret, err := base64.StdEncoding.DecodeString(encoded)
+	return secret, true, err
+}
+
+func (k *wincredKeyring) storeSecret(ctx context.Context, account string, secret []byte) error {
+	return k.write(keyringService+"/"+account, account, base64.StdEncoding.EncodeToString(secret))
+}
+
+func (k *wincredKeyring) deleteSecret(ctx context.Context, account string) error {
+	return k.remove(keyringService + "/" + account)
+}
+
+// sealed is data encrypted by keyringCipher, as written to disk.
+type sealed struct {
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// keyringCipher encrypts with AES-GCM under a random key kept in the keyring
+// under account. The key is created on first use and read once.
+type keyringCipher struct {
+	keyring keyring
+	account string
+
+	aead cipher.AEAD
+}
+
+func (c *keyringCipher) load(ctx context.Context, create bool) (cipher.AEAD, error) {
+	if c.aead != nil {
+		return c.aead, nil
+	}
+	key, ok, err := c.keyring.secret(ctx, c.account)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		if !create {
+			return nil, fmt.Errorf("the %s key is missing from the keyring", c.account)
+		}
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+		if err := c.keyring.storeSecret(ctx, c.account, key); err != nil {
+			return nil, err
+		}
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if c.aead, err = cipher.NewGCM(block); err != nil {
+		return nil, err
+	}
+	return c.aead, nil
+}
+
+// seal encrypts plaintext, authenticating additionalData along with it.
+func (c *keyringCipher) seal(ctx context.Context, plaintext, additionalData []byte) (*sealed, error) {
+	aead, err := c.load(ctx, true)
+	if err != nil {
+		return nil, err
+	}
+	s := &sealed{Nonce: make([]byte, aead.NonceSize())}
+	if _, err := rand.Read(s.Nonce); err != nil {
+		return nil, err
+	}
+	s.Ciphertext = aead.Seal(nil, s.Nonce, plaintext, additionalData)
+	return s, nil
+}
+
+// open decrypts s, which must have been sealed with the same additionalData.
+func (c *keyringCipher) open(ctx context.Context, s *sealed, additionalData []byte) ([]byte, error) {
+	aead, err := c.load(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	if len(s.Nonce) != aead.NonceSize() {
+		return nil, errors.New("invalid nonce")
+	}
+	plaintext, err := aead.Open(nil, s.Nonce, s.Ciphertext, additionalData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt: %w", err)
+	}
+	return plaintext, nil
+}
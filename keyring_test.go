@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newFakeKeyring returns a keyring kept in memory.
+func newFakeKeyring() *wincredKeyring {
+	stored := make(map[string]string)
+	return &wincredKeyring{
+		read: func(target string) (string, string, bool, error) {
+			password, ok := stored[target]
+			return "", password, ok, nil
+		},
+		write: func(target, user, password string) error {
+			stored[target] = password
+			return nil
+		},
+		remove: func(target string) error {
+			delete(stored, target)
+			return nil
+		},
+	}
+}
+
+func TestKeyringCipher(t *testing.T) {
+	ctx := context.Background()
+	ring := newFakeKeyring()
+
+	if _, err := (&keyringCipher{keyring: ring, account: "test"}).open(ctx, &sealed{}, nil); err == nil {
+		t.Error("open() error = nil, want error without a key")
+	}
+
+	sealer := &keyringCipher{keyring: ring, account: "test"}
+	s, err := sealer.seal(ctx, []byte("secret"), []byte("ad"))
+	if err != nil {
+		t.Fatalf("seal() error = %v", err)
+	}
+	if bytes.Contains(s.Ciphertext, []byte("secret")) {
+		t.Error("seal() left the plaintext readable")
+	}
+
+	// A second run reads the key the first one stored.
+	opener := &keyringCipher{keyring: ring, account: "test"}
+	got, err := opener.open(ctx, s, []byte("ad"))
+	if err != nil {
+		t.Fatalf("open() error = %v", err)
+	}
+	if string(got) != "secret" {
+		t.Errorf("open() = %q, want %q", got, "secret")
+	}
+	if _, err := opener.open(ctx, s, []byte("other")); err == nil {
+		t.Error("open() error = nil, want error for other additional data")
+	}
+	if _, err := (&keyringCipher{keyring: ring, account: "other"}).open(ctx, s, []byte("ad")); err == nil {
+		t.Error("open() error = nil, want error for the key of another account")
+	}
+}
+
+func TestSecurityKeyring_StoreSecret(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "security")
+	script := `#!/bin/sh
+echo "$@" > "` + dir + `/args"
+cat > "` + dir + `/stdin"
+`
+	if err := os.WriteFile(path, []byte(script), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := (&securityKeyring{cliPath: path}).storeSecret(context.Background(), "test", []byte("secret")); err != nil {
+		t.Fatalf("storeSecret() error = %v", err)
+	}
+	args, err := os.ReadFile(filepath.Join(dir, "args"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.TrimSpace(string(args)); got != "-i" {
+		t.Errorf("security args = %q, want %q", got, "-i")
+	}
+	stdin, err := os.ReadFile(filepath.Join(dir, "stdin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "add-generic-password -U -s \"op-aws-credential-process\" -a \"test\" -w \"c2VjcmV0\"\n"; string(stdin) != want {
+		t.Errorf("security stdin = %q, want %q", stdin, want)
+	}
+}
+
+func TestKeyringCipher_SecurityKeyring(t *testing.T) {
+	// The fake security keeps the one password it is given on stdin and logs
+	// every command line it is run with.
+	dir := t.TempDir()
+	path := filepath.Join(dir, "security")
+	script := `#!/bin/sh
+echo "$@" >> "` + dir + `/args"
+case "$1" in
+-i) sed -n 's/.* -w "\(.*\)"$/\1/p' > "` + dir + `/password" ;;
+find-generic-password) cat "` + dir + `/password" 2>/dev/null || exit 44 ;;
+*) exit 1 ;;
+esac
+`
+	if err := os.WriteFile(path, []byte(script), 0700); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	s, err := (&keyringCipher{keyring: &securityKeyring{cliPath: path}, account: cacheKeyAccount}).seal(ctx, []byte("secret"), nil)
+	if err != nil {
+		t.Fatalf("seal() error = %v", err)
+	}
+	if got, err := (&keyringCipher{keyring: &securityKeyring{cliPath: path}, account: cacheKeyAccount}).open(ctx, s, nil); err != nil || string(got) != "secret" {
+		t.Fatalf("open() = %q, %v, want %q", got, err, "secret")
+	}
+
+	key, err := os.ReadFile(filepath.Join(dir, "password"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	args, err := os.ReadFile(filepath.Join(dir, "args"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if encoded := strings.TrimSpace(string(key)); encoded == "" || strings.Contains(string(args), encoded) {
+		t.Errorf("security args = %q, want the key %q kept out of them", args, encoded)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// lockPollInterval is how often a held lock is retried.
+const lockPollInterval = 100 * time.Millisecond
+
+var (
+	heldLocksMu sync.Mutex
+	// heldLocks are the lock files this process holds. Nested cached
+	// sessions may share a cache file, and file locks do not nest.
+	heldLocks = make(map[string]bool)
+)
+
+// lockFile takes an advisory lock on path, waiting while another process
+// holds it, so concurrent invocations do not each prompt for MFA and race on
+// the cache. The returned function releases the lock. A lock this process
+// already holds is not taken again.
+func lockFile(ctx context.Context, path string) (func(), error) {
+	heldLocksMu.Lock()
+	if heldLocks[path] {
+		heldLocksMu.Unlock()
+		return func() {}, nil
+	}
+	heldLocks[path] = true
+	heldLocksMu.Unlock()
+	release := func() {
+		heldLocksMu.Lock()
+		delete(heldLocks, path)
+		heldLocksMu.Unlock()
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		release()
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	unlock := func() {
+		// Closing the file releases the lock.
+		_ = f.Close()
+		release()
+	}
+
+	waiting := false
+	for {
+		ok, err := tryLock(f)
+		if err != nil {
+			unlock()
+			return nil, err
+		}
+		if ok {
+			return unlock, nil
+		}
+		if !waiting {
+			debugLog.Printf("waiting for another invocation holding %s", path)
+			waiting = true
+		}
+		select {
+		case <-ctx.Done():
+			unlock()
+			return nil, ctx.Err()
+		case <-time.After(lockPollInterval):
+		}
+	}
+}
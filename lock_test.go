@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLockFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dev.json.lock")
+	// Another process holding the lock is stood in for by a second open
+	// file, which file locks treat the same way.
+	other, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := tryLock(other); !ok || err != nil {
+		t.Fatalf("tryLock() = %v, %v, want true", ok, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*lockPollInterval)
+	defer cancel()
+	if _, err := lockFile(ctx, path); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("lockFile() error = %v, want it to wait for the other holder", err)
+	}
+
+	if err := other.Close(); err != nil {
+		t.Fatal(err)
+	}
+	unlock, err := lockFile(context.Background(), path)
+	if err != nil {
+		t.Fatalf("lockFile() error = %v", err)
+	}
+	// A nested cached session sharing the file must not wait for itself.
+	done := make(chan error, 1)
+	go func() {
+		nested, err := lockFile(context.Background(), path)
+		if err == nil {
+			nested()
+		}
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("nested lockFile() error = %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("nested lockFile() waited for the lock this process holds")
+	}
+	unlock()
+}
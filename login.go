@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// loginCmd mints sessions for several profiles that share the 1Password item
+// and MFA device. A single GetSessionToken session is minted with one MFA
+// code and every profile is derived from it, since STS does not accept the
+// same code twice.
+type loginCmd struct {
+	SessionFlags `embed:""`
+	Profiles     []string `arg:"" help:"Profiles to mint sessions for. The MFA session is cached under the first."`
+}
+
+func (c *loginCmd) Run() error {
+	if c.FederationToken || c.OpWebIdentityTokenField != "" || c.NoSession {
+		return errors.New("login cannot be combined with --federation-token, --op-web-identity-token-field, or --no-session")
+	}
+	return c.login(context.Background(), os.Stdout)
+}
+
+func (c *loginCmd) login(ctx context.Context, w io.Writer) error {
+	first := c.SessionFlags
+	first.Profile = c.Profiles[0]
+	s, err := first.resolve(ctx)
+	if err != nil {
+		return err
+	}
+	duration := defaultSessionDuration
+	if c.Duration != 0 {
+		duration = c.Duration
+	}
+	mfaSession := s.builder.sessionToken(first.Profile, duration)
+
+	for _, profile := range c.Profiles {
+		flags := c.SessionFlags
+		flags.Profile = profile
+		s, err := flags.resolveShared(ctx, mfaSession)
+		if err != nil {
+			return fmt.Errorf("profile %s: %w", profile, err)
+		}
+		if s.builder.mfaSerial != mfaSession.MfaSerial {
+			return fmt.Errorf("profile %s uses a different MFA device than %s", profile, first.Profile)
+		}
+		creds, err := s.creds.Retrieve(ctx)
+		if err != nil {
+			return fmt.Errorf("profile %s: %w", profile, err)
+		}
+		if _, err := fmt.Fprintf(w, "%s: valid until %s\n", profile, creds.Expires.Local().Format(time.RFC3339)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
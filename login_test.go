@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	ststypes "github.com/aws/aws-sdk-go-v2/service/sts/types"
+)
+
+func TestSessionBuilder_MfaSession(t *testing.T) {
+	dir := t.TempDir()
+	shared := &fakeStsSessionProvider{creds: &ststypes.Credentials{
+		AccessKeyId:     aws.String("ASIASHARED"),
+		SecretAccessKey: aws.String("secret"),
+		SessionToken:    aws.String("token"),
+		Expiration:      aws.Time(time.Now().Add(time.Hour)),
+	}}
+	mfaSession := &CachedSessionProvider{SessionProvider: shared, CacheDir: dir, Profile: "dev"}
+	otp := &fakeOTPSource{otp: "123456"}
+	b := &sessionBuilder{otpSource: otp, cacheDir: dir, mfaSerial: "arn:aws:iam::123456789012:mfa/alice", mfaSession: mfaSession}
+
+	role, err := b.build(&config.SharedConfig{Profile: "prod", RoleARN: "arn:aws:iam::123456789012:role/admin"})
+	if err != nil {
+		t.Fatalf("build() error = %v", err)
+	}
+	provider, ok := role.SessionProvider.(*AssumeRoleProvider)
+	if !ok {
+		t.Fatalf("SessionProvider = %T, want *AssumeRoleProvider", role.SessionProvider)
+	}
+	if provider.BaseCredsProvider != mfaSession || provider.MfaSerial != "" {
+		t.Errorf("role is not assumed from the MFA session without MFA")
+	}
+
+	plain, err := b.build(&config.SharedConfig{Profile: "staging"})
+	if err != nil {
+		t.Fatalf("build() error = %v", err)
+	}
+	creds, err := plain.Retrieve(context.Background())
+	if err != nil {
+		t.Fatalf("Retrieve() error = %v", err)
+	}
+	if creds.AccessKeyID != "ASIASHARED" {
+		t.Errorf("AccessKeyID = %q, want the MFA session", creds.AccessKeyID)
+	}
+	if plain.cachePath() == mfaSession.cachePath() {
+		t.Errorf("staging is cached with the MFA session at %s", plain.cachePath())
+	}
+	if otp.called != 0 {
+		t.Errorf("OTP called = %d, want 0", otp.called)
+	}
+}
+
+func TestSessionBuilder_KeyDuration(t *testing.T) {
+	dir := t.TempDir()
+	b := &sessionBuilder{cacheDir: dir, keyDuration: 8 * time.Hour}
+	base := &config.SharedConfig{Profile: "base"}
+
+	session, err := b.build(base)
+	if err != nil {
+		t.Fatalf("build() error = %v", err)
+	}
+	if session.Duration != 8*time.Hour {
+		t.Errorf("Duration = %v, want the duration of the item", session.Duration)
+	}
+	configured, err := (&sessionBuilder{cacheDir: dir}).build(base)
+	if err != nil {
+		t.Fatalf("build() error = %v", err)
+	}
+	if session.cachePath() == configured.cachePath() {
+		t.Errorf("sessions of both durations are cached at %s", session.cachePath())
+	}
+
+	role, err := b.build(&config.SharedConfig{Profile: "admin", RoleARN: "arn:aws:iam::123456789012:role/admin", Source: base})
+	if err != nil {
+		t.Fatalf("build() error = %v", err)
+	}
+	if role.Duration != defaultRoleDuration {
+		t.Errorf("Duration = %v, want a role assumed from the session to keep its own", role.Duration)
+	}
+}
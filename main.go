@@ -1,31 +1,139 @@
 package main
 
 import (
+	"cmp"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
 	"os"
+	"os/user"
 	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/alecthomas/kong"
 	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials/processcreds"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
 	"github.com/aws/aws-sdk-go-v2/service/sts"
 )
 
 var version = "dev"
 
+// SessionFlags select the profile and 1Password item to mint a session from.
+// They are shared by every command.
+type SessionFlags struct {
+	Profile                 string            `default:"default" help:"AWS config profile name."`
+	Duration                time.Duration     `help:"STS session duration. Defaults to duration_seconds from the profile, then 12h (1h for roles)."`
+	OpVault                 string            `help:"1Password vault name or ID. Defaults to the vault of --op-access-key-ref."`
+	OpItem                  string            `help:"1Password item name or ID. Defaults to the item of --op-access-key-ref."`
+	OpAccessKeyIDField      string            `default:"Access key ID" help:"1Password field name for access key ID." name:"op-access-key-id-field"`
+	OpSecretAccessKeyField  string            `default:"Secret access key" help:"1Password field name for secret access key." name:"op-secret-access-key-field"`
+	OpAccessKeyRef          string            `help:"Secret reference (op://vault/item/field) to read the access key ID from." name:"op-access-key-ref"`
+	OpSecretKeyRef          string            `help:"Secret reference (op://vault/item/field) to read the secret access key from." name:"op-secret-key-ref"`
+	OpCLIPath               string            `default:"op" help:"Path to 1Password CLI." name:"op-cli-path"`
+	OpServiceTokenFile      string            `help:"File holding a 1Password service account token, for headless hosts and CI runners." name:"op-service-account-token-file" env:"OP_SERVICE_ACCOUNT_TOKEN_FILE"`
+	OpBackend               string            `help:"How to read 1Password: the op CLI, or the 1Password Go SDK in-process (cli, sdk)." name:"op-backend" env:"OP_AWS_OP_BACKEND" enum:"cli,sdk" default:"cli"`
+	OpItemCache             time.Duration     `help:"Keep the item read from 1Password, encrypted with a key in the OS keyring, for this long, so sessions for several profiles backed by it unlock 1Password once. Zero disables it." name:"op-item-cache" env:"OP_AWS_OP_ITEM_CACHE"`
+	Backend                 string            `help:"Password manager holding the item (1password, bitwarden, pass, vault, keepassxc, keychain, wincred, secret-service, env)." env:"OP_AWS_BACKEND" enum:"1password,bitwarden,pass,vault,keepassxc,keychain,wincred,secret-service,env" default:"1password"`
+	BackendFallback         []string          `help:"Backends to try in order when --backend fails to return the key pair." name:"backend-fallback" env:"OP_AWS_BACKEND_FALLBACK" enum:"1password,bitwarden,pass,vault,keepassxc,keychain,wincred,secret-service,env"`
+	BwItem                  string            `help:"Bitwarden item name or ID, with --backend bitwarden." name:"bw-item"`
+	BwCLIPath               string            `default:"bw" help:"Path to Bitwarden CLI." name:"bw-cli-path"`
+	PassEntry               string            `help:"password-store entry, with --backend pass." name:"pass-entry"`
+	PassCLIPath             string            `default:"pass" help:"Path to pass, or gopass." name:"pass-cli-path"`
+	VaultPath               string            `help:"HashiCorp Vault KV secret path, e.g. secret/aws/iam-user, with --backend vault." name:"vault-path"`
+	VaultAddr               string            `help:"HashiCorp Vault address." name:"vault-addr" env:"VAULT_ADDR" default:"https://127.0.0.1:8200"`
+	VaultKVVersion          int               `help:"Version of the KV secrets engine holding --vault-path (1 or 2)." name:"vault-kv-version" default:"2"`
+	KeepassxcDB             string            `help:"KeePassXC database file, with --backend keepassxc." name:"keepassxc-db" type:"path"`
+	KeepassxcEntry          string            `help:"KeePassXC entry path, e.g. AWS/iam-user, with --backend keepassxc." name:"keepassxc-entry"`
+	KeepassxcKeyFile        string            `help:"Key file that unlocks --keepassxc-db." name:"keepassxc-key-file" type:"path"`
+	KeepassxcCLIPath        string            `default:"keepassxc-cli" help:"Path to keepassxc-cli." name:"keepassxc-cli-path"`
+	KeychainService         string            `help:"macOS keychain service holding the key pair, with --backend keychain." name:"keychain-service"`
+	WincredTarget           string            `help:"Windows Credential Manager target holding the key pair, with --backend wincred." name:"wincred-target"`
+	SecretService           string            `help:"Secret Service (GNOME Keyring, KWallet) service attribute of the key pair, with --backend secret-service." name:"secret-service"`
+	SecretToolPath          string            `default:"secret-tool" help:"Path to secret-tool." name:"secret-tool-path"`
+	VaultRoleID             string            `help:"AppRole role ID to log in to Vault with; the secret ID is read from VAULT_SECRET_ID." name:"vault-role-id" env:"VAULT_ROLE_ID"`
+	OfflineMirror           time.Duration     `help:"Keep an encrypted copy of the key pair, keyed in the OS keyring, and use it for up to this long after it was saved when the backend is unreachable. Zero disables it." name:"offline-mirror"`
+	EncryptCache            bool              `help:"Encrypt cached sessions with a key kept in the OS keyring." name:"encrypt-cache" env:"OP_AWS_ENCRYPT_CACHE"`
+	RefreshMargin           time.Duration     `help:"How long before expiration a cached session is replaced by a new one." name:"refresh-margin" env:"OP_AWS_REFRESH_MARGIN" default:"5m"`
+	RefreshAhead            bool              `help:"Serve a cached session inside --refresh-margin right away and renew it in the background, unless MFA codes are entered by hand." name:"refresh-ahead" env:"OP_AWS_REFRESH_AHEAD"`
+	CacheTTL                time.Duration     `help:"Longest a session is served after it was minted, below the lifetime STS gave it, e.g. 1h for production. Zero serves it until it expires." name:"cache-ttl" env:"OP_AWS_CACHE_TTL"`
+	CacheMaxSessions        int               `default:"100" help:"Most sessions kept in the cache directory; the least recently used are deleted to make room. Zero keeps every one." name:"cache-max-sessions" env:"OP_AWS_CACHE_MAX_SESSIONS"`
+	ForceRefresh            bool              `help:"Mint new sessions even when valid ones are cached, e.g. after changing IAM permissions." name:"force-refresh"`
+	CacheDir                string            `help:"Directory to keep cached sessions in. Defaults to op-aws-credential-process under $XDG_CACHE_HOME or ~/.cache." name:"cache-dir" env:"OP_AWS_HELPER_CACHE_DIR" type:"path"`
+	StateDir                string            `help:"Directory to keep the offline mirror and the chosen MFA devices in. Defaults to op-aws-credential-process under $XDG_STATE_HOME or ~/.local/state." name:"state-dir" env:"OP_AWS_HELPER_STATE_DIR" type:"path"`
+	CacheBackend            string            `help:"Where to cache sessions: files in the cache directory, or the OS keyring (file, keyring)." name:"cache-backend" env:"OP_AWS_CACHE_BACKEND" enum:"file,keyring" default:"file"`
+	OpSessionItem           string            `help:"1Password item to keep sessions in, so your other machines sharing the vault reuse them instead of prompting for MFA again. Created when missing." name:"op-session-item" env:"OP_AWS_OP_SESSION_ITEM"`
+	OpSessionVault          string            `help:"Vault of --op-session-item. Defaults to --op-vault." name:"op-session-vault" env:"OP_AWS_OP_SESSION_VAULT"`
+	AWSCLICache             bool              `help:"Share the sessions of role profiles with the AWS CLI through its cache in ~/.aws/cli/cache." name:"aws-cli-cache" env:"OP_AWS_AWS_CLI_CACHE"`
+	CredentialCommand       string            `help:"Shell command printing the long-term key pair as credential_process JSON, in place of 1Password." name:"credential-command"`
+	OpAccount               string            `help:"1Password account to use when signed in to several, by sign-in address, email, or ID." name:"op-account" env:"OP_ACCOUNT"`
+	RoleArn                 string            `help:"IAM role ARN to assume on top of the profile's session." name:"role-arn"`
+	ExternalID              string            `help:"External ID passed when assuming --role-arn." name:"external-id"`
+	OpRoleArnField          string            `help:"1Password field holding the ARN of an IAM role to assume, in place of --role-arn." name:"op-role-arn-field"`
+	OpExternalIDField       string            `help:"1Password field holding the external ID passed when assuming the role." name:"op-external-id-field"`
+	Tag                     map[string]string `help:"Session tag attached when assuming a role (key=value, repeatable)." name:"tag"`
+	TransitiveTagKey        []string          `help:"Session tag key that persists through role chaining (repeatable)." name:"transitive-tag-key"`
+	OpTagField              []string          `help:"1Password field whose label and value are used as a session tag (repeatable)." name:"op-tag-field"`
+	PolicyFile              string            `help:"JSON session policy file that scopes down the assumed role." name:"policy-file" type:"existingfile"`
+	PolicyArn               []string          `help:"Managed policy ARN that scopes down the assumed role (repeatable)." name:"policy-arn"`
+	RoleSessionName         string            `help:"Role session name recorded in CloudTrail. Defaults to role_session_name from the profile, then user@hostname." name:"role-session-name"`
+	FederationToken         bool              `help:"Issue federated user credentials with GetFederationToken instead of an MFA session." name:"federation-token"`
+	FederationName          string            `help:"Federated user name for --federation-token. Defaults to the local user name." name:"federation-name"`
+	UseFips                 bool              `help:"Use the FIPS STS endpoint. Also enabled by AWS_USE_FIPS_ENDPOINT or use_fips_endpoint in the profile." name:"use-fips"`
+	Region                  string            `help:"AWS region for STS. Defaults to the profile's region, then AWS_REGION or AWS_DEFAULT_REGION."`
+	MfaSerial               string            `help:"MFA device serial number or ARN. Overrides mfa_serial; discovered with iam:ListMFADevices when neither is set." name:"mfa-serial"`
+	OpMfaSerialField        string            `help:"1Password field holding the MFA device ARN, in place of mfa_serial." name:"op-mfa-serial-field"`
+	OpOTP                   bool              `help:"Read the MFA code from the 1Password item's one-time password instead of prompting." name:"op-otp"`
+	OpOTPField              string            `help:"1Password one-time password field to read the MFA code from. Implies --op-otp." name:"op-otp-field"`
+	OpOTPLocal              bool              `help:"Fetch the otpauth:// seed from 1Password once and compute MFA codes locally. Implies --op-otp." name:"op-otp-local"`
+	OpOTPItem               string            `help:"1Password item to read the MFA code from, when it is not the credentials item. Implies --op-otp." name:"op-otp-item"`
+	OpOTPVault              string            `help:"1Password vault of --op-otp-item. Defaults to --op-vault. Implies --op-otp." name:"op-otp-vault"`
+	OTPCommand              string            `help:"Shell command whose stdout is used as the MFA code, e.g. 'ykman oath accounts code -s aws'." name:"otp-command"`
+	OTPCommandTimeout       time.Duration     `help:"Timeout for --otp-command and --yubikey-account, including waiting for a touch." name:"otp-command-timeout" default:"30s"`
+	Askpass                 string            `help:"Askpass program that prints the MFA code, following the SSH_ASKPASS convention." name:"askpass"`
+	Pinentry                string            `help:"pinentry program to prompt for the MFA code with, as gpg-agent does." name:"pinentry"`
+	TokenCode               string            `help:"MFA code collected by a wrapper script; - reads it from stdin." name:"token-code"`
+	OTPSocket               string            `help:"Unix socket to wait on for an external tool to push the MFA code." name:"otp-socket"`
+	ClipboardOTP            bool              `help:"Read the MFA code from the clipboard after confirming it." name:"clipboard-otp"`
+	YubikeyAccount          string            `help:"OATH account on a YubiKey to read the MFA code from with ykman." name:"yubikey-account"`
+	YubikeySerial           string            `help:"Serial number of the YubiKey to use when several are connected." name:"yubikey-serial"`
+	YkmanPath               string            `help:"Path to the YubiKey Manager CLI." name:"ykman-path" default:"ykman"`
+	OTPSources              []string          `help:"OTP sources to try in order until one returns a code (op, yubikey, command, env, askpass, pinentry, socket, clipboard, dialog, tty)." name:"otp-source" env:"OP_AWS_OTP_SOURCES" enum:"op,yubikey,command,env,askpass,pinentry,socket,clipboard,dialog,tty"`
+	TUI                     bool              `help:"Prompt with a countdown of the TOTP window and submit at six digits." name:"tui"`
+	NonInteractive          bool              `help:"Never prompt; fail immediately if an MFA code is needed. Enabled when CI is set." name:"non-interactive" env:"OP_AWS_NON_INTERACTIVE"`
+	Debug                   bool              `help:"Print diagnostics, such as which prompt is used, to stderr." env:"OP_AWS_DEBUG"`
+	PromptTemplate          string            `help:"MFA prompt with {profile}, {mfa_serial}, {vault}, and {item} replaced." name:"prompt-template" env:"OP_AWS_PROMPT_TEMPLATE"`
+	Notify                  bool              `help:"Show a desktop notification when waiting for an MFA code." name:"notify"`
+	PromptTimeout           time.Duration     `help:"Fail if the MFA code is not entered in time. Zero waits forever." name:"prompt-timeout" default:"0s"`
+	MfaAttempts             int               `help:"How many MFA codes to prompt for when STS rejects the code." name:"mfa-attempts" default:"3"`
+	MfaWaitNextWindow       bool              `help:"After STS rejects an MFA code, wait for the next TOTP window before asking again." name:"mfa-wait-next-window"`
+	NoMfa                   bool              `help:"Mint sessions without MFA, skipping mfa_serial and device discovery." name:"no-mfa"`
+	NoSession               bool              `help:"Emit the long-term credentials from 1Password without calling STS." name:"no-session"`
+	AssumeRoot              string            `help:"Member account ID to obtain short-lived root credentials for with AssumeRoot." name:"assume-root"`
+	TaskPolicyArn           string            `help:"Root task policy ARN that scopes the --assume-root credentials." name:"task-policy-arn"`
+	OpRegionField           string            `help:"1Password field holding the STS region, overriding the profile's region." name:"op-region-field"`
+	OpDurationField         string            `help:"1Password field holding the duration of sessions minted from the key, overriding duration_seconds." name:"op-duration-field"`
+	FallbackRegion          []string          `help:"STS region to try when the primary region is unreachable (repeatable)." name:"fallback-region"`
+	Partition               string            `help:"AWS partition (aws, aws-us-gov, aws-cn). Detected from the region when omitted." enum:"aws,aws-us-gov,aws-cn," default:""`
+	EndpointURL             string            `help:"Override the STS endpoint URL, e.g. for LocalStack." name:"endpoint-url" env:"AWS_ENDPOINT_URL_STS"`
+	StsTimeout              time.Duration     `help:"Timeout for each STS call. Zero disables it." name:"sts-timeout" default:"10s"`
+	MaxAttempts             int               `help:"Maximum attempts for each STS call, including the first." name:"max-attempts" default:"5" env:"AWS_MAX_ATTEMPTS"`
+	MaxBackoff              time.Duration     `help:"Maximum jittered backoff between STS retries." name:"max-backoff" default:"20s"`
+	OpWebIdentityTokenField string            `help:"1Password field holding an OIDC token to exchange with AssumeRoleWithWebIdentity." name:"op-web-identity-token-field"`
+}
+
 var cli struct {
-	Profile                string           `default:"default" help:"AWS config profile name."`
-	Duration               time.Duration    `default:"12h" help:"STS session duration."`
-	OpVault                string           `required:"" help:"1Password vault name."`
-	OpItem                 string           `required:"" help:"1Password item name."`
-	OpAccessKeyIDField     string           `default:"Access key ID" help:"1Password field name for access key ID." name:"op-access-key-id-field"`
-	OpSecretAccessKeyField string           `default:"Secret access key" help:"1Password field name for secret access key." name:"op-secret-access-key-field"`
-	OpCLIPath              string           `default:"op" help:"Path to 1Password CLI." name:"op-cli-path"`
-	Version                kong.VersionFlag `help:"Show version."`
+	Process processCmd       `cmd:"" default:"withargs" help:"Print credentials in the credential_process format."`
+	Whoami  whoamiCmd        `cmd:"" help:"Print the identity the credentials resolve to."`
+	Login   loginCmd         `cmd:"" help:"Mint sessions for several profiles sharing an MFA device with one MFA code."`
+	Import  importCmd        `cmd:"" help:"Import credentials kept by other tools into 1Password."`
+	Cache   cacheCmd         `cmd:"" help:"Inspect the session cache."`
+	Version kong.VersionFlag `help:"Show version."`
 }
 
 type OpAwsItem struct {
@@ -33,90 +141,995 @@ type OpAwsItem struct {
 	Item                 string
 	AccessKeyIDField     string
 	SecretAccessKeyField string
+	// AccessKeyIDRef and SecretAccessKeyRef are op:// secret references
+	// read in place of the fields when set.
+	AccessKeyIDRef     string
+	SecretAccessKeyRef string
+	// Account selects the 1Password account when several are signed in.
+	Account string
 }
 
+// exitInteractionRequired is the exit status when an MFA code is needed in
+// non-interactive mode.
+const exitInteractionRequired = 2
+
 func main() {
-	kong.Parse(&cli,
+	kctx := kong.Parse(&cli,
 		kong.Name("op-aws-credential-process"),
 		kong.Description("AWS credential_process implementation that retrieves credentials from 1Password with MFA session caching"),
 		kong.Vars{"version": version},
 	)
 
-	if err := run(); err != nil {
-		fmt.Fprintln(os.Stderr, err)
+	if err := kctx.Run(); err != nil {
+		if errors.Is(err, errInteractionRequired) {
+			// Pipelines can match on the code rather than the message.
+			_ = json.NewEncoder(os.Stderr).Encode(map[string]string{"error": "InteractionRequired", "message": err.Error()})
+			os.Exit(exitInteractionRequired)
+		}
+		fmt.Fprintln(os.Stderr, detectClockSkew(err, time.Now()))
 		os.Exit(1)
 	}
 }
 
-func run() error {
-	ctx := context.Background()
+// session is the credentials the flags resolve to, along with the builder
+// that minted them.
+type session struct {
+	creds   aws.CredentialsProvider
+	builder *sessionBuilder
+}
+
+func (f *SessionFlags) resolve(ctx context.Context) (*session, error) {
+	return f.resolveShared(ctx, nil)
+}
 
-	cfg, err := config.LoadSharedConfigProfile(ctx, cli.Profile)
+// resolveShared resolves the session like resolve, deriving it from
+// mfaSession rather than prompting for MFA when mfaSession is not nil.
+func (f *SessionFlags) resolveShared(ctx context.Context, mfaSession *CachedSessionProvider) (*session, error) {
+	if f.Debug {
+		debugLog.SetOutput(os.Stderr)
+	}
+	dir, err := cacheDir(f.CacheDir)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	cfg, err := loadSharedConfigProfile(ctx, dir, f.Profile)
+	if err != nil {
+		return nil, err
+	}
+	// An explicit --duration takes precedence over duration_seconds of the
+	// selected profile.
+	if f.Duration != 0 {
+		cfg.RoleDurationSeconds = &f.Duration
 	}
 
-	opCLISource := &opCLICredentialSource{
-		cliPath: cli.OpCLIPath,
-		OpAwsItem: OpAwsItem{
-			Vault:                cli.OpVault,
-			Item:                 cli.OpItem,
-			AccessKeyIDField:     cli.OpAccessKeyIDField,
-			SecretAccessKeyField: cli.OpSecretAccessKeyField,
-		},
+	partition, region, err := resolvePartition(f.Partition, resolveRegion(f.Region, cfg.Region))
+	if err != nil {
+		return nil, err
+	}
+	if region == "" && f.OpRegionField != "" {
+		// The item supplies the region when a session is minted; STS in
+		// the partition's default region stands in for items without it.
+		region = partitionRegions[partition]
+	}
+	if region == "" {
+		return nil, fmt.Errorf("region is not set for profile %s; set region in the profile, pass --region, or set AWS_REGION", f.Profile)
+	}
+	for _, r := range f.FallbackRegion {
+		if p := partitionForRegion(r); p != partition {
+			return nil, fmt.Errorf("fallback region %s belongs to partition %s, not %s", r, p, partition)
+		}
+	}
+	mfaSerial := f.MfaSerial
+	if f.NoMfa {
+		if mfaSerial != "" {
+			return nil, errors.New("--no-mfa cannot be combined with --mfa-serial")
+		}
+		if f.OpMfaSerialField != "" {
+			return nil, errors.New("--no-mfa cannot be combined with --op-mfa-serial-field")
+		}
+	} else if mfaSerial == "" && f.OpMfaSerialField == "" {
+		mfaSerial = chainMfaSerial(&cfg)
+	}
+	if err := validateARNPartition("mfa_serial", mfaSerial, partition); err != nil {
+		return nil, err
+	}
+	if err := validateARNPartition("--role-arn", f.RoleArn, partition); err != nil {
+		return nil, err
+	}
+	if err := validateARNPartition("--task-policy-arn", f.TaskPolicyArn, partition); err != nil {
+		return nil, err
+	}
+	for c := &cfg; c != nil; c = c.Source {
+		if err := validateARNPartition("role_arn", c.RoleARN, partition); err != nil {
+			return nil, err
+		}
 	}
 
-	cachedCreds := aws.NewCredentialsCache(opCLISource)
+	opSource, err := f.opSource()
+	if err != nil {
+		return nil, err
+	}
 
-	stsClient := sts.New(sts.Options{
-		Region:      cfg.Region,
-		Credentials: cachedCreds,
-	})
+	// baseSource supplies the long-term key pair STS sessions are minted
+	// from.
+	var baseSource aws.CredentialsProvider = opSource
+	if f.CredentialCommand != "" {
+		if f.OpAccessKeyRef != "" || f.OpSecretKeyRef != "" {
+			return nil, errors.New("--credential-command cannot be combined with --op-access-key-ref or --op-secret-key-ref")
+		}
+		baseSource = &commandCredentialSource{command: f.CredentialCommand}
+	}
 
-	dir, err := cacheDir()
+	state, err := stateDir(f.StateDir)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if f.OfflineMirror > 0 {
+		item := opSource.awsItem()
+		id := strings.Join([]string{f.Backend, item.Account, item.Vault, item.Item, item.AccessKeyIDRef, item.SecretAccessKeyRef, f.CredentialCommand}, "\x00")
+		baseSource = &mirrorCredentialSource{
+			source: baseSource,
+			path:   migrateState(mirrorPath(dir, id), mirrorPath(state, id)),
+			cipher: &keyringCipher{keyring: newKeyring(runtime.GOOS, f.SecretToolPath), account: mirrorKeyAccount},
+			maxAge: f.OfflineMirror,
+		}
+	}
+	cachedCreds := aws.NewCredentialsCache(baseSource)
+	var cacheCipher *keyringCipher
+	if f.EncryptCache {
+		cacheCipher = &keyringCipher{keyring: newKeyring(runtime.GOOS, f.SecretToolPath), account: cacheKeyAccount}
+	}
+	var store cacheStore
+	if f.CacheBackend == "keyring" {
+		store = newKeyringCacheStore(dir, f.SecretToolPath)
+	}
+	var syncStore cacheStore
+	if f.OpSessionItem != "" {
+		if syncStore, err = f.opSessionStore(); err != nil {
+			return nil, err
+		}
+	}
+	var awsCLICache string
+	if f.AWSCLICache {
+		// The AWS CLI reads its cache in plaintext, which would undo both.
+		if f.EncryptCache || store != nil {
+			return nil, errors.New("--aws-cli-cache cannot be combined with --encrypt-cache or --cache-backend keyring")
+		}
+		if !f.awsCLIShareable() {
+			debugLog.Printf("not sharing sessions with the AWS CLI, which does not key its cache on session policies, tags, or fields of the item")
+		} else if awsCLICache, err = awsCLICacheDir(); err != nil {
+			return nil, err
+		}
 	}
 
-	source := &CachedSessionProvider{
-		SessionProvider: &SessionTokenProvider{
-			BaseCredsProvider: cachedCreds,
-			OTPSource:         &ttyOTPSource{},
-			StsClient:         stsClient,
-			MfaSerial:         cfg.MFASerial,
-			Duration:          cli.Duration,
+	globalEndpoint, err := useGlobalSTSEndpoint(f.Profile)
+	if err != nil {
+		return nil, err
+	}
+	if f.MfaAttempts < 1 {
+		return nil, errors.New("--mfa-attempts must be at least 1")
+	}
+	if f.CacheMaxSessions < 0 {
+		return nil, errors.New("--cache-max-sessions must not be negative")
+	}
+	if f.CacheTTL < 0 {
+		return nil, errors.New("--cache-ttl must not be negative")
+	}
+	if f.RefreshMargin < 0 {
+		return nil, errors.New("--refresh-margin must not be negative")
+	}
+	if f.MaxAttempts < 1 {
+		return nil, errors.New("--max-attempts must be at least 1")
+	}
+	retryer := newRetryer(f.MaxAttempts, f.MaxBackoff)
+	stsOptFns := []func(*sts.Options){
+		func(o *sts.Options) {
+			o.Retryer = retryer
 		},
-		CacheDir:     dir,
-		Profile:      cli.Profile,
-		ExpiryWindow: expiryWindow,
-		OpAwsItem:    opCLISource.OpAwsItem,
-		MfaSerial:    cfg.MFASerial,
 	}
+	if globalEndpoint {
+		stsOptFns = append(stsOptFns, func(o *sts.Options) {
+			o.EndpointResolverV2 = &globalEndpointResolver{sts.NewDefaultEndpointResolverV2()}
+		})
+	}
+	fips, err := useFIPSEndpoint(f.UseFips, &cfg)
+	if err != nil {
+		return nil, err
+	}
+	if fips {
+		stsOptFns = append(stsOptFns, func(o *sts.Options) {
+			o.EndpointOptions.UseFIPSEndpoint = aws.FIPSEndpointStateEnabled
+		})
+	}
+	if f.EndpointURL != "" {
+		if len(f.FallbackRegion) > 0 {
+			return nil, errors.New("--fallback-region cannot be combined with --endpoint-url")
+		}
+		stsOptFns = append(stsOptFns, func(o *sts.Options) {
+			o.BaseEndpoint = aws.String(f.EndpointURL)
+		})
+	}
+
+	otpSource, interactiveOTP, err := f.otpSource(opSource)
+	if err != nil {
+		return nil, err
+	}
+	if f.MfaWaitNextWindow {
+		otpSource = &nextWindowOTPSource{source: otpSource}
+	}
+	otpSource = &promptedOTPSource{source: otpSource}
+
+	builder := &sessionBuilder{
+		baseCreds:         cachedCreds,
+		otpSource:         otpSource,
+		region:            region,
+		fallbackRegions:   f.FallbackRegion,
+		stsTimeout:        f.StsTimeout,
+		stsOptFns:         stsOptFns,
+		cacheDir:          dir,
+		cacheCipher:       cacheCipher,
+		cacheStore:        store,
+		awsCLICacheDir:    awsCLICache,
+		forceRefresh:      f.ForceRefresh,
+		refreshMargin:     f.RefreshMargin,
+		maxAge:            f.CacheTTL,
+		maxSessions:       f.CacheMaxSessions,
+		opAwsItem:         opSource.awsItem(),
+		credentialCommand: f.CredentialCommand,
+		mfaSerial:         mfaSerial,
+		roleSessionName:   f.RoleSessionName,
+		otpAttempts:       f.MfaAttempts,
+		mfaSession:        mfaSession,
+		partition:         partition,
+		syncStore:         syncStore,
+	}
+	// A renewal cannot ask for an MFA code, so one entered by hand is
+	// asked for in the foreground as before.
+	if f.RefreshAhead && (!interactiveOTP || f.NoMfa) && !renewing() {
+		builder.renewAhead = sync.OnceValue(startRenewal)
+	}
+	// A background renewal would hide the invocation it was started by.
+	if !renewing() {
+		builder.report = newCacheReport(filepath.Join(state, lastRunFile), time.Now())
+	}
+	// --region and --duration are more specific than the item's fields.
+	settings := &opSettingsSource{source: opSource, partition: partition}
+	if f.Region == "" {
+		settings.regionLabel = f.OpRegionField
+	}
+	if f.Duration == 0 {
+		settings.durationLabel = f.OpDurationField
+	}
+	if settings.regionLabel != "" {
+		builder.settings = settings
+	}
+	if settings.durationLabel != "" {
+		// Sessions are keyed on their duration, so it is read before the
+		// cache rather than when a session is minted.
+		_, duration, err := settings.stsSettings(ctx)
+		if err != nil {
+			return nil, err
+		}
+		builder.keyDuration = duration
+	}
+	if f.NoSession {
+		if cfg.RoleARN != "" || f.RoleArn != "" || f.OpRoleArnField != "" || f.FederationToken || f.OpWebIdentityTokenField != "" || f.AssumeRoot != "" {
+			return nil, errors.New("--no-session cannot be combined with roles, --federation-token, or --op-web-identity-token-field")
+		}
+		return &session{creds: baseSource, builder: builder}, nil
+	}
+
+	if mfaSerial == "" && f.OpMfaSerialField != "" {
+		builder.mfaSerialSource = &opMfaSerialSource{source: opSource, label: f.OpMfaSerialField}
+	} else if mfaSerial == "" && !f.NoMfa && f.EndpointURL != "" {
+		// The endpoint is that of STS; IAM is not assumed to be served
+		// next to it.
+		debugLog.Printf("not discovering the MFA device with --endpoint-url; set --mfa-serial to use one")
+	} else if mfaSerial == "" && !f.NoMfa {
+		iamOptions := iam.Options{Region: region, Credentials: cachedCreds, Retryer: retryer}
+		if fips {
+			iamOptions.EndpointOptions.UseFIPSEndpoint = aws.FIPSEndpointStateEnabled
+		}
+		serialSource := &iamMfaSerialSource{
+			client:     iam.New(iamOptions),
+			choicePath: migrateState(filepath.Join(dir, f.Profile+".mfa-serial"), filepath.Join(state, f.Profile+".mfa-serial")),
+			timeout:    f.StsTimeout,
+		}
+		if !f.nonInteractive() {
+			serialSource.chooser = &ttyOTPSource{label: f.mfaLabel()}
+		}
+		builder.mfaSerialSource = serialSource
+	}
+
+	var policy string
+	if f.PolicyFile != "" {
+		data, err := os.ReadFile(f.PolicyFile)
+		if err != nil {
+			return nil, err
+		}
+		policy = string(data)
+	}
+
+	if f.AssumeRoot != "" && (f.FederationToken || f.OpWebIdentityTokenField != "") {
+		return nil, errors.New("--assume-root cannot be combined with --federation-token or --op-web-identity-token-field")
+	}
+
+	if f.OpWebIdentityTokenField != "" {
+		if f.FederationToken {
+			return nil, errors.New("--op-web-identity-token-field cannot be combined with --federation-token")
+		}
+		if len(f.Tag) > 0 || len(f.TransitiveTagKey) > 0 || len(f.OpTagField) > 0 {
+			return nil, errors.New("session tags are not supported with --op-web-identity-token-field")
+		}
+		if f.OpRoleArnField != "" || f.OpExternalIDField != "" {
+			return nil, errors.New("--op-web-identity-token-field cannot be combined with --op-role-arn-field or --op-external-id-field")
+		}
+		roleArn := f.RoleArn
+		if roleArn == "" {
+			roleArn = cfg.RoleARN
+		}
+		if roleArn == "" {
+			return nil, errors.New("--op-web-identity-token-field requires role_arn or --role-arn")
+		}
+		tokenSource := &opWebIdentityTokenSource{source: opSource, label: f.OpWebIdentityTokenField}
+		source := builder.webIdentity(&cfg, roleArn, tokenSource, policy, f.PolicyArn)
+		return &session{creds: source, builder: builder}, nil
+	}
+
+	if f.FederationToken {
+		if cfg.RoleARN != "" || f.RoleArn != "" || f.OpRoleArnField != "" {
+			return nil, errors.New("--federation-token cannot be combined with role_arn or --role-arn")
+		}
+		if len(f.Tag) > 0 || len(f.TransitiveTagKey) > 0 || len(f.OpTagField) > 0 {
+			return nil, errors.New("session tags are not supported with --federation-token")
+		}
+		name := f.FederationName
+		if name == "" {
+			name = defaultFederationName()
+		}
+		source := builder.federate(&cfg, name, policy, f.PolicyArn)
+		return &session{creds: source, builder: builder}, nil
+	}
+
+	source, err := builder.build(&cfg)
+	if err != nil {
+		return nil, err
+	}
+	if f.OpRoleArnField != "" && f.RoleArn != "" {
+		return nil, errors.New("--op-role-arn-field cannot be combined with --role-arn")
+	}
+	if f.ExternalID != "" && f.RoleArn == "" && f.OpRoleArnField == "" {
+		return nil, errors.New("--external-id requires --role-arn")
+	}
+	profileRole, _ := source.SessionProvider.(*AssumeRoleProvider)
+	if f.OpExternalIDField != "" && f.RoleArn == "" && f.OpRoleArnField == "" && profileRole == nil {
+		return nil, errors.New("--op-external-id-field requires role_arn, --role-arn, or --op-role-arn-field")
+	}
+	if f.RoleArn != "" || f.OpRoleArnField != "" {
+		duration := defaultRoleDuration
+		if f.Duration != 0 {
+			duration = f.Duration
+		}
+		source = builder.assumeRole(source, f.Profile, f.RoleArn, f.ExternalID, builder.sessionName(&cfg), duration)
+		if f.OpRoleArnField != "" {
+			source.SessionType = opRoleSessionType
+		}
+	}
+	if f.OpRoleArnField != "" || f.OpExternalIDField != "" {
+		// The fields are read only when a session is minted, so cached
+		// sessions are served without running op.
+		role := source.SessionProvider.(*AssumeRoleProvider)
+		role.RoleSource = &opRoleSource{source: opSource, roleArnLabel: f.OpRoleArnField, externalIDLabel: f.OpExternalIDField}
+	}
+
+	if len(f.Tag) > 0 || len(f.TransitiveTagKey) > 0 || len(f.OpTagField) > 0 || policy != "" || len(f.PolicyArn) > 0 {
+		role, ok := source.SessionProvider.(*AssumeRoleProvider)
+		if !ok {
+			return nil, errors.New("session tags and policies require role_arn or --role-arn")
+		}
+		role.Tags = f.Tag
+		role.TransitiveTagKeys = f.TransitiveTagKey
+		if len(f.OpTagField) > 0 {
+			role.TagSource = &opTagSource{source: opSource, labels: f.OpTagField}
+		}
+		role.Policy = policy
+		role.PolicyArns = f.PolicyArn
+	}
+
+	if f.AssumeRoot != "" {
+		if f.TaskPolicyArn == "" {
+			return nil, errors.New("--assume-root requires --task-policy-arn")
+		}
+		duration := defaultRootDuration
+		if f.Duration != 0 {
+			duration = f.Duration
+		}
+		source = builder.assumeRoot(source, f.Profile, f.AssumeRoot, f.TaskPolicyArn, duration)
+	} else if f.TaskPolicyArn != "" {
+		return nil, errors.New("--task-policy-arn requires --assume-root")
+	}
+
+	return &session{creds: source, builder: builder}, nil
+}
+
+// awsCLIShareable reports whether role sessions are minted with no
+// parameters but those the AWS CLI keys its cache on, so a session shared
+// through it grants the same to either.
+func (f *SessionFlags) awsCLIShareable() bool {
+	return f.PolicyFile == "" && len(f.PolicyArn) == 0 &&
+		len(f.Tag) == 0 && len(f.TransitiveTagKey) == 0 && len(f.OpTagField) == 0 &&
+		f.OpRoleArnField == "" && f.OpExternalIDField == ""
+}
+
+// otpSource selects where MFA codes come from. Without a flag or
+// OP_AWS_MFA_CODE, the user is prompted on the terminal, or with a dialog
+// when there is none. interactive reports whether the source waits for a
+// person.
+func (f *SessionFlags) otpSource(opSource opItem) (source OTPSource, interactive bool, err error) {
+	prompt := &ttyOTPSource{label: f.mfaLabel(), noTerminal: f.nonInteractive()}
+	askpass, preferAskpass := envAskpass()
+	if askpass != "" {
+		prompt.dialog = newAskpassOTPSource(askpass, f.mfaLabel())
+	} else if dialog := newDialogOTPSource(runtime.GOOS, f.mfaLabel()); dialog != nil {
+		prompt.dialog = dialog
+	}
+
+	if len(f.OTPSources) > 0 {
+		return f.otpSourceChain(prompt, opSource)
+	}
+
+	otpSources := 0
+	for _, set := range []bool{f.TokenCode != "", f.OTPCommand != "", f.opOTP(), f.YubikeyAccount != "", f.Askpass != "", f.Pinentry != "", f.OTPSocket != "", f.ClipboardOTP} {
+		if set {
+			otpSources++
+		}
+	}
+
+	source = prompt
+	if f.TUI {
+		source = &tuiOTPSource{prompt: prompt}
+	}
+	// interactive sources wait for a person and are bounded by
+	// --prompt-timeout; the others have timeouts of their own or answer
+	// immediately.
+	interactive = true
+	switch {
+	case otpSources > 1:
+		return nil, false, errors.New("only one of --token-code, --otp-command, --op-otp, --yubikey-account, --askpass, --pinentry, --otp-socket, and --clipboard-otp can be used")
+	case f.TokenCode != "":
+		source = &tokenCodeOTPSource{code: f.TokenCode}
+		interactive = false
+	case f.Pinentry != "":
+		source = &pinentryOTPSource{cliPath: f.Pinentry, label: f.mfaLabel()}
+	case f.Askpass != "":
+		source = newAskpassOTPSource(f.Askpass, f.mfaLabel())
+	case f.OTPSocket != "":
+		source = &socketOTPSource{path: f.OTPSocket, label: f.mfaLabel()}
+	case f.ClipboardOTP:
+		source = &clipboardOTPSource{command: clipboardCommand(runtime.GOOS), prompt: prompt}
+	case f.YubikeyAccount != "":
+		source = &yubikeyOTPSource{
+			cliPath: f.YkmanPath,
+			account: f.YubikeyAccount,
+			serial:  f.YubikeySerial,
+			timeout: f.OTPCommandTimeout,
+			prompt:  prompt,
+		}
+		interactive = false
+	case f.OTPCommand != "":
+		source = &commandOTPSource{command: f.OTPCommand, timeout: f.OTPCommandTimeout}
+		interactive = false
+	case f.opOTP():
+		source = f.opOTPSource(opSource)
+		interactive = false
+	case os.Getenv(mfaCodeEnv) != "":
+		source = &envOTPSource{name: mfaCodeEnv}
+		interactive = false
+	case preferAskpass:
+		source = prompt.dialog
+	}
+
+	if interactive {
+		source = f.interactiveOTPSource(source)
+	}
+	return source, interactive, nil
+}
+
+// opOTP reports whether the MFA code is read from 1Password.
+func (f *SessionFlags) opOTP() bool {
+	return f.OpOTP || f.OpOTPField != "" || f.OpOTPLocal || f.OpOTPItem != "" || f.OpOTPVault != ""
+}
+
+// opOTPSource reads the MFA code from the credentials item, or from
+// --op-otp-item when the TOTP lives in a separate item, e.g. a personal MFA
+// item next to a shared team item holding the access key.
+func (f *SessionFlags) opOTPSource(opSource opItem) *opItemOTPSource {
+	if f.OpOTPItem == "" && f.OpOTPVault == "" {
+		return &opItemOTPSource{source: opSource, label: f.OpOTPField, local: f.OpOTPLocal}
+	}
+	vault, item := opSource.awsItem().Vault, opSource.awsItem().Item
+	if f.OpOTPItem != "" {
+		item = f.OpOTPItem
+	}
+	if f.OpOTPVault != "" {
+		vault = f.OpOTPVault
+	}
+	return &opItemOTPSource{source: opSource.withItem(vault, item), label: f.OpOTPField, local: f.OpOTPLocal}
+}
+
+// opSource returns the item the credentials are read from, through op or
+// the 1Password SDK as --op-backend selects.
+func (f *SessionFlags) opSource() (opItem, error) {
+	source, err := f.backendSource()
+	if err != nil || len(f.BackendFallback) == 0 {
+		return source, err
+	}
+	chain := &chainCredentialSource{names: []string{f.Backend}, sources: []opItem{source}}
+	for _, name := range f.BackendFallback {
+		g := *f
+		g.Backend, g.BackendFallback = name, nil
+		source, err := g.backendSource()
+		if err != nil {
+			return nil, fmt.Errorf("--backend-fallback %s: %w", name, err)
+		}
+		chain.names = append(chain.names, name)
+		chain.sources = append(chain.sources, source)
+	}
+	return chain, nil
+}
+
+// backendSource returns the item the credentials are read from in the
+// password manager of --backend.
+func (f *SessionFlags) backendSource() (opItem, error) {
+	vault, name, err := f.opVaultItem()
+	if err != nil {
+		return nil, err
+	}
+	switch f.Backend {
+	case "bitwarden":
+		return &bwCredentialSource{cliPath: f.BwCLIPath, OpAwsItem: OpAwsItem{
+			Item:                 name,
+			AccessKeyIDField:     f.OpAccessKeyIDField,
+			SecretAccessKeyField: f.OpSecretAccessKeyField,
+		}}, nil
+	case "pass":
+		return &passCredentialSource{cliPath: f.PassCLIPath, OpAwsItem: OpAwsItem{
+			Item:                 name,
+			AccessKeyIDField:     f.OpAccessKeyIDField,
+			SecretAccessKeyField: f.OpSecretAccessKeyField,
+		}}, nil
+	case "vault":
+		if f.VaultKVVersion != 1 && f.VaultKVVersion != 2 {
+			return nil, errors.New("--vault-kv-version must be 1 or 2")
+		}
+		client := &http.Client{Timeout: 30 * time.Second}
+		return &vaultCredentialSource{
+			client:    client,
+			addr:      f.VaultAddr,
+			kvVersion: f.VaultKVVersion,
+			login:     newVaultLogin(client, f.VaultAddr, f.VaultRoleID, os.Getenv("VAULT_SECRET_ID")),
+			OpAwsItem: OpAwsItem{
+				Item:                 name,
+				AccessKeyIDField:     f.OpAccessKeyIDField,
+				SecretAccessKeyField: f.OpSecretAccessKeyField,
+			},
+		}, nil
+	case "keychain":
+		if runtime.GOOS != "darwin" {
+			return nil, errors.New("--backend keychain requires macOS")
+		}
+		return &keychainCredentialSource{cliPath: "/usr/bin/security", OpAwsItem: OpAwsItem{
+			Item:                 name,
+			AccessKeyIDField:     f.OpAccessKeyIDField,
+			SecretAccessKeyField: f.OpSecretAccessKeyField,
+		}}, nil
+	case "env":
+		return &envCredentialSource{OpAwsItem: OpAwsItem{
+			Item:                 name,
+			AccessKeyIDField:     f.OpAccessKeyIDField,
+			SecretAccessKeyField: f.OpSecretAccessKeyField,
+		}}, nil
+	case "secret-service":
+		return &secretServiceCredentialSource{cliPath: f.SecretToolPath, OpAwsItem: OpAwsItem{
+			Item:                 name,
+			AccessKeyIDField:     f.OpAccessKeyIDField,
+			SecretAccessKeyField: f.OpSecretAccessKeyField,
+		}}, nil
+	case "wincred":
+		return &wincredCredentialSource{lookup: readWincred, OpAwsItem: OpAwsItem{
+			Item:                 name,
+			AccessKeyIDField:     f.OpAccessKeyIDField,
+			SecretAccessKeyField: f.OpSecretAccessKeyField,
+		}}, nil
+	case "keepassxc":
+		return &keepassxcCredentialSource{cliPath: f.KeepassxcCLIPath, keyFile: f.KeepassxcKeyFile, OpAwsItem: OpAwsItem{
+			Vault:                vault,
+			Item:                 name,
+			AccessKeyIDField:     f.OpAccessKeyIDField,
+			SecretAccessKeyField: f.OpSecretAccessKeyField,
+		}}, nil
+	}
+	item := OpAwsItem{
+		Vault:                vault,
+		Item:                 name,
+		AccessKeyIDField:     f.OpAccessKeyIDField,
+		SecretAccessKeyField: f.OpSecretAccessKeyField,
+		AccessKeyIDRef:       f.OpAccessKeyRef,
+		SecretAccessKeyRef:   f.OpSecretKeyRef,
+		Account:              f.OpAccount,
+	}
+	token, err := f.opServiceAccountToken()
+	if err != nil {
+		return nil, err
+	}
+	var source opItem
+	if f.OpBackend == "sdk" {
+		debugLog.Print("reading 1Password with the SDK")
+		source = &opSDKCredentialSource{connect: newOpSDKConnector(token, f.OpAccount), OpAwsItem: item}
+	} else {
+		var env []string
+		if token != "" {
+			env = []string{"OP_SERVICE_ACCOUNT_TOKEN=" + token}
+		}
+		source = &opCLICredentialSource{cliPath: f.OpCLIPath, env: env, OpAwsItem: item}
+	}
+	if f.OpItemCache > 0 {
+		dir, err := cacheDir(f.CacheDir)
+		if err != nil {
+			return nil, err
+		}
+		source = &cachedOpItem{
+			opItem: source,
+			dir:    dir,
+			cipher: &keyringCipher{keyring: newKeyring(runtime.GOOS, f.SecretToolPath), account: opItemCacheKeyAccount},
+			ttl:    f.OpItemCache,
+		}
+	}
+	return source, nil
+}
+
+// opSessionStore returns the store of --op-session-item, which is read and
+// written with op whichever backend holds the key pair.
+func (f *SessionFlags) opSessionStore() (*opSessionStore, error) {
+	if f.OpBackend == "sdk" {
+		return nil, errors.New("--op-session-item requires --op-backend cli")
+	}
+	vault := cmp.Or(f.OpSessionVault, f.OpVault)
+	if vault == "" {
+		return nil, errors.New("--op-session-item requires --op-session-vault or --op-vault")
+	}
+	token, err := f.opServiceAccountToken()
+	if err != nil {
+		return nil, err
+	}
+	var env []string
+	if token != "" {
+		env = []string{"OP_SERVICE_ACCOUNT_TOKEN=" + token}
+	}
+	return &opSessionStore{op: &opCLICredentialSource{cliPath: f.OpCLIPath, env: env, OpAwsItem: OpAwsItem{
+		Vault:   vault,
+		Item:    f.OpSessionItem,
+		Account: f.OpAccount,
+	}}}, nil
+}
+
+// opVaultItem returns --op-vault and --op-item. When both are omitted, the
+// vault and item of the secret references are used, so the other flags that
+// read the item, such as --op-otp, work with references alone. With
+// another --backend, the item is the entry, path, service, or target flag of
+// that backend, and the vault is --keepassxc-db or empty.
+func (f *SessionFlags) opVaultItem() (vault, item string, err error) {
+	switch f.Backend {
+	case "bitwarden":
+		if f.BwItem == "" {
+			return "", "", errors.New("--bw-item is required with --backend bitwarden")
+		}
+		return "", f.BwItem, nil
+	case "pass":
+		if f.PassEntry == "" {
+			return "", "", errors.New("--pass-entry is required with --backend pass")
+		}
+		return "", f.PassEntry, nil
+	case "vault":
+		if f.VaultPath == "" {
+			return "", "", errors.New("--vault-path is required with --backend vault")
+		}
+		return "", f.VaultPath, nil
+	case "keepassxc":
+		if f.KeepassxcDB == "" || f.KeepassxcEntry == "" {
+			return "", "", errors.New("--keepassxc-db and --keepassxc-entry are required with --backend keepassxc")
+		}
+		return f.KeepassxcDB, f.KeepassxcEntry, nil
+	case "keychain":
+		if f.KeychainService == "" {
+			return "", "", errors.New("--keychain-service is required with --backend keychain")
+		}
+		return "", f.KeychainService, nil
+	case "wincred":
+		if f.WincredTarget == "" {
+			return "", "", errors.New("--wincred-target is required with --backend wincred")
+		}
+		return "", f.WincredTarget, nil
+	case "secret-service":
+		if f.SecretService == "" {
+			return "", "", errors.New("--secret-service is required with --backend secret-service")
+		}
+		return "", f.SecretService, nil
+	case "env":
+		// The item flags are ignored, so the same command line works
+		// with and without a password manager.
+		return "", envCredentialItem, nil
+	}
+	vault, item = f.OpVault, f.OpItem
+	for _, ref := range []string{f.OpAccessKeyRef, f.OpSecretKeyRef} {
+		if ref == "" {
+			continue
+		}
+		refVault, refItem, err := parseSecretRef(ref)
+		if err != nil {
+			return "", "", err
+		}
+		if f.OpVault == "" && f.OpItem == "" && vault == "" {
+			vault, item = refVault, refItem
+		}
+	}
+	if (vault == "" || item == "") && f.CredentialCommand == "" {
+		return "", "", errors.New("--op-vault and --op-item are required unless --op-access-key-ref or --op-secret-key-ref is set")
+	}
+	return vault, item, nil
+}
 
-	creds, err := source.RetrieveStsCredentials(ctx)
+// opServiceAccountToken returns the service account token from
+// --op-service-account-token-file or OP_SERVICE_ACCOUNT_TOKEN, or an empty
+// string when neither is set. With a token, 1Password is read without the
+// desktop app or an interactive prompt.
+func (f *SessionFlags) opServiceAccountToken() (string, error) {
+	if f.OpServiceTokenFile == "" {
+		token := os.Getenv("OP_SERVICE_ACCOUNT_TOKEN")
+		if token != "" {
+			debugLog.Print("using the 1Password service account from OP_SERVICE_ACCOUNT_TOKEN")
+		}
+		return token, nil
+	}
+	data, err := os.ReadFile(f.OpServiceTokenFile)
+	if err != nil {
+		return "", err
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", fmt.Errorf("service account token file %s is empty", f.OpServiceTokenFile)
+	}
+	debugLog.Printf("using the 1Password service account from %s", f.OpServiceTokenFile)
+	return token, nil
+}
+
+// mfaLabel describes the profile and item in MFA prompts.
+func (f *SessionFlags) mfaLabel() mfaLabel {
+	vault, item, _ := f.opVaultItem()
+	return mfaLabel{profile: f.Profile, vault: vault, item: item, template: f.PromptTemplate}
+}
+
+// nonInteractive reports whether prompting is disabled, explicitly or because
+// CI is set as it is by most CI services.
+func (f *SessionFlags) nonInteractive() bool {
+	if f.NonInteractive || renewing() {
+		return true
+	}
+	ci := strings.ToLower(os.Getenv("CI"))
+	return ci != "" && ci != "false" && ci != "0"
+}
+
+// interactiveOTPSource applies --notify and --prompt-timeout to a source that
+// waits for a person, or replaces it in non-interactive mode.
+func (f *SessionFlags) interactiveOTPSource(source OTPSource) OTPSource {
+	if f.nonInteractive() {
+		return nonInteractiveOTPSource{}
+	}
+	if f.Notify {
+		source = &notifyingOTPSource{
+			source:  source,
+			command: func(message string) []string { return notifyCommand(runtime.GOOS, message) },
+			profile: f.Profile,
+		}
+	}
+	if f.PromptTimeout > 0 {
+		source = &timeoutOTPSource{source: source, timeout: f.PromptTimeout}
+	}
+	return source
+}
+
+// otpSourceChain builds the --otp-source chain. Sources that are unavailable
+// on this machine, such as a dialog without a desktop session, are left out so
+// the same flags work on laptops and servers. It also reports whether the
+// source tried first waits for a person.
+func (f *SessionFlags) otpSourceChain(prompt *ttyOTPSource, opSource opItem) (OTPSource, bool, error) {
+	chain := &chainOTPSource{}
+	// firstInteractive is whether the source tried first waits for a person.
+	firstInteractive := false
+	for _, name := range f.OTPSources {
+		var source OTPSource
+		interactive := false
+		switch name {
+		case "op":
+			source = f.opOTPSource(opSource)
+		case "yubikey":
+			if f.YubikeyAccount == "" {
+				return nil, false, errors.New("--otp-source yubikey requires --yubikey-account")
+			}
+			source = &yubikeyOTPSource{
+				cliPath: f.YkmanPath,
+				account: f.YubikeyAccount,
+				serial:  f.YubikeySerial,
+				timeout: f.OTPCommandTimeout,
+				prompt:  prompt,
+			}
+		case "command":
+			if f.OTPCommand == "" {
+				return nil, false, errors.New("--otp-source command requires --otp-command")
+			}
+			source = &commandOTPSource{command: f.OTPCommand, timeout: f.OTPCommandTimeout}
+		case "env":
+			if os.Getenv(mfaCodeEnv) == "" {
+				continue
+			}
+			source = &envOTPSource{name: mfaCodeEnv}
+		case "askpass":
+			path := f.Askpass
+			if path == "" {
+				path, _ = envAskpass()
+			}
+			if path == "" {
+				continue
+			}
+			source, interactive = newAskpassOTPSource(path, f.mfaLabel()), true
+		case "pinentry":
+			if f.Pinentry == "" {
+				return nil, false, errors.New("--otp-source pinentry requires --pinentry")
+			}
+			source, interactive = &pinentryOTPSource{cliPath: f.Pinentry, label: f.mfaLabel()}, true
+		case "socket":
+			if f.OTPSocket == "" {
+				return nil, false, errors.New("--otp-source socket requires --otp-socket")
+			}
+			source, interactive = &socketOTPSource{path: f.OTPSocket, label: f.mfaLabel()}, true
+		case "clipboard":
+			command := clipboardCommand(runtime.GOOS)
+			if command == nil {
+				continue
+			}
+			source, interactive = &clipboardOTPSource{command: command, prompt: prompt}, true
+		case "dialog":
+			dialog := newDialogOTPSource(runtime.GOOS, f.mfaLabel())
+			if dialog == nil {
+				continue
+			}
+			source, interactive = dialog, true
+		case "tty":
+			source, interactive = &ttyOTPSource{label: f.mfaLabel()}, true
+			if f.TUI {
+				source = &tuiOTPSource{prompt: &ttyOTPSource{label: f.mfaLabel()}}
+			}
+		default:
+			return nil, false, fmt.Errorf("unknown OTP source %q", name)
+		}
+		if interactive {
+			source = f.interactiveOTPSource(source)
+		}
+		if len(chain.sources) == 0 {
+			firstInteractive = interactive
+		}
+		chain.sources = append(chain.sources, source)
+	}
+	if len(chain.sources) == 0 {
+		return nil, false, errors.New("none of the OTP sources in --otp-source are available")
+	}
+	return chain, firstInteractive, nil
+}
+
+// writeCredentials emits the credentials of provider in the credential_process
+// format. Long-term credentials are emitted without a session token or
+// expiration, which the SDKs treat as non-expiring.
+func writeCredentials(ctx context.Context, provider aws.CredentialsProvider) error {
+	creds, err := provider.Retrieve(ctx)
 	if err != nil {
 		return err
 	}
 
-	return json.NewEncoder(os.Stdout).Encode(processcreds.CredentialProcessResponse{
+	resp := processcreds.CredentialProcessResponse{
 		Version:         1,
-		AccessKeyID:     aws.ToString(creds.AccessKeyId),
-		SecretAccessKey: aws.ToString(creds.SecretAccessKey),
-		SessionToken:    aws.ToString(creds.SessionToken),
-		Expiration:      creds.Expiration,
-	})
+		AccessKeyID:     creds.AccessKeyID,
+		SecretAccessKey: creds.SecretAccessKey,
+		SessionToken:    creds.SessionToken,
+	}
+	if creds.CanExpire {
+		resp.Expiration = &creds.Expires
+	}
+	return json.NewEncoder(os.Stdout).Encode(resp)
+}
+
+const (
+	defaultSessionDuration = 12 * time.Hour
+	// defaultRoleDuration matches the AWS CLI default; most roles cap sessions at one hour.
+	defaultRoleDuration = 1 * time.Hour
+	// defaultRootDuration is the maximum AssumeRoot allows.
+	defaultRootDuration = 15 * time.Minute
+)
+
+// defaultRoleSessionName returns user@hostname so sessions can be traced back
+// to a person in CloudTrail. It returns an empty string when neither is known,
+// leaving AssumeRoleProvider to generate a name.
+func defaultRoleSessionName() string {
+	var name string
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+	if host, err := os.Hostname(); err == nil {
+		name += "@" + host
+	}
+	return sanitizeRoleSessionName(name)
+}
+
+// defaultFederationName returns the local user name, which fits the 32
+// character limit of GetFederationToken more often than user@hostname.
+func defaultFederationName() string {
+	name := "op-aws-credential-process"
+	if u, err := user.Current(); err == nil {
+		if n := sanitizeRoleSessionName(u.Username); n != "" {
+			name = n
+		}
+	}
+	if len(name) > 32 {
+		name = name[:32]
+	}
+	return name
 }
 
-const expiryWindow = 5 * time.Minute
+// cacheDir returns the directory cached sessions are kept in: override when
+// set, or op-aws-credential-process under the XDG cache directory.
+func cacheDir(override string) (string, error) {
+	return xdgDir(override, "XDG_CACHE_HOME", ".cache")
+}
+
+// stateDir returns the directory state that must survive the cache being
+// wiped is kept in, such as the offline mirror and the chosen MFA device:
+// override when set, or op-aws-credential-process under the XDG state
+// directory.
+func stateDir(override string) (string, error) {
+	return xdgDir(override, "XDG_STATE_HOME", filepath.Join(".local", "state"))
+}
 
-func cacheDir() (string, error) {
-	dir := os.Getenv("XDG_CACHE_HOME")
+// xdgDir returns override when set, or op-aws-credential-process under the
+// directory named by env, which defaults to home under the home directory.
+func xdgDir(override, env, home string) (string, error) {
+	if override != "" {
+		return override, nil
+	}
+	dir := os.Getenv(env)
 	if dir == "" {
-		home, err := os.UserHomeDir()
+		userHome, err := os.UserHomeDir()
 		if err != nil {
 			return "", err
 		}
-		dir = filepath.Join(home, ".cache")
+		dir = filepath.Join(userHome, home)
+	}
+	return filepath.Join(dir, "op-aws-credential-process"), nil
+}
+
+// migrateState moves state from old, where earlier versions kept it in the
+// cache directory, to path unless it is already there, and returns path.
+func migrateState(old, path string) string {
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		return path
+	}
+	if _, err := os.Stat(old); err != nil {
+		return path
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		debugLog.Printf("failed to move %s to %s: %v", old, path, err)
+		return path
+	}
+	if err := os.Rename(old, path); err != nil {
+		debugLog.Printf("failed to move %s to %s: %v", old, path, err)
 	}
-	return dir, nil
+	return path
 }
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSessionFlags_OpOTPSource(t *testing.T) {
+	creds := &opCLICredentialSource{OpAwsItem: OpAwsItem{Vault: "Team", Item: "aws"}}
+
+	tests := []struct {
+		name      string
+		flags     SessionFlags
+		wantVault string
+		wantItem  string
+	}{
+		{name: "credentials item", flags: SessionFlags{OpOTP: true}, wantVault: "Team", wantItem: "aws"},
+		{name: "separate item", flags: SessionFlags{OpOTPItem: "aws-mfa"}, wantVault: "Team", wantItem: "aws-mfa"},
+		{name: "separate vault", flags: SessionFlags{OpOTPItem: "aws-mfa", OpOTPVault: "Private"}, wantVault: "Private", wantItem: "aws-mfa"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !tt.flags.opOTP() {
+				t.Fatal("opOTP() = false, want true")
+			}
+			got := tt.flags.opOTPSource(creds).source.awsItem()
+			if got.Vault != tt.wantVault || got.Item != tt.wantItem {
+				t.Errorf("item = %s/%s, want %s/%s", got.Vault, got.Item, tt.wantVault, tt.wantItem)
+			}
+		})
+	}
+	if creds.Vault != "Team" || creds.Item != "aws" {
+		t.Errorf("credentials item changed to %s/%s", creds.Vault, creds.Item)
+	}
+}
+
+func TestSessionFlags_OpVaultItem(t *testing.T) {
+	tests := []struct {
+		name      string
+		flags     SessionFlags
+		wantVault string
+		wantItem  string
+		wantErr   bool
+	}{
+		{name: "flags", flags: SessionFlags{OpVault: "Team", OpItem: "aws"}, wantVault: "Team", wantItem: "aws"},
+		{name: "reference", flags: SessionFlags{OpAccessKeyRef: "op://Private/AWS/username", OpSecretKeyRef: "op://Shared/AWS/password"}, wantVault: "Private", wantItem: "AWS"},
+		{name: "secret key reference", flags: SessionFlags{OpSecretKeyRef: "op://Shared/AWS/section/password"}, wantVault: "Shared", wantItem: "AWS"},
+		{name: "flags over reference", flags: SessionFlags{OpVault: "Team", OpItem: "aws", OpAccessKeyRef: "op://Private/AWS/username"}, wantVault: "Team", wantItem: "aws"},
+		{name: "invalid reference", flags: SessionFlags{OpAccessKeyRef: "Private/AWS/username"}, wantErr: true},
+		{name: "missing item", flags: SessionFlags{OpVault: "Team"}, wantErr: true},
+		{name: "environment", flags: SessionFlags{Backend: "env", OpVault: "Team", OpItem: "aws"}, wantItem: envCredentialItem},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vault, item, err := tt.flags.opVaultItem()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("opVaultItem() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if vault != tt.wantVault || item != tt.wantItem {
+				t.Errorf("opVaultItem() = %s/%s, want %s/%s", vault, item, tt.wantVault, tt.wantItem)
+			}
+		})
+	}
+}
+
+func TestCacheDir(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", "/xdg")
+	got, err := cacheDir("")
+	if err != nil {
+		t.Fatalf("cacheDir() error = %v", err)
+	}
+	if want := filepath.Join("/xdg", "op-aws-credential-process"); got != want {
+		t.Errorf("cacheDir() = %q, want %q", got, want)
+	}
+	got, err = cacheDir("/mnt/cache")
+	if err != nil {
+		t.Fatalf("cacheDir() error = %v", err)
+	}
+	if got != "/mnt/cache" {
+		t.Errorf("cacheDir() = %q, want %q", got, "/mnt/cache")
+	}
+}
+
+func TestStateDir(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", "/xdg-state")
+	got, err := stateDir("")
+	if err != nil {
+		t.Fatalf("stateDir() error = %v", err)
+	}
+	if want := filepath.Join("/xdg-state", "op-aws-credential-process"); got != want {
+		t.Errorf("stateDir() = %q, want %q", got, want)
+	}
+
+	t.Setenv("XDG_STATE_HOME", "")
+	t.Setenv("HOME", "/home/alice")
+	got, err = stateDir("")
+	if err != nil {
+		t.Fatalf("stateDir() error = %v", err)
+	}
+	if want := filepath.Join("/home/alice", ".local", "state", "op-aws-credential-process"); got != want {
+		t.Errorf("stateDir() = %q, want %q", got, want)
+	}
+}
+
+func TestMigrateState(t *testing.T) {
+	dir := t.TempDir()
+	old := filepath.Join(dir, "cache", "dev.mfa-serial")
+	path := filepath.Join(dir, "state", "dev.mfa-serial")
+	if err := os.MkdirAll(filepath.Dir(old), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(old, []byte("arn:aws:iam::123456789012:mfa/alice"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := migrateState(old, path); got != path {
+		t.Errorf("migrateState() = %q, want %q", got, path)
+	}
+	if data, err := os.ReadFile(path); err != nil || string(data) != "arn:aws:iam::123456789012:mfa/alice" {
+		t.Errorf("state = %q, %v, want it moved", data, err)
+	}
+	if _, err := os.Stat(old); !os.IsNotExist(err) {
+		t.Errorf("old state exists (err = %v), want it moved", err)
+	}
+
+	// State already in place is not overwritten.
+	if err := os.WriteFile(old, []byte("stale"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	migrateState(old, path)
+	if data, _ := os.ReadFile(path); string(data) != "arn:aws:iam::123456789012:mfa/alice" {
+		t.Errorf("state = %q, want it kept", data)
+	}
+}
+
+func TestSessionFlags_AWSCLIShareable(t *testing.T) {
+	tests := map[string]struct {
+		flags SessionFlags
+		want  bool
+	}{
+		"profile only":       {SessionFlags{Profile: "prod"}, true},
+		"session policy":     {SessionFlags{PolicyFile: "policy.json"}, false},
+		"managed policy":     {SessionFlags{PolicyArn: []string{"arn:aws:iam::aws:policy/ReadOnlyAccess"}}, false},
+		"session tag":        {SessionFlags{Tag: map[string]string{"team": "infra"}}, false},
+		"transitive tag key": {SessionFlags{TransitiveTagKey: []string{"team"}}, false},
+		"tag field":          {SessionFlags{OpTagField: []string{"team"}}, false},
+		"role ARN field":     {SessionFlags{OpRoleArnField: "Role ARN"}, false},
+		"external ID field":  {SessionFlags{OpExternalIDField: "External ID"}, false},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := tt.flags.awsCLIShareable(); got != tt.want {
+				t.Errorf("awsCLIShareable() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+)
+
+type MfaSerialSource interface {
+	MfaSerial(ctx context.Context) (string, error)
+}
+
+type ListMFADevicesAPIClient interface {
+	ListMFADevices(ctx context.Context, params *iam.ListMFADevicesInput, optFns ...func(*iam.Options)) (*iam.ListMFADevicesOutput, error)
+}
+
+// MfaDeviceChooser picks one of several MFA devices.
+type MfaDeviceChooser interface {
+	ChooseMfaDevice(ctx context.Context, serials []string) (string, error)
+}
+
+// iamMfaSerialSource discovers the MFA device of the IAM user that owns the
+// long-term credentials. A user without MFA devices yields an empty serial,
+// so sessions are minted without MFA.
+type iamMfaSerialSource struct {
+	client ListMFADevicesAPIClient
+	// chooser picks a device when the user has several. The choice is
+	// remembered in choicePath so it is asked only once per profile.
+	chooser    MfaDeviceChooser
+	choicePath string
+	// timeout bounds the IAM call like --sts-timeout does STS calls. Zero
+	// disables it.
+	timeout time.Duration
+}
+
+func (s *iamMfaSerialSource) MfaSerial(ctx context.Context) (string, error) {
+	listCtx := ctx
+	if s.timeout > 0 {
+		var cancel context.CancelFunc
+		listCtx, cancel = context.WithTimeout(ctx, s.timeout)
+		defer cancel()
+	}
+	out, err := s.client.ListMFADevices(listCtx, &iam.ListMFADevicesInput{})
+	if err != nil {
+		return "", fmt.Errorf("failed to discover MFA device: %w", err)
+	}
+
+	serials := make([]string, 0, len(out.MFADevices))
+	for _, device := range out.MFADevices {
+		serials = append(serials, aws.ToString(device.SerialNumber))
+	}
+	switch len(serials) {
+	case 0:
+		return "", nil
+	case 1:
+		return serials[0], nil
+	}
+
+	// A remembered choice is used only while the device still exists.
+	if s.choicePath != "" {
+		if data, err := os.ReadFile(s.choicePath); err == nil {
+			if choice := strings.TrimSpace(string(data)); slices.Contains(serials, choice) {
+				return choice, nil
+			}
+		}
+	}
+	if s.chooser == nil {
+		return "", fmt.Errorf("multiple MFA devices found; set mfa_serial or --mfa-serial to one of: %s", strings.Join(serials, ", "))
+	}
+	choice, err := s.chooser.ChooseMfaDevice(ctx, serials)
+	if err != nil {
+		return "", err
+	}
+	if s.choicePath != "" {
+		if err := os.MkdirAll(filepath.Dir(s.choicePath), 0700); err == nil {
+			_ = writeFileAtomic(s.choicePath, []byte(choice+"\n"), 0600)
+		}
+	}
+	return choice, nil
+}
+
+// ChooseMfaDevice lists the devices on the terminal and reads the number of
+// the one to use.
+func (s *ttyOTPSource) ChooseMfaDevice(ctx context.Context, serials []string) (string, error) {
+	r, w, closeFn, ok := s.open()
+	defer closeFn()
+
+	var prompt strings.Builder
+	prompt.WriteString("Multiple MFA devices found:\n")
+	for i, serial := range serials {
+		fmt.Fprintf(&prompt, "  %d) %s\n", i+1, serial)
+	}
+	fmt.Fprintf(&prompt, "Choose the device for profile %s [1-%d]: ", s.label.profile, len(serials))
+
+	var n int
+	if err := s.ask(r, w, ok, prompt.String(), func(r io.Reader, w io.Writer) error {
+		_, err := fmt.Fscanln(r, &n)
+		return err
+	}); err != nil {
+		return "", fmt.Errorf("failed to read MFA device choice: %w", err)
+	}
+	if n < 1 || n > len(serials) {
+		return "", fmt.Errorf("invalid MFA device choice %d", n)
+	}
+	return serials[n-1], nil
+}
+
+// resolveMfaSerial returns serial, or discovers it from source when serial is
+// empty and a source is configured.
+func resolveMfaSerial(ctx context.Context, serial string, source MfaSerialSource) (string, error) {
+	if serial != "" || source == nil {
+		return serial, nil
+	}
+	return source.MfaSerial(ctx)
+}
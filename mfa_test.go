@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	iamtypes "github.com/aws/aws-sdk-go-v2/service/iam/types"
+)
+
+type fakeListMFADevicesClient struct {
+	serials []string
+	err     error
+	// hang blocks the call until the context is done.
+	hang bool
+}
+
+func (f *fakeListMFADevicesClient) ListMFADevices(ctx context.Context, params *iam.ListMFADevicesInput, optFns ...func(*iam.Options)) (*iam.ListMFADevicesOutput, error) {
+	if f.hang {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	if f.err != nil {
+		return nil, f.err
+	}
+	out := &iam.ListMFADevicesOutput{}
+	for _, serial := range f.serials {
+		out.MFADevices = append(out.MFADevices, iamtypes.MFADevice{SerialNumber: aws.String(serial)})
+	}
+	return out, nil
+}
+
+type fakeMfaSerialSource struct {
+	serial string
+	err    error
+	called int
+}
+
+func (f *fakeMfaSerialSource) MfaSerial(ctx context.Context) (string, error) {
+	f.called++
+	return f.serial, f.err
+}
+
+func TestIamMfaSerialSource(t *testing.T) {
+	tests := []struct {
+		name    string
+		client  *fakeListMFADevicesClient
+		want    string
+		wantErr bool
+	}{
+		{name: "single device", client: &fakeListMFADevicesClient{serials: []string{"arn:aws:iam::123456789012:mfa/user"}}, want: "arn:aws:iam::123456789012:mfa/user"},
+		{name: "no device", client: &fakeListMFADevicesClient{}, want: ""},
+		{name: "multiple devices", client: &fakeListMFADevicesClient{serials: []string{"a", "b"}}, wantErr: true},
+		{name: "api error", client: &fakeListMFADevicesClient{err: errors.New("access denied")}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := &iamMfaSerialSource{client: tt.client}
+			got, err := source.MfaSerial(context.Background())
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("MfaSerial = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+type fakeMfaDeviceChooser struct {
+	choice string
+	called int
+}
+
+func (f *fakeMfaDeviceChooser) ChooseMfaDevice(ctx context.Context, serials []string) (string, error) {
+	f.called++
+	return f.choice, nil
+}
+
+func TestIamMfaSerialSource_ChoosesAndRemembers(t *testing.T) {
+	client := &fakeListMFADevicesClient{serials: []string{"arn:aws:iam::123456789012:mfa/phone", "arn:aws:iam::123456789012:mfa/yubikey"}}
+	chooser := &fakeMfaDeviceChooser{choice: "arn:aws:iam::123456789012:mfa/yubikey"}
+	source := &iamMfaSerialSource{
+		client:     client,
+		chooser:    chooser,
+		choicePath: filepath.Join(t.TempDir(), "op-aws-credential-process", "prod.mfa-serial"),
+	}
+
+	for range 2 {
+		got, err := source.MfaSerial(context.Background())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got != chooser.choice {
+			t.Errorf("MfaSerial = %q, want %q", got, chooser.choice)
+		}
+	}
+	if chooser.called != 1 {
+		t.Errorf("chooser.called = %d, want 1", chooser.called)
+	}
+}
+
+func TestIamMfaSerialSource_StaleChoice(t *testing.T) {
+	choicePath := filepath.Join(t.TempDir(), "prod.mfa-serial")
+	if err := os.WriteFile(choicePath, []byte("arn:aws:iam::123456789012:mfa/removed\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	chooser := &fakeMfaDeviceChooser{choice: "arn:aws:iam::123456789012:mfa/phone"}
+	source := &iamMfaSerialSource{
+		client:     &fakeListMFADevicesClient{serials: []string{"arn:aws:iam::123456789012:mfa/phone", "arn:aws:iam::123456789012:mfa/yubikey"}},
+		chooser:    chooser,
+		choicePath: choicePath,
+	}
+
+	got, err := source.MfaSerial(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got != chooser.choice || chooser.called != 1 {
+		t.Errorf("MfaSerial = %q (chooser called %d times), want %q chosen again", got, chooser.called, chooser.choice)
+	}
+}
+
+func TestTTYOTPSource_ChooseMfaDevice(t *testing.T) {
+	if f, err := os.OpenFile("/dev/tty", os.O_RDWR, 0); err == nil {
+		_ = f.Close()
+		t.Skip("a controlling terminal is available")
+	}
+
+	var stderr bytes.Buffer
+	source := &ttyOTPSource{label: mfaLabel{profile: "prod"}, stdin: strings.NewReader("2\n"), stderr: &stderr}
+
+	got, err := source.ChooseMfaDevice(context.Background(), []string{"phone", "yubikey"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got != "yubikey" {
+		t.Errorf("ChooseMfaDevice = %q, want %q", got, "yubikey")
+	}
+	if !strings.Contains(stderr.String(), "2) yubikey") {
+		t.Errorf("stderr = %q, want the device list", stderr.String())
+	}
+}
+
+func TestIamMfaSerialSource_Timeout(t *testing.T) {
+	source := &iamMfaSerialSource{client: &fakeListMFADevicesClient{hang: true}, timeout: 10 * time.Millisecond}
+	if _, err := source.MfaSerial(context.Background()); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("MfaSerial() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// mirrorKeyAccount is the keyring account of the offline mirror key.
+const mirrorKeyAccount = "offline-mirror"
+
+// mirrorEntry is the offline mirror as written to disk. SavedAt and the
+// file name are authenticated along with the ciphertext, so the freshness
+// limit cannot be lifted by editing the file, and the mirror of one key pair
+// cannot stand in for another's.
+type mirrorEntry struct {
+	SavedAt time.Time `json:"saved_at"`
+	sealed
+}
+
+type mirrorPlaintext struct {
+	AccessKeyID     string `json:"access_key_id"`
+	SecretAccessKey string `json:"secret_access_key"`
+}
+
+// mirrorCredentialSource keeps an encrypted copy of the key pair each time
+// source returns it, and hands the copy out for up to maxAge when source
+// fails, e.g. while 1Password is unreachable. It does not stand in when op
+// is locked, so dismissing the unlock prompt never bypasses it.
+type mirrorCredentialSource struct {
+	source aws.CredentialsProvider
+	path   string
+	cipher *keyringCipher
+	maxAge time.Duration
+	now    func() time.Time
+}
+
+// mirrorPath returns where the mirror of the key pair identified by id is
+// kept.
+func mirrorPath(dir, id string) string {
+	sum := sha1.Sum([]byte(id))
+	return filepath.Join(dir, "mirror", hex.EncodeToString(sum[:])+".json")
+}
+
+func (s *mirrorCredentialSource) timeNow() time.Time {
+	if s.now == nil {
+		return time.Now()
+	}
+	return s.now()
+}
+
+func (s *mirrorCredentialSource) Retrieve(ctx context.Context) (aws.Credentials, error) {
+	creds, err := s.source.Retrieve(ctx)
+	if err == nil {
+		if err := s.save(ctx, creds); err != nil {
+			warnLog.Printf("failed to update the offline mirror: %v", err)
+		}
+		return creds, nil
+	}
+	if ctx.Err() != nil || errors.Is(err, errOpLocked) {
+		return aws.Credentials{}, err
+	}
+
+	mirrored, savedAt, loadErr := s.load(ctx)
+	if loadErr != nil {
+		debugLog.Printf("offline mirror is unavailable: %v", loadErr)
+		return aws.Credentials{}, err
+	}
+	if age := s.timeNow().Sub(savedAt); age > s.maxAge {
+		return aws.Credentials{}, fmt.Errorf("%w\nthe offline mirror was saved %s ago, longer than --offline-mirror %s", err, age.Round(time.Minute), s.maxAge)
+	}
+	warnLog.Printf("using the offline mirror saved at %s: %v", savedAt.Local().Format(time.RFC3339), err)
+	return mirrored, nil
+}
+
+func (s *mirrorCredentialSource) save(ctx context.Context, creds aws.Credentials) error {
+	plaintext, err := json.Marshal(mirrorPlaintext{AccessKeyID: creds.AccessKeyID, SecretAccessKey: creds.SecretAccessKey})
+	if err != nil {
+		return err
+	}
+	entry := mirrorEntry{SavedAt: s.timeNow().UTC().Truncate(time.Second)}
+	sealed, err := s.cipher.seal(ctx, plaintext, s.additionalData(entry.SavedAt))
+	if err != nil {
+		return err
+	}
+	entry.sealed = *sealed
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return err
+	}
+	return writeFileAtomic(s.path, data, 0600)
+}
+
+func (s *mirrorCredentialSource) load(ctx context.Context) (aws.Credentials, time.Time, error) {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return aws.Credentials{}, time.Time{}, err
+	}
+	var entry mirrorEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return aws.Credentials{}, time.Time{}, err
+	}
+	plaintext, err := s.cipher.open(ctx, &entry.sealed, s.additionalData(entry.SavedAt))
+	if err != nil {
+		return aws.Credentials{}, time.Time{}, fmt.Errorf("offline mirror: %w", err)
+	}
+	var p mirrorPlaintext
+	if err := json.Unmarshal(plaintext, &p); err != nil {
+		return aws.Credentials{}, time.Time{}, err
+	}
+	return aws.Credentials{AccessKeyID: p.AccessKeyID, SecretAccessKey: p.SecretAccessKey}, entry.SavedAt, nil
+}
+
+// additionalData binds the mirror to its file and to when it was saved.
+func (s *mirrorCredentialSource) additionalData(savedAt time.Time) []byte {
+	return []byte(filepath.Base(s.path) + "\x00" + savedAt.UTC().Format(time.RFC3339))
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+func TestMirrorCredentialSource(t *testing.T) {
+	now := time.Date(2026, 10, 15, 9, 0, 0, 0, time.UTC)
+	source := &fakeCredsProvider{creds: aws.Credentials{AccessKeyID: "AKIA", SecretAccessKey: "secret"}}
+	path := mirrorPath(t.TempDir(), "1password\x00\x00Private\x00AWS")
+	mirror := &mirrorCredentialSource{
+		source: source,
+		path:   path,
+		cipher: &keyringCipher{keyring: newFakeKeyring(), account: mirrorKeyAccount},
+		maxAge: 24 * time.Hour,
+		now:    func() time.Time { return now },
+	}
+	ctx := context.Background()
+
+	if _, err := mirror.Retrieve(ctx); err != nil {
+		t.Fatalf("Retrieve() error = %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("mirror was not saved: %v", err)
+	}
+	if strings.Contains(string(data), "secret") || strings.Contains(string(data), "AKIA") {
+		t.Errorf("mirror holds the key pair in plaintext: %s", data)
+	}
+
+	source.creds, source.err = aws.Credentials{}, errors.New("op is unreachable")
+	now = now.Add(time.Hour)
+	creds, err := mirror.Retrieve(ctx)
+	if err != nil {
+		t.Fatalf("Retrieve() error = %v, want the mirrored key pair", err)
+	}
+	if creds.AccessKeyID != "AKIA" || creds.SecretAccessKey != "secret" {
+		t.Errorf("Retrieve() = %q, %q, want %q, %q", creds.AccessKeyID, creds.SecretAccessKey, "AKIA", "secret")
+	}
+
+	source.err = errOpLocked
+	if _, err := mirror.Retrieve(ctx); !errors.Is(err, errOpLocked) {
+		t.Errorf("Retrieve() error = %v, want errOpLocked rather than the mirror", err)
+	}
+
+	source.err = errors.New("op is unreachable")
+	now = now.Add(24 * time.Hour)
+	if _, err := mirror.Retrieve(ctx); err == nil {
+		t.Error("Retrieve() error = nil, want error for a mirror older than maxAge")
+	}
+}
+
+func TestMirrorCredentialSource_Tampered(t *testing.T) {
+	now := time.Date(2026, 10, 15, 9, 0, 0, 0, time.UTC)
+	source := &fakeCredsProvider{creds: aws.Credentials{AccessKeyID: "AKIA", SecretAccessKey: "secret"}}
+	path := filepath.Join(t.TempDir(), "mirror.json")
+	mirror := &mirrorCredentialSource{
+		source: source,
+		path:   path,
+		cipher: &keyringCipher{keyring: newFakeKeyring(), account: mirrorKeyAccount},
+		maxAge: 24 * time.Hour,
+		now:    func() time.Time { return now },
+	}
+	ctx := context.Background()
+	if _, err := mirror.Retrieve(ctx); err != nil {
+		t.Fatalf("Retrieve() error = %v", err)
+	}
+
+	// Moving saved_at forward must not extend the mirror's life.
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data = []byte(strings.Replace(string(data), "2026-10-15T09:00:00Z", "2026-10-20T09:00:00Z", 1))
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	source.err = errors.New("op is unreachable")
+	now = now.Add(72 * time.Hour)
+	if _, err := mirror.Retrieve(ctx); err == nil {
+		t.Error("Retrieve() error = nil, want error for a tampered mirror")
+	}
+}
+
+func TestMirrorCredentialSource_Moved(t *testing.T) {
+	now := time.Date(2026, 10, 15, 9, 0, 0, 0, time.UTC)
+	dir := t.TempDir()
+	cipher := &keyringCipher{keyring: newFakeKeyring(), account: mirrorKeyAccount}
+	newMirror := func(source aws.CredentialsProvider, id string) *mirrorCredentialSource {
+		return &mirrorCredentialSource{
+			source: source,
+			path:   mirrorPath(dir, id),
+			cipher: cipher,
+			maxAge: 24 * time.Hour,
+			now:    func() time.Time { return now },
+		}
+	}
+	ctx := context.Background()
+	prod := newMirror(&fakeCredsProvider{creds: aws.Credentials{AccessKeyID: "AKIAPROD", SecretAccessKey: "prod"}}, "prod")
+	if _, err := prod.Retrieve(ctx); err != nil {
+		t.Fatalf("Retrieve() error = %v", err)
+	}
+
+	// The mirror of one key pair copied over another's must not be served
+	// for it.
+	dev := newMirror(&fakeCredsProvider{err: errors.New("op is unreachable")}, "dev")
+	data, err := os.ReadFile(prod.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(dev.path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if creds, err := dev.Retrieve(ctx); err == nil {
+		t.Errorf("Retrieve() = %q, want error for a mirror moved from another key pair", creds.AccessKeyID)
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+)
+
+// notifyCommand returns the command that shows message as a desktop
+// notification on goos, or nil when no notifier is available.
+func notifyCommand(goos, message string) []string {
+	switch goos {
+	case "darwin":
+		return []string{"osascript", "-e", fmt.Sprintf("display notification %q with title %q", message, dialogTitle)}
+	case "windows":
+		return nil
+	}
+	if _, err := exec.LookPath("notify-send"); err != nil {
+		return nil
+	}
+	return []string{"notify-send", "--app-name", dialogTitle, dialogTitle, message}
+}
+
+// notifyingOTPSource fires a desktop notification before asking source, so a
+// prompt started in the background by an SDK does not look like a hang.
+type notifyingOTPSource struct {
+	source OTPSource
+	// command returns the notification command for message.
+	command func(message string) []string
+	profile string
+}
+
+func (s *notifyingOTPSource) OTP(ctx context.Context, serial string) (string, error) {
+	if c := s.command(fmt.Sprintf("AWS profile %s needs an MFA code", s.profile)); len(c) > 0 {
+		// The notification is best effort and must not delay the prompt.
+		cmd := exec.Command(c[0], c[1:]...)
+		if err := cmd.Start(); err == nil {
+			go func() { _ = cmd.Wait() }()
+		}
+	}
+	return s.source.OTP(ctx, serial)
+}
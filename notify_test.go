@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNotifyingOTPSource(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "notification")
+	source := &notifyingOTPSource{
+		source: &fakeOTPSource{otp: "123456"},
+		command: func(message string) []string {
+			return []string{"sh", "-c", `printf %s "$1" > "$2"`, "sh", message, out}
+		},
+		profile: "prod",
+	}
+
+	got, err := source.OTP(context.Background(), "arn:aws:iam::123456789012:mfa/alice")
+	if err != nil {
+		t.Fatalf("OTP() error = %v", err)
+	}
+	if got != "123456" {
+		t.Errorf("OTP() = %q, want %q", got, "123456")
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		message, err := os.ReadFile(out)
+		if err == nil && len(message) > 0 {
+			if want := "AWS profile prod needs an MFA code"; string(message) != want {
+				t.Errorf("notification = %q, want %q", message, want)
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("notification was not sent")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestNotifyingOTPSource_NoNotifier(t *testing.T) {
+	source := &notifyingOTPSource{
+		source:  &fakeOTPSource{otp: "123456"},
+		command: func(string) []string { return nil },
+	}
+
+	if _, err := source.OTP(context.Background(), "arn:aws:iam::123456789012:mfa/alice"); err != nil {
+		t.Fatalf("OTP() error = %v", err)
+	}
+}
+
+func TestNotifyCommand_Darwin(t *testing.T) {
+	got := notifyCommand("darwin", "AWS profile prod needs an MFA code")
+	if len(got) != 3 || got[0] != "osascript" || !strings.Contains(got[2], `"AWS profile prod needs an MFA code"`) {
+		t.Errorf("notifyCommand() = %q", got)
+	}
+}
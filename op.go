@@ -1,56 +1,708 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io/fs"
+	"os"
 	"os/exec"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 )
 
+// opItem reads the fields of the 1Password item, through the op CLI or the
+// 1Password SDK. As an aws.CredentialsProvider it returns the long-term
+// credentials stored in the item.
+type opItem interface {
+	aws.CredentialsProvider
+	awsItem() OpAwsItem
+	// withItem returns a reader for another item, e.g. one holding the TOTP.
+	withItem(vault, item string) opItem
+	// fields returns the values of the given field labels keyed by label.
+	// Labels missing from the item are absent from the result, or make the
+	// call fail when the backend cannot tell them apart from other errors.
+	fields(ctx context.Context, labels ...string) (map[string]string, error)
+	// totp returns the current code of the one-time password field label,
+	// or of the item's primary one-time password when label is empty.
+	totp(ctx context.Context, label string) (string, error)
+	// otpSeed returns the otpauth:// URI of the same field.
+	otpSeed(ctx context.Context, label string) (string, error)
+	// details reads the whole item, or returns nil when the backend can
+	// only read it field by field.
+	details(ctx context.Context) (*opItemDetails, error)
+	// read returns the value of an op:// secret reference, which may point
+	// at any item the account can access.
+	read(ctx context.Context, ref string) (string, error)
+}
+
+// readCredentials reads the long-term credentials from the item's fields, or
+// from the secret references given in their place.
+func readCredentials(ctx context.Context, s opItem) (aws.Credentials, error) {
+	item := s.awsItem()
+	var labels []string
+	if item.AccessKeyIDRef == "" {
+		labels = append(labels, item.AccessKeyIDField)
+	}
+	if item.SecretAccessKeyRef == "" {
+		labels = append(labels, item.SecretAccessKeyField)
+	}
+	var values map[string]string
+	if len(labels) > 0 {
+		details, err := s.details(ctx)
+		if err != nil {
+			return aws.Credentials{}, err
+		}
+		if details != nil {
+			values = details.fields
+			if details.apiCredential {
+				useAPICredentialFields(&item, values)
+				warnKeyExpiry(values[apiCredentialExpiresLabel], time.Now())
+			}
+			detectCredentialFields(&item, values)
+		} else if values, err = s.fields(ctx, labels...); err != nil {
+			return aws.Credentials{}, err
+		}
+	}
+
+	creds := aws.Credentials{
+		AccessKeyID:     values[item.AccessKeyIDField],
+		SecretAccessKey: values[item.SecretAccessKeyField],
+	}
+	for _, ref := range []struct {
+		ref   string
+		value *string
+	}{
+		{item.AccessKeyIDRef, &creds.AccessKeyID},
+		{item.SecretAccessKeyRef, &creds.SecretAccessKey},
+	} {
+		if ref.ref == "" {
+			continue
+		}
+		value, err := s.read(ctx, ref.ref)
+		if err != nil {
+			return aws.Credentials{}, err
+		}
+		*ref.value = value
+	}
+	if creds.AccessKeyID == "" || creds.SecretAccessKey == "" {
+		return aws.Credentials{}, errors.New("missing credentials in 1Password item")
+	}
+	return creds, nil
+}
+
+// opItemDetails is a whole item, read in one call.
+type opItemDetails struct {
+	// apiCredential is set for items of the API Credential category.
+	apiCredential bool
+	// fields holds the field values by label. The first field wins when a
+	// label repeats across sections. One-time password fields are left
+	// out, so their seeds are only read when asked for.
+	fields map[string]string
+}
+
+// The labels of the fields 1Password gives API Credential items.
+const (
+	apiCredentialUsernameLabel   = "username"
+	apiCredentialCredentialLabel = "credential"
+	apiCredentialExpiresLabel    = "expires"
+)
+
+// keyExpiryWarning is how long before the expiry date of an API Credential
+// item a warning is printed.
+const keyExpiryWarning = 7 * 24 * time.Hour
+
+// useAPICredentialFields points the item at the username and credential
+// fields of an API Credential item, unless it already names fields the item
+// has, so no field flags are needed for them.
+func useAPICredentialFields(item *OpAwsItem, values map[string]string) {
+	if _, ok := values[item.AccessKeyIDField]; !ok {
+		item.AccessKeyIDField = apiCredentialUsernameLabel
+	}
+	if _, ok := values[item.SecretAccessKeyField]; !ok {
+		item.SecretAccessKeyField = apiCredentialCredentialLabel
+	}
+}
+
+var (
+	accessKeyIDPattern     = regexp.MustCompile(`^(AKIA|ASIA)[A-Z0-9]{16}$`)
+	secretAccessKeyPattern = regexp.MustCompile(`^[A-Za-z0-9/+]{40}$`)
+)
+
+// detectCredentialFields points the item at fields whose values look like an
+// access key ID and a secret access key when the configured labels are not
+// found, e.g. in items created by other tools. A field is only picked when
+// exactly one matches, and a warning names it so the flag can be set.
+func detectCredentialFields(item *OpAwsItem, values map[string]string) {
+	for _, f := range []struct {
+		label   *string
+		pattern *regexp.Regexp
+		what    string
+		flag    string
+	}{
+		{&item.AccessKeyIDField, accessKeyIDPattern, "an access key ID", "--op-access-key-id-field"},
+		{&item.SecretAccessKeyField, secretAccessKeyPattern, "a secret access key", "--op-secret-access-key-field"},
+	} {
+		if _, ok := values[*f.label]; ok {
+			continue
+		}
+		var matches []string
+		for label, value := range values {
+			if f.pattern.MatchString(value) {
+				matches = append(matches, label)
+			}
+		}
+		if len(matches) != 1 {
+			continue
+		}
+		warnLog.Printf("field %q not found; using %q, which looks like %s (set %s to silence this)", *f.label, matches[0], f.what, f.flag)
+		*f.label = matches[0]
+	}
+}
+
+// warnKeyExpiry warns when the expires date of an API Credential item has
+// passed or is near, so stale keys are rotated before STS rejects them.
+func warnKeyExpiry(expires string, now time.Time) {
+	if expires == "" {
+		return
+	}
+	t, err := parseItemDate(expires)
+	if err != nil {
+		debugLog.Printf("ignoring the expires field: %v", err)
+		return
+	}
+	switch {
+	case !now.Before(t):
+		warnLog.Printf("the access key in 1Password expired on %s; rotate it", t.Format(time.DateOnly))
+	case t.Sub(now) < keyExpiryWarning:
+		warnLog.Printf("the access key in 1Password expires on %s; rotate it soon", t.Format(time.DateOnly))
+	}
+}
+
+// parseItemDate parses the value of a date field, which op prints as Unix
+// seconds and the SDK as YYYY-MM-DD.
+func parseItemDate(value string) (time.Time, error) {
+	if secs, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(secs, 0).UTC(), nil
+	}
+	t, err := time.Parse(time.DateOnly, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %q", value)
+	}
+	return t, nil
+}
+
+// isOpID reports whether s looks like a 1Password vault or item ID, 26
+// lowercase letters and digits, rather than a name.
+func isOpID(s string) bool {
+	if len(s) != 26 {
+		return false
+	}
+	for _, c := range s {
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// parseSecretRef returns the vault and item an op://vault/item/[section/]field
+// secret reference points at.
+func parseSecretRef(ref string) (vault, item string, err error) {
+	path, ok := strings.CutPrefix(ref, "op://")
+	if ok {
+		path, _, _ = strings.Cut(path, "?")
+		parts := strings.Split(path, "/")
+		if (len(parts) == 3 || len(parts) == 4) && !slices.Contains(parts, "") {
+			return parts[0], parts[1], nil
+		}
+	}
+	return "", "", fmt.Errorf("invalid secret reference %q; want op://vault/item/field", ref)
+}
+
 type opCLICredentialSource struct {
 	cliPath string
+	// env is added to the environment of op, e.g. a service account token.
+	env []string
+	// checked is set once the version of op has been checked.
+	checked bool
 	OpAwsItem
 }
 
+// minOpVersion is the oldest op that supports --format json and
+// --fields label=.
+var minOpVersion = [3]int{2, 0, 0}
+
+// checkVersion fails early with a clear message when op is missing or older
+// than minOpVersion, rather than surfacing op's usage errors. A version that
+// cannot be read is let through.
+func (s *opCLICredentialSource) checkVersion(ctx context.Context) error {
+	if s.checked {
+		return nil
+	}
+	out, err := exec.CommandContext(ctx, s.cliPath, "--version").Output()
+	if errors.Is(err, exec.ErrNotFound) || errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("1Password CLI %q not found; install op v2 or set --op-cli-path", s.cliPath)
+	}
+	s.checked = true
+	if err != nil {
+		debugLog.Printf("skipping the op version check: %v", err)
+		return nil
+	}
+	v, ok := parseOpVersion(string(out))
+	if !ok {
+		debugLog.Printf("skipping the op version check: unrecognized version %q", strings.TrimSpace(string(out)))
+		return nil
+	}
+	if slices.Compare(v[:], minOpVersion[:]) < 0 {
+		return fmt.Errorf("1Password CLI %s is too old; op-aws-credential-process requires %d.%d.%d or later", strings.TrimSpace(string(out)), minOpVersion[0], minOpVersion[1], minOpVersion[2])
+	}
+	return nil
+}
+
+// parseOpVersion parses the output of op --version, e.g. 2.30.0 or
+// 2.31.0-beta.01.
+func parseOpVersion(out string) ([3]int, bool) {
+	var v [3]int
+	core, _, _ := strings.Cut(strings.TrimSpace(out), "-")
+	parts := strings.Split(core, ".")
+	if len(parts) != 3 {
+		return v, false
+	}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return v, false
+		}
+		v[i] = n
+	}
+	return v, true
+}
+
 func (s *opCLICredentialSource) Retrieve(ctx context.Context) (aws.Credentials, error) {
-	fields := fmt.Sprintf("label=%s,label=%s", s.AccessKeyIDField, s.SecretAccessKeyField)
-	cmd := exec.CommandContext(ctx, s.cliPath,
-		"item", "get", s.Item,
-		"--vault", s.Vault,
-		"--fields", fields,
-		"--format", "json",
-	)
+	return readCredentials(ctx, s)
+}
+
+func (s *opCLICredentialSource) awsItem() OpAwsItem {
+	return s.OpAwsItem
+}
+
+func (s *opCLICredentialSource) withItem(vault, item string) opItem {
+	other := *s
+	other.Vault = vault
+	other.Item = item
+	return &other
+}
+
+// itemGet runs op item get for the item with the given extra arguments.
+func (s *opCLICredentialSource) itemGet(ctx context.Context, args ...string) ([]byte, error) {
+	out, err := s.run(ctx, append([]string{"item", "get", s.Item, "--vault", s.Vault}, args...)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get op item: %w", err)
+	}
+	return out, nil
+}
+
+func (s *opCLICredentialSource) details(ctx context.Context) (*opItemDetails, error) {
+	out, err := s.itemGet(ctx, "--format", "json")
+	if err != nil {
+		return nil, err
+	}
+	var item struct {
+		Category string    `json:"category"`
+		Fields   []opField `json:"fields"`
+	}
+	if err := json.Unmarshal(out, &item); err != nil {
+		return nil, err
+	}
+
+	details := &opItemDetails{apiCredential: item.Category == "API_CREDENTIAL", fields: make(map[string]string, len(item.Fields))}
+	for _, field := range item.Fields {
+		if field.Type == "OTP" {
+			continue
+		}
+		if _, ok := details.fields[field.Label]; !ok {
+			details.fields[field.Label] = field.Value
+		}
+	}
+	return details, nil
+}
+
+func (s *opCLICredentialSource) read(ctx context.Context, ref string) (string, error) {
+	out, err := s.run(ctx, "read", "--no-newline", ref)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", ref, err)
+	}
+	return string(out), nil
+}
+
+// errOpLocked is returned when op cannot read the item until the user signs
+// in or unlocks 1Password.
+var errOpLocked = errors.New("1Password CLI is locked")
+
+// opUnlockHints maps messages op prints when it needs the user to sign in to
+// instructions for doing so, since op's own messages rarely say how.
+var opUnlockHints = []struct {
+	match []string
+	hint  string
+}{
+	{
+		match: []string{"not currently signed in", "account is not signed in", "session expired", "no accounts configured"},
+		hint:  `turn on "Integrate with 1Password CLI" in the 1Password app under Settings > Developer to unlock with biometrics, or run eval $(op signin); on hosts without the app, set OP_SERVICE_ACCOUNT_TOKEN`,
+	},
+	{
+		match: []string{"authorization prompt dismissed", "authorization timeout"},
+		hint:  "the 1Password unlock prompt was dismissed or timed out; run the command again and approve it",
+	},
+	{
+		match: []string{"connecting to desktop app", "cannot connect to 1password app"},
+		hint:  "the 1Password app could not be reached; start and unlock it, or turn off its CLI integration and run eval $(op signin)",
+	},
+}
+
+// opUnlockHint returns instructions for the sign-in op reports in stderr, or
+// an empty string when it failed for another reason.
+func opUnlockHint(stderr []byte) string {
+	lower := strings.ToLower(string(stderr))
+	for _, h := range opUnlockHints {
+		for _, match := range h.match {
+			if strings.Contains(lower, match) {
+				return h.hint
+			}
+		}
+	}
+	return ""
+}
+
+// run runs op with args, adding its stderr to the error when it fails.
+// Failures for want of a sign-in are replaced with instructions, and op's
+// stderr is left to --debug.
+func (s *opCLICredentialSource) run(ctx context.Context, args ...string) ([]byte, error) {
+	return s.runWithInput(ctx, nil, args...)
+}
+
+// runWithInput runs op like run, with input on its standard input.
+func (s *opCLICredentialSource) runWithInput(ctx context.Context, input []byte, args ...string) ([]byte, error) {
+	if err := s.checkVersion(ctx); err != nil {
+		return nil, err
+	}
+	if s.Account != "" {
+		args = append([]string{"--account", s.Account}, args...)
+	}
+	cmd := exec.CommandContext(ctx, s.cliPath, args...)
+	if len(s.env) > 0 {
+		cmd.Env = append(os.Environ(), s.env...)
+	}
+	if input != nil {
+		cmd.Stdin = bytes.NewReader(input)
+	}
 	out, err := cmd.Output()
 	if err != nil {
 		var exitErr *exec.ExitError
 		if errors.As(err, &exitErr) {
-			return aws.Credentials{}, fmt.Errorf("failed to get op item: %w\n%s", err, exitErr.Stderr)
+			if hint := opUnlockHint(exitErr.Stderr); hint != "" {
+				debugLog.Printf("op failed: %v\n%s", err, exitErr.Stderr)
+				return nil, fmt.Errorf("%w: %s", errOpLocked, hint)
+			}
+			return nil, fmt.Errorf("%w\n%s", err, exitErr.Stderr)
 		}
-		return aws.Credentials{}, err
+		return nil, err
 	}
+	return out, nil
+}
 
-	var items []struct {
-		Label string `json:"label"`
-		Value string `json:"value"`
+type opField struct {
+	Label string `json:"label"`
+	Type  string `json:"type"`
+	Value string `json:"value"`
+	// TOTP is the current code of a one-time password field.
+	TOTP string `json:"totp"`
+}
+
+func (s *opCLICredentialSource) fields(ctx context.Context, labels ...string) (map[string]string, error) {
+	items, err := s.fieldItems(ctx, labels...)
+	if err != nil {
+		return nil, err
 	}
-	if err := json.Unmarshal(out, &items); err != nil {
-		return aws.Credentials{}, err
+
+	values := make(map[string]string, len(items))
+	for _, item := range items {
+		values[item.Label] = item.Value
 	}
+	return values, nil
+}
 
-	var creds aws.Credentials
+func (s *opCLICredentialSource) fieldItems(ctx context.Context, labels ...string) ([]opField, error) {
+	selectors := make([]string, len(labels))
+	for i, label := range labels {
+		selectors[i] = "label=" + label
+	}
+	out, err := s.itemGet(ctx, "--fields", strings.Join(selectors, ","), "--format", "json")
+	if err != nil {
+		return nil, err
+	}
+
+	var items []opField
+	// op prints a single object rather than an array when one field is requested.
+	if trimmed := strings.TrimSpace(string(out)); strings.HasPrefix(trimmed, "{") {
+		var item opField
+		if err := json.Unmarshal(out, &item); err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	} else if err := json.Unmarshal(out, &items); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+func (s *opCLICredentialSource) totp(ctx context.Context, label string) (string, error) {
+	if label == "" {
+		out, err := s.itemGet(ctx, "--otp")
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(out)), nil
+	}
+
+	items, err := s.fieldItems(ctx, label)
+	if err != nil {
+		return "", err
+	}
 	for _, item := range items {
-		switch item.Label {
-		case s.AccessKeyIDField:
-			creds.AccessKeyID = item.Value
-		case s.SecretAccessKeyField:
-			creds.SecretAccessKey = item.Value
+		if item.Label == label && item.TOTP != "" {
+			return item.TOTP, nil
 		}
 	}
-	if creds.AccessKeyID == "" || creds.SecretAccessKey == "" {
-		return aws.Credentials{}, fmt.Errorf("missing credentials in op output")
+	return "", fmt.Errorf("missing one-time password field %q in op output", label)
+}
+
+func (s *opCLICredentialSource) otpSeed(ctx context.Context, label string) (string, error) {
+	if label != "" {
+		values, err := s.fields(ctx, label)
+		if err != nil {
+			return "", err
+		}
+		if seed := values[label]; seed != "" {
+			return seed, nil
+		}
+		return "", fmt.Errorf("missing one-time password field %q in op output", label)
 	}
-	return creds, nil
+
+	out, err := s.itemGet(ctx, "--format", "json")
+	if err != nil {
+		return "", err
+	}
+	var item struct {
+		Fields []struct {
+			Type  string `json:"type"`
+			Value string `json:"value"`
+		} `json:"fields"`
+	}
+	if err := json.Unmarshal(out, &item); err != nil {
+		return "", err
+	}
+	for _, field := range item.Fields {
+		if field.Type == "OTP" && field.Value != "" {
+			return field.Value, nil
+		}
+	}
+	return "", errors.New("missing one-time password field in op output")
+}
+
+// opTagSource reads session tags from item fields; each field label is
+// used as the tag key.
+type opTagSource struct {
+	source opItem
+	labels []string
+}
+
+func (s *opTagSource) SessionTags(ctx context.Context) (map[string]string, error) {
+	values, err := s.source.fields(ctx, s.labels...)
+	if err != nil {
+		return nil, err
+	}
+	for _, label := range s.labels {
+		if _, ok := values[label]; !ok {
+			return nil, fmt.Errorf("missing tag field %q in op output", label)
+		}
+	}
+	return values, nil
+}
+
+// opWebIdentityTokenSource reads an OIDC token stored in an item field.
+type opWebIdentityTokenSource struct {
+	source opItem
+	label  string
+}
+
+func (s *opWebIdentityTokenSource) WebIdentityToken(ctx context.Context) (string, error) {
+	values, err := s.source.fields(ctx, s.label)
+	if err != nil {
+		return "", err
+	}
+	token, ok := values[s.label]
+	if !ok {
+		return "", fmt.Errorf("missing web identity token field %q in op output", s.label)
+	}
+	return token, nil
+}
+
+// opRoleSessionType is the session type of roles whose ARN is read from
+// the item, which is not known until the session is minted.
+const opRoleSessionType = "assume-role"
+
+// opRoleSource reads the role ARN and external ID from item fields when a
+// session is minted, so external IDs are kept out of plaintext config files.
+// Sessions are keyed on the labels, and a change to the fields takes effect
+// once the session is refreshed. Either label may be empty.
+type opRoleSource struct {
+	source          opItem
+	roleArnLabel    string
+	externalIDLabel string
+}
+
+func (s *opRoleSource) Role(ctx context.Context) (string, string, error) {
+	var labels []string
+	for _, label := range []string{s.roleArnLabel, s.externalIDLabel} {
+		if label != "" {
+			labels = append(labels, label)
+		}
+	}
+	values, err := s.source.fields(ctx, labels...)
+	if err != nil {
+		return "", "", err
+	}
+	for _, label := range labels {
+		if values[label] == "" {
+			return "", "", fmt.Errorf("missing role field %q in op output", label)
+		}
+	}
+	return values[s.roleArnLabel], values[s.externalIDLabel], nil
+}
+
+// opSettingsSource reads the STS region and session duration from item
+// fields, so the item describes how to use the key on any machine. The
+// region is read when a session is minted, and the duration before the
+// cache is, as it keys the session. Either label may be empty, and fields
+// missing from the item leave the config in place. The fields are read
+// once.
+type opSettingsSource struct {
+	source        opItem
+	regionLabel   string
+	durationLabel string
+	// partition is the partition of the configured region, which the
+	// item's region must belong to.
+	partition string
+
+	read     bool
+	region   string
+	duration time.Duration
+	err      error
+}
+
+func (s *opSettingsSource) stsSettings(ctx context.Context) (string, time.Duration, error) {
+	if !s.read {
+		s.region, s.duration, s.err = s.fetch(ctx)
+		s.read = true
+	}
+	return s.region, s.duration, s.err
+}
+
+func (s *opSettingsSource) stsRegion(ctx context.Context) (string, error) {
+	region, _, err := s.stsSettings(ctx)
+	return region, err
+}
+
+func (s *opSettingsSource) fetch(ctx context.Context) (string, time.Duration, error) {
+	var labels []string
+	for _, label := range []string{s.regionLabel, s.durationLabel} {
+		if label != "" {
+			labels = append(labels, label)
+		}
+	}
+	values, err := s.source.fields(ctx, labels...)
+	if err != nil {
+		return "", 0, err
+	}
+
+	region := strings.TrimSpace(values[s.regionLabel])
+	if region != "" {
+		if p := partitionForRegion(region); p != s.partition {
+			return "", 0, fmt.Errorf("region %s in field %q belongs to partition %s, not %s", region, s.regionLabel, p, s.partition)
+		}
+		debugLog.Printf("using region %s from field %q", region, s.regionLabel)
+	}
+	var duration time.Duration
+	if value := strings.TrimSpace(values[s.durationLabel]); value != "" {
+		// Plain numbers are seconds, like duration_seconds.
+		if seconds, err := strconv.Atoi(value); err == nil {
+			duration = time.Duration(seconds) * time.Second
+		} else if duration, err = time.ParseDuration(value); err != nil {
+			return "", 0, fmt.Errorf("invalid duration in field %q: %w", s.durationLabel, err)
+		}
+		debugLog.Printf("using session duration %s from field %q", duration, s.durationLabel)
+	}
+	return region, duration, nil
+}
+
+// opMfaSerialSource reads the MFA device ARN from an item field, so the item
+// describes the device alongside the key pair.
+type opMfaSerialSource struct {
+	source opItem
+	label  string
+}
+
+func (s *opMfaSerialSource) MfaSerial(ctx context.Context) (string, error) {
+	values, err := s.source.fields(ctx, s.label)
+	if err != nil {
+		return "", err
+	}
+	serial, ok := values[s.label]
+	if !ok || serial == "" {
+		return "", fmt.Errorf("missing MFA serial field %q in op output", s.label)
+	}
+	return serial, nil
+}
+
+// opItemOTPSource reads the current MFA code from the item, so no prompt is
+// needed when the MFA seed is stored in 1Password. Without a label, the
+// item's primary one-time password is used.
+type opItemOTPSource struct {
+	source opItem
+	label  string
+	// local fetches the otpauth:// seed once and computes codes in memory,
+	// which saves op round trips on retries and avoids codes op reports
+	// stale at window boundaries.
+	local bool
+	key   *totpKey
+	now   func() time.Time
+}
+
+func (s *opItemOTPSource) OTP(ctx context.Context, serial string) (string, error) {
+	if s.local {
+		if s.key == nil {
+			seed, err := s.source.otpSeed(ctx, s.label)
+			if err != nil {
+				return "", err
+			}
+			if s.key, err = parseOTPAuth(seed); err != nil {
+				return "", err
+			}
+		}
+		now := time.Now
+		if s.now != nil {
+			now = s.now
+		}
+		return s.key.code(now()), nil
+	}
+
+	return s.source.totp(ctx, s.label)
 }
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// opItemCacheKeyAccount is the keyring account of the item cache key.
+const opItemCacheKeyAccount = "op-item-cache"
+
+// opItemCacheEntry is a cached item as written to disk. SavedAt is
+// authenticated along with the ciphertext, so the item cannot be kept past
+// its TTL by editing the file.
+type opItemCacheEntry struct {
+	SavedAt time.Time `json:"saved_at"`
+	sealed
+}
+
+type opItemCachePlaintext struct {
+	APICredential bool              `json:"api_credential"`
+	Fields        map[string]string `json:"fields"`
+}
+
+// cachedOpItem keeps the fields the key pair is read from through opItem,
+// encrypted, for ttl, so invocations minting sessions for several profiles
+// backed by the same item read it from 1Password, and prompt to unlock it,
+// once. Other fields, one-time passwords, and secret references are always
+// read afresh.
+type cachedOpItem struct {
+	opItem
+	dir    string
+	cipher *keyringCipher
+	ttl    time.Duration
+	now    func() time.Time
+
+	cached *opItemDetails
+	// unsaved is set when cached was read from the backend rather than the
+	// cache.
+	unsaved bool
+}
+
+// opItemCachePath returns where the item is cached.
+func opItemCachePath(dir string, item OpAwsItem) string {
+	sum := sha1.Sum([]byte(strings.Join([]string{item.Account, item.Vault, item.Item}, "\x00")))
+	return filepath.Join(dir, "op-items", hex.EncodeToString(sum[:])+".json")
+}
+
+func (s *cachedOpItem) timeNow() time.Time {
+	if s.now == nil {
+		return time.Now()
+	}
+	return s.now()
+}
+
+func (s *cachedOpItem) Retrieve(ctx context.Context) (aws.Credentials, error) {
+	creds, err := readCredentials(ctx, s)
+	if err != nil || !s.unsaved {
+		return creds, err
+	}
+	s.unsaved = false
+	if err := s.save(ctx, opItemCachePath(s.dir, s.awsItem()), credentialDetails(s.cached, creds)); err != nil {
+		debugLog.Printf("failed to cache the item: %v", err)
+	}
+	return creds, nil
+}
+
+// credentialDetails returns what of details is cached once creds are read
+// from it: the fields holding the key pair and, of an API Credential item,
+// its expiry date.
+func credentialDetails(details *opItemDetails, creds aws.Credentials) *opItemDetails {
+	kept := &opItemDetails{apiCredential: details.apiCredential, fields: make(map[string]string)}
+	for label, value := range details.fields {
+		if value == creds.AccessKeyID || value == creds.SecretAccessKey || details.apiCredential && label == apiCredentialExpiresLabel {
+			kept.fields[label] = value
+		}
+	}
+	return kept
+}
+
+func (s *cachedOpItem) withItem(vault, item string) opItem {
+	return &cachedOpItem{opItem: s.opItem.withItem(vault, item), dir: s.dir, cipher: s.cipher, ttl: s.ttl, now: s.now}
+}
+
+func (s *cachedOpItem) details(ctx context.Context) (*opItemDetails, error) {
+	if s.cached != nil {
+		return s.cached, nil
+	}
+	path := opItemCachePath(s.dir, s.awsItem())
+	if details, err := s.load(ctx, path); err == nil {
+		debugLog.Printf("read the item from the cache")
+		s.cached = details
+		return details, nil
+	} else if !os.IsNotExist(err) {
+		debugLog.Printf("ignoring the cached item: %v", err)
+	}
+
+	details, err := s.opItem.details(ctx)
+	if err != nil || details == nil {
+		return details, err
+	}
+	s.cached, s.unsaved = details, true
+	return details, nil
+}
+
+// fields answers from the whole item when it has every label, and asks the
+// backend otherwise, since op matches labels more loosely than exactly.
+func (s *cachedOpItem) fields(ctx context.Context, labels ...string) (map[string]string, error) {
+	details, err := s.details(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if details == nil {
+		return s.opItem.fields(ctx, labels...)
+	}
+	values := make(map[string]string, len(labels))
+	for _, label := range labels {
+		value, ok := details.fields[label]
+		if !ok {
+			return s.opItem.fields(ctx, labels...)
+		}
+		values[label] = value
+	}
+	return values, nil
+}
+
+func (s *cachedOpItem) save(ctx context.Context, path string, details *opItemDetails) error {
+	plaintext, err := json.Marshal(opItemCachePlaintext{APICredential: details.apiCredential, Fields: details.fields})
+	if err != nil {
+		return err
+	}
+	entry := opItemCacheEntry{SavedAt: s.timeNow().UTC().Truncate(time.Second)}
+	sealed, err := s.cipher.seal(ctx, plaintext, opItemCacheAdditionalData(path, entry.SavedAt))
+	if err != nil {
+		return err
+	}
+	entry.sealed = *sealed
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data, 0600)
+}
+
+// load returns the cached item, deleting it once it is older than ttl.
+func (s *cachedOpItem) load(ctx context.Context, path string) (*opItemDetails, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var entry opItemCacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, err
+	}
+	if age := s.timeNow().Sub(entry.SavedAt); age < 0 || age > s.ttl {
+		_ = os.Remove(path)
+		return nil, os.ErrNotExist
+	}
+	plaintext, err := s.cipher.open(ctx, &entry.sealed, opItemCacheAdditionalData(path, entry.SavedAt))
+	if err != nil {
+		return nil, err
+	}
+	var p opItemCachePlaintext
+	if err := json.Unmarshal(plaintext, &p); err != nil {
+		return nil, err
+	}
+	return &opItemDetails{apiCredential: p.APICredential, fields: p.Fields}, nil
+}
+
+// opItemCacheAdditionalData binds a cached item to its file, so it cannot
+// stand in for another item, and to when it was saved.
+func opItemCacheAdditionalData(path string, savedAt time.Time) []byte {
+	return []byte(filepath.Base(path) + "\x00" + savedAt.UTC().Format(time.RFC3339))
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeOpItem reads an item held in memory, counting the reads.
+type fakeOpItem struct {
+	opItem
+	item      OpAwsItem
+	values    map[string]string
+	err       error
+	reads     int
+	fieldReqs int
+}
+
+func (f *fakeOpItem) awsItem() OpAwsItem { return f.item }
+
+func (f *fakeOpItem) details(ctx context.Context) (*opItemDetails, error) {
+	f.reads++
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &opItemDetails{fields: f.values}, nil
+}
+
+func (f *fakeOpItem) fields(ctx context.Context, labels ...string) (map[string]string, error) {
+	f.fieldReqs++
+	values := make(map[string]string)
+	for _, label := range labels {
+		for k, v := range f.values {
+			if strings.EqualFold(k, label) {
+				values[label] = v
+			}
+		}
+	}
+	return values, nil
+}
+
+func TestCachedOpItem(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	now := time.Date(2026, 10, 15, 9, 0, 0, 0, time.UTC)
+	ring := newFakeKeyring()
+	source := &fakeOpItem{
+		item:   defaultOpAwsItem(),
+		values: map[string]string{"username": "AKIA", "credential": "secret", "Role": "arn:aws:iam::222222222222:role/Admin"},
+	}
+	newItem := func() *cachedOpItem {
+		return &cachedOpItem{
+			opItem: source,
+			dir:    dir,
+			cipher: &keyringCipher{keyring: ring, account: opItemCacheKeyAccount},
+			ttl:    5 * time.Minute,
+			now:    func() time.Time { return now },
+		}
+	}
+
+	// The first invocation reads the item; the next ones, for other
+	// profiles, read the cache.
+	for range 3 {
+		creds, err := newItem().Retrieve(ctx)
+		if err != nil {
+			t.Fatalf("Retrieve() error = %v", err)
+		}
+		if creds.AccessKeyID != "AKIA" || creds.SecretAccessKey != "secret" {
+			t.Errorf("Retrieve() = %+v, want the item's key pair", creds)
+		}
+	}
+	if source.reads != 1 {
+		t.Errorf("reads = %d, want 1", source.reads)
+	}
+	data, err := os.ReadFile(opItemCachePath(dir, source.item))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "secret") {
+		t.Errorf("cached item = %s, want it encrypted", data)
+	}
+
+	item := newItem()
+	values, err := item.fields(ctx, "username")
+	if err != nil {
+		t.Fatalf("fields() error = %v", err)
+	}
+	if values["username"] != "AKIA" || source.fieldReqs != 0 {
+		t.Errorf("fields() = %v with %d requests, want the access key ID from the cache", values, source.fieldReqs)
+	}
+	// Only the key pair is cached; other fields are left to the backend.
+	if values, err := item.fields(ctx, "Role"); err != nil || values["Role"] == "" || source.fieldReqs != 1 {
+		t.Errorf("fields() = %v, %v with %d requests, want the backend asked", values, err, source.fieldReqs)
+	}
+
+	now = now.Add(6 * time.Minute)
+	if _, err := newItem().Retrieve(ctx); err != nil {
+		t.Fatalf("Retrieve() error = %v", err)
+	}
+	if source.reads != 2 {
+		t.Errorf("reads = %d, want 2 after the TTL", source.reads)
+	}
+}
+
+func TestCachedOpItem_OnlyKeyPair(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	source := &fakeOpItem{
+		item: defaultOpAwsItem(),
+		values: map[string]string{
+			"username":          "AKIA",
+			"credential":        "secret",
+			"password":          "hunter2",
+			"notesPlain":        "recovery codes",
+			"one-time password": "otpauth://totp/AWS?secret=JBSWY3DPEHPK3PXP",
+		},
+	}
+	item := &cachedOpItem{
+		opItem: source,
+		dir:    dir,
+		cipher: &keyringCipher{keyring: newFakeKeyring(), account: opItemCacheKeyAccount},
+		ttl:    5 * time.Minute,
+	}
+	if _, err := item.Retrieve(ctx); err != nil {
+		t.Fatalf("Retrieve() error = %v", err)
+	}
+
+	cached, err := item.load(ctx, opItemCachePath(dir, source.item))
+	if err != nil {
+		t.Fatalf("load() error = %v", err)
+	}
+	if len(cached.fields) != 2 || cached.fields["username"] != "AKIA" || cached.fields["credential"] != "secret" {
+		t.Errorf("cached fields = %v, want only the key pair", cached.fields)
+	}
+	for _, value := range cached.fields {
+		if strings.Contains(value, "otpauth://") {
+			t.Errorf("cached fields = %v, want no one-time password seed", cached.fields)
+		}
+	}
+}
+
+func TestCachedOpItem_Tampered(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	now := time.Date(2026, 10, 15, 9, 0, 0, 0, time.UTC)
+	source := &fakeOpItem{item: defaultOpAwsItem(), values: map[string]string{"username": "AKIA", "credential": "secret"}}
+	item := &cachedOpItem{
+		opItem: source,
+		dir:    dir,
+		cipher: &keyringCipher{keyring: newFakeKeyring(), account: opItemCacheKeyAccount},
+		ttl:    5 * time.Minute,
+		now:    func() time.Time { return now },
+	}
+	if _, err := item.Retrieve(ctx); err != nil {
+		t.Fatalf("Retrieve() error = %v", err)
+	}
+
+	// Moving saved_at forward must not extend the cache's life, and a
+	// file copied from another item must not stand in for it.
+	path := opItemCachePath(dir, source.item)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := opItemCachePath(dir, OpAwsItem{Vault: "Private", Item: "other"})
+	if err := os.MkdirAll(filepath.Dir(other), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(other, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	data = []byte(strings.Replace(string(data), "2026-10-15T09:00:00Z", "2026-10-15T09:10:00Z", 1))
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(12 * time.Minute)
+	item.cached = nil
+	source.err = errors.New("op is locked")
+	if _, err := item.Retrieve(ctx); err == nil {
+		t.Error("Retrieve() error = nil, want the tampered cache ignored")
+	}
+
+	otherItem := &cachedOpItem{opItem: &fakeOpItem{item: OpAwsItem{Vault: "Private", Item: "other"}, err: errors.New("op is locked")}, dir: dir, cipher: item.cipher, ttl: time.Hour, now: item.now}
+	if _, err := otherItem.Retrieve(ctx); err == nil {
+		t.Error("Retrieve() error = nil, want a copied cache ignored")
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/1password/onepassword-sdk-go"
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// SecretResolver resolves op:// secret references.
+type SecretResolver interface {
+	Resolve(ctx context.Context, secretReference string) (string, error)
+}
+
+// ItemGetter reads a whole item by vault and item ID.
+type ItemGetter interface {
+	Get(ctx context.Context, vaultID string, itemID string) (onepassword.Item, error)
+}
+
+// opSDKClient is the part of the 1Password SDK client the item is read with.
+type opSDKClient struct {
+	secrets SecretResolver
+	items   ItemGetter
+}
+
+// primaryOTPLabel is the label 1Password gives the one-time password field
+// it adds to items.
+const primaryOTPLabel = "one-time password"
+
+// opSDKCredentialSource reads the item with the 1Password Go SDK instead of
+// running op, which removes the dependency on the CLI and its subprocess
+// overhead.
+type opSDKCredentialSource struct {
+	// connect creates the SDK client on first use, so runs served from the
+	// session cache do not pay for it.
+	connect func(ctx context.Context) (*opSDKClient, error)
+	client  *opSDKClient
+	OpAwsItem
+}
+
+// newOpSDKConnector returns a connect function for opSDKCredentialSource
+// that signs in with the service account token, or through the desktop app
+// as account when token is empty.
+func newOpSDKConnector(token, account string) func(ctx context.Context) (*opSDKClient, error) {
+	return func(ctx context.Context) (*opSDKClient, error) {
+		opts := []onepassword.ClientOption{onepassword.WithIntegrationInfo("op-aws-credential-process", version)}
+		switch {
+		case token != "":
+			opts = append(opts, onepassword.WithServiceAccountToken(token))
+		case account != "":
+			opts = append(opts, onepassword.WithDesktopAppIntegration(account))
+		default:
+			return nil, fmt.Errorf("the 1Password SDK requires OP_SERVICE_ACCOUNT_TOKEN, --op-service-account-token-file, or --op-account")
+		}
+		client, err := onepassword.NewClient(ctx, opts...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create 1Password SDK client: %w", err)
+		}
+		return &opSDKClient{secrets: client.Secrets(), items: client.Items()}, nil
+	}
+}
+
+func (s *opSDKCredentialSource) sdkClient(ctx context.Context) (*opSDKClient, error) {
+	if s.client == nil {
+		client, err := s.connect(ctx)
+		if err != nil {
+			return nil, err
+		}
+		s.client = client
+	}
+	return s.client, nil
+}
+
+func (s *opSDKCredentialSource) resolve(ctx context.Context, ref string) (string, error) {
+	client, err := s.sdkClient(ctx)
+	if err != nil {
+		return "", err
+	}
+	return client.secrets.Resolve(ctx, ref)
+}
+
+// byID reports whether the item is given by vault and item IDs, so it can be
+// read whole in one call instead of field by field by name.
+func (s *opSDKCredentialSource) byID() bool {
+	return isOpID(s.Vault) && isOpID(s.Item)
+}
+
+// item reads the item by ID.
+func (s *opSDKCredentialSource) item(ctx context.Context) (onepassword.Item, error) {
+	client, err := s.sdkClient(ctx)
+	if err != nil {
+		return onepassword.Item{}, err
+	}
+	item, err := client.items.Get(ctx, s.Vault, s.Item)
+	if err != nil {
+		return onepassword.Item{}, fmt.Errorf("failed to get 1Password item %s: %w", s.Item, err)
+	}
+	return item, nil
+}
+
+// otpField returns the one-time password field label of the item read by
+// ID, or its first one-time password field when label is empty.
+func (s *opSDKCredentialSource) otpField(ctx context.Context, label string) (onepassword.ItemField, error) {
+	item, err := s.item(ctx)
+	if err != nil {
+		return onepassword.ItemField{}, err
+	}
+	for _, field := range item.Fields {
+		if field.FieldType == onepassword.ItemFieldTypeTOTP && (label == "" || field.Title == label) {
+			return field, nil
+		}
+	}
+	return onepassword.ItemField{}, fmt.Errorf("missing one-time password field %q in 1Password item %s", label, s.Item)
+}
+
+func (s *opSDKCredentialSource) ref(label string) string {
+	return fmt.Sprintf("op://%s/%s/%s", s.Vault, s.Item, label)
+}
+
+func (s *opSDKCredentialSource) Retrieve(ctx context.Context) (aws.Credentials, error) {
+	return readCredentials(ctx, s)
+}
+
+func (s *opSDKCredentialSource) awsItem() OpAwsItem {
+	return s.OpAwsItem
+}
+
+func (s *opSDKCredentialSource) withItem(vault, item string) opItem {
+	other := *s
+	other.Vault = vault
+	other.Item = item
+	return &other
+}
+
+func (s *opSDKCredentialSource) details(ctx context.Context) (*opItemDetails, error) {
+	// Without IDs the SDK reads the item only field by field.
+	if !s.byID() {
+		return nil, nil
+	}
+	item, err := s.item(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	details := &opItemDetails{apiCredential: item.Category == onepassword.ItemCategoryAPICredentials, fields: make(map[string]string, len(item.Fields))}
+	for _, field := range item.Fields {
+		if field.FieldType == onepassword.ItemFieldTypeTOTP {
+			continue
+		}
+		if _, ok := details.fields[field.Title]; !ok {
+			details.fields[field.Title] = field.Value
+		}
+	}
+	return details, nil
+}
+
+func (s *opSDKCredentialSource) fields(ctx context.Context, labels ...string) (map[string]string, error) {
+	if s.byID() {
+		details, err := s.details(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return details.fields, nil
+	}
+
+	values := make(map[string]string, len(labels))
+
+	for _, label := range labels {
+		value, err := s.resolve(ctx, s.ref(label))
+		if err != nil {
+			return nil, err
+		}
+		values[label] = value
+	}
+	return values, nil
+}
+
+func (s *opSDKCredentialSource) totp(ctx context.Context, label string) (string, error) {
+	if s.byID() {
+		field, err := s.otpField(ctx, label)
+		if err != nil {
+			return "", err
+		}
+		if field.Details == nil || field.Details.OTP() == nil || field.Details.OTP().Code == nil {
+			return "", fmt.Errorf("1Password could not compute the code of one-time password field %q", field.Title)
+		}
+		return *field.Details.OTP().Code, nil
+	}
+
+	if label == "" {
+		label = primaryOTPLabel
+	}
+	return s.resolve(ctx, s.ref(label)+"?attribute=totp")
+}
+
+func (s *opSDKCredentialSource) otpSeed(ctx context.Context, label string) (string, error) {
+	if s.byID() {
+		field, err := s.otpField(ctx, label)
+		if err != nil {
+			return "", err
+		}
+		return field.Value, nil
+	}
+
+	if label == "" {
+		label = primaryOTPLabel
+	}
+	return s.resolve(ctx, s.ref(label))
+}
+
+func (s *opSDKCredentialSource) read(ctx context.Context, ref string) (string, error) {
+	return s.resolve(ctx, ref)
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/1password/onepassword-sdk-go"
+)
+
+// fakeSecretResolver resolves references from a map, standing in for the
+// 1Password SDK.
+type fakeSecretResolver struct {
+	secrets map[string]string
+}
+
+func (r *fakeSecretResolver) Resolve(ctx context.Context, ref string) (string, error) {
+	secret, ok := r.secrets[ref]
+	if !ok {
+		return "", errors.New("no such secret: " + ref)
+	}
+	return secret, nil
+}
+
+func TestOpSDKCredentialSource(t *testing.T) {
+	connects := 0
+	source := &opSDKCredentialSource{
+		connect: func(ctx context.Context) (*opSDKClient, error) {
+			connects++
+			return &opSDKClient{secrets: &fakeSecretResolver{secrets: map[string]string{
+				"op://vault/item/Access key ID":                    "AKIA",
+				"op://vault/item/Secret access key":                "secret",
+				"op://vault/item/one-time password?attribute=totp": "123456",
+				"op://vault/other/one-time password":               "otpauth://totp/aws?secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ",
+				"op://vault/item/MFA?attribute=totp":               "654321",
+			}}}, nil
+		},
+		OpAwsItem: OpAwsItem{
+			Vault:                "vault",
+			Item:                 "item",
+			AccessKeyIDField:     "Access key ID",
+			SecretAccessKeyField: "Secret access key",
+		},
+	}
+	ctx := context.Background()
+
+	creds, err := source.Retrieve(ctx)
+	if err != nil {
+		t.Fatalf("Retrieve() error = %v", err)
+	}
+	if creds.AccessKeyID != "AKIA" || creds.SecretAccessKey != "secret" {
+		t.Errorf("Retrieve() = %+v", creds)
+	}
+
+	for label, want := range map[string]string{"": "123456", "MFA": "654321"} {
+		if got, err := source.totp(ctx, label); err != nil || got != want {
+			t.Errorf("totp(%q) = %q, %v, want %q", label, got, err, want)
+		}
+	}
+	if got, err := source.withItem("vault", "other").otpSeed(ctx, ""); err != nil || got != "otpauth://totp/aws?secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ" {
+		t.Errorf("otpSeed() = %q, %v", got, err)
+	}
+	if _, err := source.fields(ctx, "missing"); err == nil {
+		t.Error("fields() error = nil, want error for a missing field")
+	}
+
+	if connects != 1 {
+		t.Errorf("connected %d times, want 1", connects)
+	}
+}
+
+// fakeItemGetter returns items by vault and item ID, standing in for the
+// 1Password SDK.
+type fakeItemGetter struct {
+	items map[string]onepassword.Item
+}
+
+func (g *fakeItemGetter) Get(ctx context.Context, vaultID, itemID string) (onepassword.Item, error) {
+	item, ok := g.items[vaultID+"/"+itemID]
+	if !ok {
+		return onepassword.Item{}, errors.New("no such item: " + itemID)
+	}
+	return item, nil
+}
+
+func TestOpSDKCredentialSource_ByID(t *testing.T) {
+	const (
+		vaultID = "abcdefghijklmnopqrstuvwxyz"
+		itemID  = "0123456789abcdefghijklmnop"
+	)
+	code := "123456"
+	details := onepassword.NewItemFieldDetailsTypeVariantOTP(&onepassword.OTPFieldDetails{Code: &code})
+	source := &opSDKCredentialSource{
+		connect: func(ctx context.Context) (*opSDKClient, error) {
+			return &opSDKClient{
+				// Names are not resolved when the item is given by ID.
+				secrets: &fakeSecretResolver{},
+				items: &fakeItemGetter{items: map[string]onepassword.Item{
+					vaultID + "/" + itemID: {Fields: []onepassword.ItemField{
+						{Title: "Access key ID", Value: "AKIA"},
+						{Title: "Secret access key", Value: "secret"},
+						{
+							Title:     "one-time password",
+							FieldType: onepassword.ItemFieldTypeTOTP,
+							Value:     "otpauth://totp/aws?secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ",
+							Details:   &details,
+						},
+					}},
+				}},
+			}, nil
+		},
+		OpAwsItem: OpAwsItem{
+			Vault:                vaultID,
+			Item:                 itemID,
+			AccessKeyIDField:     "Access key ID",
+			SecretAccessKeyField: "Secret access key",
+		},
+	}
+	ctx := context.Background()
+
+	creds, err := source.Retrieve(ctx)
+	if err != nil {
+		t.Fatalf("Retrieve() error = %v", err)
+	}
+	if creds.AccessKeyID != "AKIA" || creds.SecretAccessKey != "secret" {
+		t.Errorf("Retrieve() = %+v", creds)
+	}
+	if got, err := source.totp(ctx, ""); err != nil || got != code {
+		t.Errorf("totp() = %q, %v, want %q", got, err, code)
+	}
+	if got, err := source.otpSeed(ctx, "one-time password"); err != nil || got != "otpauth://totp/aws?secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ" {
+		t.Errorf("otpSeed() = %q, %v", got, err)
+	}
+	if _, err := source.totp(ctx, "MFA"); err == nil {
+		t.Error("totp() error = nil, want error for a missing field")
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// opSessionStore keeps sessions in the fields of a dedicated 1Password
+// item, one concealed field per cache file name, so machines sharing the
+// vault reuse each other's sessions. The item is created on the first save,
+// and fields of expired sessions are dropped on each one.
+type opSessionStore struct {
+	op  *opCLICredentialSource
+	now func() time.Time
+}
+
+// isOpItemNotFound reports whether op failed because the item does not
+// exist.
+func isOpItemNotFound(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "isn't an item")
+}
+
+func (s *opSessionStore) load(ctx context.Context, name string) ([]byte, error) {
+	details, err := s.op.details(ctx)
+	if isOpItemNotFound(err) {
+		return nil, os.ErrNotExist
+	}
+	if err != nil {
+		return nil, err
+	}
+	value, ok := details.fields[name]
+	if !ok || value == "" {
+		return nil, os.ErrNotExist
+	}
+	return []byte(value), nil
+}
+
+func (s *opSessionStore) save(ctx context.Context, name string, data []byte) error {
+	return s.edit(ctx, name, data)
+}
+
+// list returns the fields holding sessions, which are named after cache
+// files. The item does not record when they were used.
+func (s *opSessionStore) list(ctx context.Context) ([]storedSession, error) {
+	details, err := s.op.details(ctx)
+	if isOpItemNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var sessions []storedSession
+	for label := range details.fields {
+		if filepath.Ext(label) == ".json" {
+			sessions = append(sessions, storedSession{name: label})
+		}
+	}
+	return sessions, nil
+}
+
+func (s *opSessionStore) delete(ctx context.Context, name string) error {
+	return s.edit(ctx, name, nil)
+}
+
+// edit sets the field name to data, or drops it when data is nil, dropping
+// the fields of expired sessions along the way.
+func (s *opSessionStore) edit(ctx context.Context, name string, data []byte) error {
+	out, err := s.op.itemGet(ctx, "--format", "json")
+	if isOpItemNotFound(err) && data == nil {
+		return nil
+	}
+	if isOpItemNotFound(err) {
+		template, err := json.Marshal(map[string]any{
+			"title":    s.op.Item,
+			"category": "SECURE_NOTE",
+			"fields":   []map[string]any{{"label": name, "type": "CONCEALED", "value": string(data)}},
+		})
+		if err != nil {
+			return err
+		}
+		return s.withTemplate(ctx, template, "item", "create", "--vault", s.op.Vault)
+	}
+	if err != nil {
+		return err
+	}
+
+	// The item is edited whole, keeping what op reports of it untouched
+	// but for the fields.
+	var item map[string]json.RawMessage
+	if err := json.Unmarshal(out, &item); err != nil {
+		return err
+	}
+	var fields []map[string]any
+	if raw, ok := item["fields"]; ok {
+		if err := json.Unmarshal(raw, &fields); err != nil {
+			return err
+		}
+	}
+	kept := make([]map[string]any, 0, len(fields)+1)
+	if data != nil {
+		kept = append(kept, map[string]any{"label": name, "type": "CONCEALED", "value": string(data)})
+	}
+	for _, field := range fields {
+		label, _ := field["label"].(string)
+		value, _ := field["value"].(string)
+		if label == name || s.expired(value) {
+			continue
+		}
+		kept = append(kept, field)
+	}
+	if item["fields"], err = json.Marshal(kept); err != nil {
+		return err
+	}
+	template, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+	return s.withTemplate(ctx, template, "item", "edit", s.op.Item, "--vault", s.op.Vault)
+}
+
+// expired reports whether value is a cached session that expired more than
+// cacheGracePeriod ago. Fields holding anything else are kept.
+func (s *opSessionStore) expired(value string) bool {
+	var entry cachedEntry
+	if err := json.Unmarshal([]byte(value), &entry); err != nil || entry.Credentials == nil || entry.Credentials.Expiration == nil {
+		return false
+	}
+	now := time.Now()
+	if s.now != nil {
+		now = s.now()
+	}
+	return now.After(aws.ToTime(entry.Credentials.Expiration).Add(cacheGracePeriod))
+}
+
+// withTemplate runs op with args and template on its standard input, so the
+// session never appears in the arguments of op, which other users can see,
+// nor in a file.
+func (s *opSessionStore) withTemplate(ctx context.Context, template []byte, args ...string) error {
+	if _, err := s.op.runWithInput(ctx, template, args...); err != nil {
+		return fmt.Errorf("failed to save the session to 1Password: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// writeFakeOpItemCLI writes an op that keeps one item in a file, and logs
+// its arguments to a file next to it.
+func writeFakeOpItemCLI(t *testing.T) (cliPath, itemPath, argsPath string) {
+	t.Helper()
+	dir := t.TempDir()
+	cliPath = filepath.Join(dir, "op")
+	itemPath = filepath.Join(dir, "item.json")
+	argsPath = filepath.Join(dir, "args")
+	script := `#!/bin/sh
+[ "$1" = --version ] && echo 2.30.0 && exit 0
+echo "$@" >> ` + argsPath + `
+case "$1 $2" in
+"item get")
+	[ -f ` + itemPath + ` ] || { echo "[ERROR] 2026/10/15 09:00:00 \"$3\" isn't an item in the \"$5\" vault." >&2; exit 1; }
+	cat ` + itemPath + `
+	;;
+"item create"|"item edit")
+	cat > ` + itemPath + `
+	;;
+esac
+`
+	if err := os.WriteFile(cliPath, []byte(script), 0700); err != nil {
+		t.Fatal(err)
+	}
+	return cliPath, itemPath, argsPath
+}
+
+func TestOpSessionStore(t *testing.T) {
+	ctx := context.Background()
+	cliPath, itemPath, argsPath := writeFakeOpItemCLI(t)
+	now := time.Date(2026, 10, 15, 9, 0, 0, 0, time.UTC)
+	store := &opSessionStore{
+		op:  &opCLICredentialSource{cliPath: cliPath, OpAwsItem: OpAwsItem{Vault: "Shared", Item: "AWS sessions"}},
+		now: func() time.Time { return now },
+	}
+	session := func(key string, expires time.Time) []byte {
+		data, err := json.Marshal(cachedEntry{Credentials: newStsCreds(key, "SECRET-"+key, "TOKEN", expires)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+
+	if _, err := store.load(ctx, "dev-0123456789abcdef.json"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("load() error = %v, want os.ErrNotExist for a missing item", err)
+	}
+	if err := store.save(ctx, "dev-0123456789abcdef.json", session("OLD", now.Add(time.Hour))); err != nil {
+		t.Fatalf("save() error = %v", err)
+	}
+	if err := store.save(ctx, "prod-0123456789abcdef.json", session("PROD", now.Add(time.Hour))); err != nil {
+		t.Fatalf("save() error = %v", err)
+	}
+	if err := store.save(ctx, "dev-0123456789abcdef.json", session("DEV", now.Add(time.Hour))); err != nil {
+		t.Fatalf("save() error = %v", err)
+	}
+	data, err := store.load(ctx, "dev-0123456789abcdef.json")
+	if err != nil {
+		t.Fatalf("load() error = %v", err)
+	}
+	if !strings.Contains(string(data), "DEV") {
+		t.Errorf("load() = %s, want the latest session", data)
+	}
+
+	// Sessions expired for longer than the grace period are dropped on the
+	// next save; the rest of the item is left alone.
+	item, err := os.ReadFile(itemPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	item = []byte(strings.Replace(string(item), `"title"`, `"notesPlain":"Sessions of op-aws-credential-process","title"`, 1))
+	if err := os.WriteFile(itemPath, item, 0600); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(26 * time.Hour)
+	if err := store.save(ctx, "other-0123456789abcdef.json", session("OTHER", now.Add(time.Hour))); err != nil {
+		t.Fatalf("save() error = %v", err)
+	}
+	for name, want := range map[string]bool{"dev-0123456789abcdef.json": false, "prod-0123456789abcdef.json": false, "other-0123456789abcdef.json": true} {
+		if _, err := store.load(ctx, name); (err == nil) != want {
+			t.Errorf("load(%s) error = %v, want kept = %v", name, err, want)
+		}
+	}
+	if item, err := os.ReadFile(itemPath); err != nil || !strings.Contains(string(item), "notesPlain") {
+		t.Errorf("item = %s, want the notes kept", item)
+	}
+
+	sessions, err := store.list(ctx)
+	if err != nil {
+		t.Fatalf("list() error = %v", err)
+	}
+	if len(sessions) != 1 || sessions[0].name != "other-0123456789abcdef.json" {
+		t.Errorf("list() = %+v, want only other", sessions)
+	}
+	if err := store.delete(ctx, "other-0123456789abcdef.json"); err != nil {
+		t.Fatalf("delete() error = %v", err)
+	}
+	if _, err := store.load(ctx, "other-0123456789abcdef.json"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("load() error = %v, want os.ErrNotExist after delete()", err)
+	}
+
+	args, err := os.ReadFile(argsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(args), "SECRET") || strings.Contains(string(args), "--template") {
+		t.Errorf("op was run with a secret or a template file in its arguments:\n%s", args)
+	}
+}
+
+func TestCachedSessionProvider_SyncStore(t *testing.T) {
+	ctx := context.Background()
+	shared := &fileCacheStore{dir: t.TempDir()}
+	machine := func(inner *fakeStsSessionProvider) *CachedSessionProvider {
+		return &CachedSessionProvider{
+			SessionProvider: inner,
+			CacheDir:        t.TempDir(),
+			Profile:         "dev",
+			ExpiryWindow:    5 * time.Minute,
+			OpAwsItem:       defaultOpAwsItem(),
+			Cipher:          &keyringCipher{keyring: newFakeKeyring(), account: cacheKeyAccount},
+			SyncStore:       shared,
+		}
+	}
+
+	laptop := &fakeStsSessionProvider{creds: newStsCreds("LAPTOP", "SECRET", "TOKEN", time.Now().Add(time.Hour))}
+	if _, err := machine(laptop).RetrieveStsCredentials(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The desktop has its own keyring, and still reads the session.
+	desktop := &fakeStsSessionProvider{creds: newStsCreds("DESKTOP", "SECRET", "TOKEN", time.Now().Add(time.Hour))}
+	provider := machine(desktop)
+	creds, err := provider.RetrieveStsCredentials(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := aws.ToString(creds.AccessKeyId); got != "LAPTOP" || desktop.called != 0 {
+		t.Errorf("AccessKeyId = %q with %d mints, want the laptop's session", got, desktop.called)
+	}
+	if _, err := os.Stat(provider.cachePath()); err != nil {
+		t.Errorf("shared session not cached locally: %v", err)
+	}
+}
@@ -1,32 +1,563 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unicode"
+
+	"github.com/aws/smithy-go"
+	"golang.org/x/term"
 )
 
+// OTPSource supplies the MFA code for the device serial.
 type OTPSource interface {
-	OTP(ctx context.Context) (string, error)
+	OTP(ctx context.Context, serial string) (string, error)
 }
 
-type ttyOTPSource struct{}
+// mfaPrompt tells which profile and MFA device the code is for, since users
+// with several profiles easily enter the wrong device's code.
+func mfaPrompt(profile, serial string) string {
+	switch {
+	case profile != "" && serial != "":
+		return fmt.Sprintf("MFA code for profile %s (%s)", profile, serial)
+	case profile != "":
+		return "MFA code for profile " + profile
+	case serial != "":
+		return fmt.Sprintf("MFA code for %s", serial)
+	}
+	return "Enter MFA code"
+}
+
+// mfaLabel identifies what an MFA code is for in prompts.
+type mfaLabel struct {
+	profile string
+	vault   string
+	item    string
+	// template replaces the default prompt, see --prompt-template.
+	template string
+}
 
-func (s *ttyOTPSource) OTP(ctx context.Context) (string, error) {
-	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+// prompt returns the prompt for the device serial. The template can refer
+// to {profile}, {mfa_serial}, {vault}, and {item}.
+func (l mfaLabel) prompt(serial string) string {
+	if l.template == "" {
+		return mfaPrompt(l.profile, serial)
+	}
+	return strings.NewReplacer(
+		"{profile}", l.profile,
+		"{mfa_serial}", serial,
+		"{vault}", l.vault,
+		"{item}", l.item,
+	).Replace(l.template)
+}
+
+// invalidOTPNotifier is implemented by OTP sources that can tell the user why
+// they are prompted again.
+type invalidOTPNotifier interface {
+	NotifyInvalidOTP(ctx context.Context, err error)
+}
+
+// ttyOTPSource prompts on the terminal, /dev/tty or the Windows console, since
+// stdout carries the credential JSON.
+// Without a controlling terminal, e.g. in some IDEs or make pipelines, it
+// reads from stdin and prompts on stderr instead.
+type ttyOTPSource struct {
+	// label is shown in the prompt.
+	label  mfaLabel
+	stdin  io.Reader
+	stderr io.Writer
+	// dialog asks for the code when there is neither a terminal nor a
+	// piped stdin, e.g. when a GUI app runs the SDK.
+	dialog OTPSource
+	// noTerminal keeps the terminal closed, for --non-interactive.
+	noTerminal bool
+	// terminal opens the terminal; nil means openTerminal.
+	terminal func() (io.Reader, io.Writer, func(), error)
+}
+
+// open returns the terminal to prompt on, falling back to stdin and stderr.
+// ok reports whether a terminal was found.
+func (s *ttyOTPSource) open() (r io.Reader, w io.Writer, closeFn func(), ok bool) {
+	if !s.noTerminal {
+		terminal := s.terminal
+		if terminal == nil {
+			terminal = openTerminal
+		}
+		r, w, closeFn, err := terminal()
+		if err == nil {
+			debugLog.Print("prompting on the terminal")
+			return r, w, closeFn, true
+		}
+		debugLog.Printf("terminal is unavailable, prompting on stdin and stderr: %v", err)
+	}
+
+	r, w = s.stdio()
+	return r, w, func() {}, false
+}
+
+func (s *ttyOTPSource) stdio() (io.Reader, io.Writer) {
+	var r io.Reader = os.Stdin
+	if s.stdin != nil {
+		r = s.stdin
+	}
+	var w io.Writer = os.Stderr
+	if s.stderr != nil {
+		w = s.stderr
+	}
+	return r, w
+}
+
+// ask writes prompt and reads the answer with read. When the terminal opened
+// but cannot be used, as in some IDE-embedded terminals and sandboxes, the
+// question is asked again on stdin and stderr.
+func (s *ttyOTPSource) ask(r io.Reader, w io.Writer, onTerminal bool, prompt string, read func(io.Reader, io.Writer) error) error {
+	_, err := fmt.Fprint(w, prompt)
+	if err == nil {
+		err = read(r, w)
+	}
+	if !onTerminal || !isTerminalIOError(err) {
+		return err
+	}
+
+	debugLog.Printf("terminal is not usable, prompting on stdin and stderr: %v", err)
+	r, w = s.stdio()
+	if _, err := fmt.Fprint(w, prompt); err != nil {
+		return err
+	}
+	return read(r, w)
+}
+
+// isTerminalIOError reports whether err comes from the terminal device itself,
+// e.g. EIO, rather than from what was typed.
+func isTerminalIOError(err error) bool {
+	var pathErr *fs.PathError
+	var errno syscall.Errno
+	return errors.As(err, &pathErr) || errors.As(err, &errno)
+}
+
+func (s *ttyOTPSource) OTP(ctx context.Context, serial string) (string, error) {
+	r, w, closeFn, ok := s.open()
+	defer closeFn()
+
+	if !ok && s.dialog != nil && s.stdin == nil && !stdinIsPipe() {
+		debugLog.Print("prompting with a dialog")
+		return s.dialog.OTP(ctx, serial)
+	}
+
+	var code string
+	err := s.ask(r, w, ok, s.label.prompt(serial)+": ", func(r io.Reader, w io.Writer) (err error) {
+		code, err = readCode(ctx, r, w)
+		return err
+	})
+	return code, err
+}
+
+// readCode reads a code from r. On a terminal, the code is read key by key
+// without echo, so it does not linger in scrollback or recordings, and is
+// submitted as soon as six digits are typed or pasted.
+func readCode(ctx context.Context, r io.Reader, w io.Writer) (string, error) {
+	if f, ok := r.(*os.File); ok && term.IsTerminal(int(f.Fd())) {
+		restore, err := makeRaw(ctx, f)
+		if err != nil {
+			return "", err
+		}
+		code, err := readDigits(r)
+		restore()
+		// The newline typed by the user is not echoed either.
+		_, _ = fmt.Fprint(w, "\r\n")
+		return code, err
+	}
+
+	// Read byte by byte so that input after the line is left for the next
+	// prompt.
+	var line []byte
+	buf := make([]byte, 1)
+	for {
+		n, err := r.Read(buf)
+		if n == 1 {
+			if buf[0] == '\n' {
+				break
+			}
+			line = append(line, buf[0])
+		}
+		if err != nil {
+			if errors.Is(err, io.EOF) && len(line) > 0 {
+				break
+			}
+			return "", err
+		}
+	}
+	return normalizeOTP(string(line)), nil
+}
+
+// readDigits reads keys from a terminal in raw mode. Separators in pasted
+// codes such as "123 456" or "123-456" are skipped, and the code is submitted
+// at six digits or on Enter.
+func readDigits(r io.Reader) (string, error) {
+	var code []byte
+	buf := make([]byte, 1)
+	for {
+		if _, err := r.Read(buf); err != nil {
+			return "", err
+		}
+		switch b := buf[0]; {
+		case b == 3: // Ctrl-C
+			return "", errPromptAborted
+		case b == '\r' || b == '\n':
+			return string(code), nil
+		case b == 127 || b == 8: // Backspace
+			if len(code) > 0 {
+				code = code[:len(code)-1]
+			}
+		case b >= '0' && b <= '9':
+			code = append(code, b)
+			if len(code) == 6 {
+				return string(code), nil
+			}
+		}
+	}
+}
+
+// normalizeOTP strips whitespace and dashes, which authenticator apps use to
+// group the digits of codes as in "123 456".
+func normalizeOTP(code string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) || r == '-' {
+			return -1
+		}
+		return r
+	}, code)
+}
+
+func (s *ttyOTPSource) NotifyInvalidOTP(ctx context.Context, err error) {
+	_, w, closeFn, _ := s.open()
+	defer closeFn()
+	if errors.Is(err, errMalformedOTP) {
+		_, _ = fmt.Fprintf(w, "%v, try again.\n", err)
+		return
+	}
+	_, _ = fmt.Fprintln(w, "Invalid MFA code, try again.")
+}
+
+// errPromptTimeout is returned when nobody answers the MFA prompt in time,
+// e.g. when a cron job or a background SDK refresh hits it.
+var errPromptTimeout = errors.New("timed out waiting for the MFA code")
+
+// makeRaw puts the terminal f in raw mode, and returns a func that restores
+// it. Should the prompt be abandoned with the read pending, the terminal is
+// restored then.
+func makeRaw(ctx context.Context, f *os.File) (restore func(), err error) {
+	state, err := term.MakeRaw(int(f.Fd()))
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	restore = sync.OnceFunc(func() {
+		_ = term.Restore(int(f.Fd()), state)
+	})
+	if hooks, ok := ctx.Value(abandonHooksKey{}).(*abandonHooks); ok {
+		hooks.add(restore)
 	}
-	defer func() {
-		_ = tty.Close()
+	return restore, nil
+}
+
+type abandonHooksKey struct{}
+
+// abandonHooks are run by timeoutOTPSource before it gives up on a prompt,
+// so the prompt leaves the terminal as it found it.
+type abandonHooks struct {
+	mu    sync.Mutex
+	hooks []func()
+}
+
+func (h *abandonHooks) add(hook func()) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.hooks = append(h.hooks, hook)
+}
+
+func (h *abandonHooks) run() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, hook := range h.hooks {
+		hook()
+	}
+}
+
+// timeoutOTPSource fails with errPromptTimeout when source does not return a
+// code in time. Reads from a terminal cannot be interrupted, so the pending
+// read is abandoned rather than cancelled, once the terminal is restored.
+type timeoutOTPSource struct {
+	source  OTPSource
+	timeout time.Duration
+}
+
+func (s *timeoutOTPSource) OTP(ctx context.Context, serial string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	hooks := &abandonHooks{}
+	ctx = context.WithValue(ctx, abandonHooksKey{}, hooks)
+
+	type result struct {
+		code string
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		code, err := s.source.OTP(ctx, serial)
+		done <- result{code, err}
 	}()
 
-	if _, err := fmt.Fprint(tty, "Enter MFA code: "); err != nil {
-		return "", err
+	select {
+	case r := <-done:
+		return r.code, r.err
+	case <-ctx.Done():
+		hooks.run()
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return "", fmt.Errorf("%w after %s", errPromptTimeout, s.timeout)
+		}
+		return "", ctx.Err()
 	}
-	var code string
-	if _, err := fmt.Fscanln(tty, &code); err != nil {
-		return "", err
+}
+
+func (s *timeoutOTPSource) NotifyInvalidOTP(ctx context.Context, err error) {
+	if n, ok := s.source.(invalidOTPNotifier); ok {
+		n.NotifyInvalidOTP(ctx, err)
+	}
+}
+
+// nextWindowOTPSource waits for the next TOTP window after STS rejects a
+// code, since generators such as op or a YubiKey return the rejected code
+// again within the same window.
+type nextWindowOTPSource struct {
+	source   OTPSource
+	stderr   io.Writer
+	now      func() time.Time
+	rejected bool
+}
+
+func (s *nextWindowOTPSource) OTP(ctx context.Context, serial string) (string, error) {
+	if s.rejected {
+		s.rejected = false
+		now := time.Now
+		if s.now != nil {
+			now = s.now
+		}
+		wait := totpPeriod - time.Duration(now().UnixNano())%totpPeriod
+		w := s.stderr
+		if w == nil {
+			w = os.Stderr
+		}
+		_, _ = fmt.Fprintf(w, "Waiting %s for the next MFA code.\n", wait.Round(time.Second))
+
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+	return s.source.OTP(ctx, serial)
+}
+
+func (s *nextWindowOTPSource) NotifyInvalidOTP(ctx context.Context, err error) {
+	// A malformed code was never sent, so the window is still unused.
+	if isInvalidOTP(err) {
+		s.rejected = true
+	}
+	if n, ok := s.source.(invalidOTPNotifier); ok {
+		n.NotifyInvalidOTP(ctx, err)
+	}
+}
+
+// errInteractionRequired is returned in non-interactive mode when the MFA
+// code could only be obtained by asking someone.
+var errInteractionRequired = errors.New("an MFA code is required but prompting is disabled in non-interactive mode")
+
+// nonInteractiveOTPSource stands in for prompts in non-interactive mode and
+// fails without opening the terminal.
+type nonInteractiveOTPSource struct{}
+
+func (nonInteractiveOTPSource) OTP(ctx context.Context, serial string) (string, error) {
+	return "", errInteractionRequired
+}
+
+// chainOTPSource tries each source in order until one returns a code.
+type chainOTPSource struct {
+	sources []OTPSource
+}
+
+func (s *chainOTPSource) OTP(ctx context.Context, serial string) (string, error) {
+	var errs []error
+	for _, source := range s.sources {
+		code, err := source.OTP(ctx, serial)
+		if err == nil {
+			return code, nil
+		}
+		if ctx.Err() != nil {
+			return "", err
+		}
+		errs = append(errs, err)
+	}
+	return "", fmt.Errorf("no OTP source returned a code: %w", errors.Join(errs...))
+}
+
+func (s *chainOTPSource) NotifyInvalidOTP(ctx context.Context, err error) {
+	for _, source := range s.sources {
+		if n, ok := source.(invalidOTPNotifier); ok {
+			n.NotifyInvalidOTP(ctx, err)
+			return
+		}
+	}
+}
+
+// mfaCodeEnv holds an MFA code obtained by a wrapper script, which skips the
+// prompt entirely.
+const mfaCodeEnv = "OP_AWS_MFA_CODE"
+
+// envOTPSource reads the MFA code from an environment variable.
+type envOTPSource struct {
+	name string
+}
+
+func (s *envOTPSource) OTP(ctx context.Context, serial string) (string, error) {
+	code := strings.TrimSpace(os.Getenv(s.name))
+	if code == "" {
+		return "", fmt.Errorf("%s is not set", s.name)
+	}
+	return code, nil
+}
+
+// tokenCodeOTPSource returns the code passed with --token-code, or read from
+// stdin when the code is "-". A code cannot be asked for again, so it is
+// returned only once.
+type tokenCodeOTPSource struct {
+	code  string
+	stdin io.Reader
+	used  bool
+}
+
+func (s *tokenCodeOTPSource) OTP(ctx context.Context, serial string) (string, error) {
+	if s.used {
+		return "", errors.New("the MFA code passed with --token-code was rejected")
+	}
+	s.used = true
+	if s.code != "-" {
+		return strings.TrimSpace(s.code), nil
+	}
+
+	r := s.stdin
+	if r == nil {
+		r = os.Stdin
+	}
+	// The whole line is read, so codes grouped as in "123 456" are taken.
+	line, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil && (!errors.Is(err, io.EOF) || line == "") {
+		return "", fmt.Errorf("failed to read the MFA code from stdin: %w", err)
+	}
+	code := normalizeOTP(line)
+	if err := validateOTP(code); err != nil {
+		return "", fmt.Errorf("invalid MFA code on stdin: %w", err)
+	}
+	return code, nil
+}
+
+// commandOTPSource runs a shell command, e.g. `ykman oath accounts code -s
+// aws`, and uses its trimmed stdout as the MFA code.
+type commandOTPSource struct {
+	command string
+	timeout time.Duration
+}
+
+func (s *commandOTPSource) OTP(ctx context.Context, serial string) (string, error) {
+	if s.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.timeout)
+		defer cancel()
+	}
+
+	cmd := shellCommand(ctx, s.command)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	// Do not wait for grandchildren holding stdout once the shell is killed.
+	cmd.WaitDelay = time.Second
+	out, err := cmd.Output()
+	if ctx.Err() == context.DeadlineExceeded {
+		return "", fmt.Errorf("otp command timed out after %s", s.timeout)
+	}
+	if err != nil {
+		return "", fmt.Errorf("otp command failed: %w\n%s", err, stderr.Bytes())
+	}
+
+	code := strings.TrimSpace(string(out))
+	if code == "" {
+		return "", errors.New("otp command printed no code")
 	}
 	return code, nil
 }
+
+// isInvalidOTP reports whether STS rejected the MFA token code itself, e.g. a
+// typo or a code that expired in flight, as opposed to a wrong serial.
+func isInvalidOTP(err error) bool {
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	msg := apiErr.ErrorMessage()
+	switch apiErr.ErrorCode() {
+	case "AccessDenied":
+		return strings.Contains(msg, "invalid MFA one time pass code")
+	case "ValidationError":
+		return strings.Contains(msg, "tokenCode")
+	}
+	return false
+}
+
+// errMalformedOTP is returned for codes that STS would reject anyway.
+var errMalformedOTP = errors.New("MFA code must be 6 digits")
+
+// validateOTP rejects obviously invalid codes locally, so a typo costs no STS
+// round trip and yields a clear message instead of a cryptic AWS error.
+func validateOTP(code string) error {
+	if len(code) != 6 {
+		return errMalformedOTP
+	}
+	for _, r := range code {
+		if r < '0' || r > '9' {
+			return errMalformedOTP
+		}
+	}
+	return nil
+}
+
+// withOTP calls fn with a token code from source, prompting for a fresh code
+// while the code is malformed or STS rejects it, up to attempts times in
+// total.
+func withOTP(ctx context.Context, source OTPSource, serial string, attempts int, fn func(code string) error) error {
+	for attempt := 1; ; attempt++ {
+		code, err := source.OTP(ctx, serial)
+		if err != nil {
+			return err
+		}
+		code = normalizeOTP(code)
+		if err = validateOTP(code); err == nil {
+			err = fn(code)
+		}
+		if err == nil || !(isInvalidOTP(err) || errors.Is(err, errMalformedOTP)) || attempt >= attempts {
+			return err
+		}
+		if n, ok := source.(invalidOTPNotifier); ok {
+			n.NotifyInvalidOTP(ctx, err)
+		}
+	}
+}
@@ -0,0 +1,489 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/aws/smithy-go"
+)
+
+var errInvalidOTP = &smithy.GenericAPIError{
+	Code:    "AccessDenied",
+	Message: "MultiFactorAuthentication failed with invalid MFA one time pass code.",
+}
+
+func TestIsInvalidOTP(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "invalid code", err: errInvalidOTP, want: true},
+		{name: "malformed code", err: &smithy.GenericAPIError{Code: "ValidationError", Message: "1 validation error detected: Value '12' at 'tokenCode' failed to satisfy constraint"}, want: true},
+		{name: "wrong serial", err: &smithy.GenericAPIError{Code: "AccessDenied", Message: "MultiFactorAuthentication failed, unable to validate MFA code."}, want: false},
+		{name: "not an API error", err: errors.New("boom"), want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isInvalidOTP(tt.err); got != tt.want {
+				t.Errorf("isInvalidOTP() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithOTP(t *testing.T) {
+	tests := []struct {
+		name       string
+		attempts   int
+		errs       []error
+		wantErr    bool
+		wantCalled int
+	}{
+		{name: "accepted first", attempts: 3, errs: []error{nil}, wantCalled: 1},
+		{name: "accepted after invalid code", attempts: 3, errs: []error{errInvalidOTP, nil}, wantCalled: 2},
+		{name: "gives up after attempts", attempts: 2, errs: []error{errInvalidOTP, errInvalidOTP, nil}, wantErr: true, wantCalled: 2},
+		{name: "other errors are not retried", attempts: 3, errs: []error{errors.New("boom"), nil}, wantErr: true, wantCalled: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			otp := &fakeOTPSource{otp: "123456"}
+			calls := 0
+			err := withOTP(context.Background(), otp, "arn:aws:iam::123456789012:mfa/alice", tt.attempts, func(code string) error {
+				err := tt.errs[calls]
+				calls++
+				return err
+			})
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("withOTP() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if otp.called != tt.wantCalled {
+				t.Errorf("OTP called = %d, want %d", otp.called, tt.wantCalled)
+			}
+		})
+	}
+}
+
+func TestCommandOTPSource(t *testing.T) {
+	tests := []struct {
+		name    string
+		command string
+		want    string
+		wantErr string
+	}{
+		{name: "code", command: "echo ' 123456 '", want: "123456"},
+		{name: "failure surfaces stderr", command: "echo 'no YubiKey found' >&2; exit 1", wantErr: "no YubiKey found"},
+		{name: "empty output", command: "true", wantErr: "no code"},
+		{name: "timeout", command: "sleep 5", wantErr: "timed out"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := &commandOTPSource{command: tt.command, timeout: 100 * time.Millisecond}
+
+			got, err := source.OTP(context.Background(), "arn:aws:iam::123456789012:mfa/alice")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("OTP() error = %v, want containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("OTP() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("OTP() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEnvOTPSource(t *testing.T) {
+	t.Setenv(mfaCodeEnv, " 123456\n")
+
+	got, err := (&envOTPSource{name: mfaCodeEnv}).OTP(context.Background(), "arn:aws:iam::123456789012:mfa/alice")
+	if err != nil {
+		t.Fatalf("OTP() error = %v", err)
+	}
+	if got != "123456" {
+		t.Errorf("OTP() = %q, want %q", got, "123456")
+	}
+}
+
+func TestEnvOTPSource_Unset(t *testing.T) {
+	t.Setenv(mfaCodeEnv, "")
+
+	if _, err := (&envOTPSource{name: mfaCodeEnv}).OTP(context.Background(), "arn:aws:iam::123456789012:mfa/alice"); err == nil {
+		t.Fatal("OTP() error = nil, want error")
+	}
+}
+
+func TestTTYOTPSource_StdinFallback(t *testing.T) {
+	if f, err := os.OpenFile("/dev/tty", os.O_RDWR, 0); err == nil {
+		_ = f.Close()
+		t.Skip("a controlling terminal is available")
+	}
+
+	var stderr bytes.Buffer
+	source := &ttyOTPSource{label: mfaLabel{profile: "prod"}, stdin: strings.NewReader("123456\n"), stderr: &stderr}
+
+	got, err := source.OTP(context.Background(), "arn:aws:iam::123456789012:mfa/alice")
+	if err != nil {
+		t.Fatalf("OTP() error = %v", err)
+	}
+	if got != "123456" {
+		t.Errorf("OTP() = %q, want %q", got, "123456")
+	}
+	if want := "MFA code for profile prod (arn:aws:iam::123456789012:mfa/alice): "; stderr.String() != want {
+		t.Errorf("stderr = %q, want %q", stderr.String(), want)
+	}
+}
+
+func TestMfaPrompt(t *testing.T) {
+	tests := []struct {
+		profile string
+		serial  string
+		want    string
+	}{
+		{profile: "prod", serial: "arn:aws:iam::123456789012:mfa/alice", want: "MFA code for profile prod (arn:aws:iam::123456789012:mfa/alice)"},
+		{profile: "prod", want: "MFA code for profile prod"},
+		{serial: "GAHT12345678", want: "MFA code for GAHT12345678"},
+		{want: "Enter MFA code"},
+	}
+
+	for _, tt := range tests {
+		if got := mfaPrompt(tt.profile, tt.serial); got != tt.want {
+			t.Errorf("mfaPrompt(%q, %q) = %q, want %q", tt.profile, tt.serial, got, tt.want)
+		}
+	}
+}
+
+func TestMfaLabel_Prompt(t *testing.T) {
+	label := mfaLabel{profile: "prod", vault: "Work", item: "aws", template: "{profile} の MFA コード ({item} in {vault}, {mfa_serial})"}
+
+	got := label.prompt("arn:aws:iam::123456789012:mfa/alice")
+	if want := "prod の MFA コード (aws in Work, arn:aws:iam::123456789012:mfa/alice)"; got != want {
+		t.Errorf("prompt() = %q, want %q", got, want)
+	}
+	if got, want := (mfaLabel{profile: "prod"}).prompt(""), "MFA code for profile prod"; got != want {
+		t.Errorf("prompt() without template = %q, want %q", got, want)
+	}
+}
+
+type blockingOTPSource struct{}
+
+func (s *blockingOTPSource) OTP(ctx context.Context, serial string) (string, error) {
+	<-ctx.Done()
+	return "", ctx.Err()
+}
+
+func TestTimeoutOTPSource(t *testing.T) {
+	source := &timeoutOTPSource{source: &blockingOTPSource{}, timeout: 10 * time.Millisecond}
+
+	_, err := source.OTP(context.Background(), "arn:aws:iam::123456789012:mfa/alice")
+	if !errors.Is(err, errPromptTimeout) {
+		t.Fatalf("OTP() error = %v, want %v", err, errPromptTimeout)
+	}
+}
+
+// rawOTPSource stands in for a prompt that puts the terminal in raw mode
+// and is then left waiting for a key.
+type rawOTPSource struct {
+	restored chan struct{}
+}
+
+func (s *rawOTPSource) OTP(ctx context.Context, serial string) (string, error) {
+	if hooks, ok := ctx.Value(abandonHooksKey{}).(*abandonHooks); ok {
+		hooks.add(sync.OnceFunc(func() { close(s.restored) }))
+	}
+	select {}
+}
+
+func TestTimeoutOTPSource_RestoresTerminal(t *testing.T) {
+	prompt := &rawOTPSource{restored: make(chan struct{})}
+	source := &timeoutOTPSource{source: prompt, timeout: 10 * time.Millisecond}
+
+	if _, err := source.OTP(context.Background(), ""); !errors.Is(err, errPromptTimeout) {
+		t.Fatalf("OTP() error = %v, want %v", err, errPromptTimeout)
+	}
+	select {
+	case <-prompt.restored:
+	default:
+		t.Error("the terminal was not restored before OTP() returned")
+	}
+}
+
+func TestTimeoutOTPSource_Answered(t *testing.T) {
+	source := &timeoutOTPSource{source: &fakeOTPSource{otp: "123456"}, timeout: time.Second}
+
+	got, err := source.OTP(context.Background(), "arn:aws:iam::123456789012:mfa/alice")
+	if err != nil {
+		t.Fatalf("OTP() error = %v", err)
+	}
+	if got != "123456" {
+		t.Errorf("OTP() = %q, want %q", got, "123456")
+	}
+}
+
+func TestChainOTPSource(t *testing.T) {
+	failing := &fakeOTPSource{err: errors.New("no YubiKey detected")}
+	working := &fakeOTPSource{otp: "123456"}
+	unused := &fakeOTPSource{otp: "654321"}
+	source := &chainOTPSource{sources: []OTPSource{failing, working, unused}}
+
+	got, err := source.OTP(context.Background(), "arn:aws:iam::123456789012:mfa/alice")
+	if err != nil {
+		t.Fatalf("OTP() error = %v", err)
+	}
+	if got != "123456" {
+		t.Errorf("OTP() = %q, want %q", got, "123456")
+	}
+	if failing.called != 1 || working.called != 1 || unused.called != 0 {
+		t.Errorf("called = %d, %d, %d, want 1, 1, 0", failing.called, working.called, unused.called)
+	}
+}
+
+func TestChainOTPSource_AllFail(t *testing.T) {
+	errNoKey := errors.New("no YubiKey detected")
+	source := &chainOTPSource{sources: []OTPSource{
+		&fakeOTPSource{err: errNoKey},
+		&fakeOTPSource{err: errors.New("no terminal")},
+	}}
+
+	_, err := source.OTP(context.Background(), "arn:aws:iam::123456789012:mfa/alice")
+	if !errors.Is(err, errNoKey) {
+		t.Fatalf("OTP() error = %v, want wrapping %v", err, errNoKey)
+	}
+}
+
+func TestValidateOTP(t *testing.T) {
+	tests := []struct {
+		code    string
+		wantErr bool
+	}{
+		{code: "123456"},
+		{code: "012345"},
+		{code: "12345", wantErr: true},
+		{code: "1234567", wantErr: true},
+		{code: "12a456", wantErr: true},
+		{code: "123 56", wantErr: true},
+		{code: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		if err := validateOTP(tt.code); (err != nil) != tt.wantErr {
+			t.Errorf("validateOTP(%q) error = %v, wantErr %v", tt.code, err, tt.wantErr)
+		}
+	}
+}
+
+type sequenceOTPSource struct {
+	codes  []string
+	called int
+}
+
+func (s *sequenceOTPSource) OTP(ctx context.Context, serial string) (string, error) {
+	code := s.codes[s.called]
+	s.called++
+	return code, nil
+}
+
+func TestWithOTP_MalformedCode(t *testing.T) {
+	otp := &sequenceOTPSource{codes: []string{"12345", "123456"}}
+	var sent []string
+	err := withOTP(context.Background(), otp, "arn:aws:iam::123456789012:mfa/alice", 3, func(code string) error {
+		sent = append(sent, code)
+		return nil
+	})
+
+	if err != nil {
+		t.Fatalf("withOTP() error = %v", err)
+	}
+	if otp.called != 2 {
+		t.Errorf("OTP called = %d, want 2", otp.called)
+	}
+	if len(sent) != 1 || sent[0] != "123456" {
+		t.Errorf("codes sent to STS = %v, want [123456]", sent)
+	}
+}
+
+func TestWithOTP_MalformedCodeGivesUp(t *testing.T) {
+	otp := &sequenceOTPSource{codes: []string{"abc", "abc"}}
+	err := withOTP(context.Background(), otp, "arn:aws:iam::123456789012:mfa/alice", 2, func(code string) error {
+		t.Fatal("STS should not be called with a malformed code")
+		return nil
+	})
+
+	if !errors.Is(err, errMalformedOTP) {
+		t.Fatalf("withOTP() error = %v, want %v", err, errMalformedOTP)
+	}
+}
+
+func TestNonInteractiveOTPSource(t *testing.T) {
+	source := &chainOTPSource{sources: []OTPSource{
+		&fakeOTPSource{err: errors.New("OP_AWS_MFA_CODE is not set")},
+		nonInteractiveOTPSource{},
+	}}
+	err := withOTP(context.Background(), source, "arn:aws:iam::123456789012:mfa/alice", 3, func(code string) error {
+		t.Fatal("STS should not be called without a code")
+		return nil
+	})
+
+	if !errors.Is(err, errInteractionRequired) {
+		t.Fatalf("withOTP() error = %v, want %v", err, errInteractionRequired)
+	}
+}
+
+func TestNextWindowOTPSource(t *testing.T) {
+	otp := &sequenceOTPSource{codes: []string{"123456", "654321"}}
+	var stderr bytes.Buffer
+	source := &nextWindowOTPSource{
+		source: otp,
+		stderr: &stderr,
+		// 5ms before a window boundary, as 1_700_000_010 is a multiple of 30.
+		now: func() time.Time { return time.Unix(1_700_000_010, 0).Add(-5 * time.Millisecond) },
+	}
+	var sent []string
+	err := withOTP(context.Background(), source, "arn:aws:iam::123456789012:mfa/alice", 3, func(code string) error {
+		sent = append(sent, code)
+		if len(sent) == 1 {
+			return errInvalidOTP
+		}
+		return nil
+	})
+
+	if err != nil {
+		t.Fatalf("withOTP() error = %v", err)
+	}
+	if len(sent) != 2 || sent[1] != "654321" {
+		t.Errorf("codes sent to STS = %v, want [123456 654321]", sent)
+	}
+	if !strings.Contains(stderr.String(), "Waiting") {
+		t.Errorf("stderr = %q, want a waiting message", stderr.String())
+	}
+}
+
+type failingReader struct {
+	err error
+}
+
+func (r failingReader) Read(p []byte) (int, error) {
+	return 0, r.err
+}
+
+func TestTTYOTPSource_UnusableTerminal(t *testing.T) {
+	var terminal, stderr bytes.Buffer
+	source := &ttyOTPSource{
+		label:  mfaLabel{profile: "prod"},
+		stdin:  strings.NewReader("123456\n"),
+		stderr: &stderr,
+		terminal: func() (io.Reader, io.Writer, func(), error) {
+			return failingReader{&fs.PathError{Op: "read", Path: "/dev/tty", Err: syscall.EIO}}, &terminal, func() {}, nil
+		},
+	}
+
+	got, err := source.OTP(context.Background(), "arn:aws:iam::123456789012:mfa/alice")
+	if err != nil {
+		t.Fatalf("OTP() error = %v", err)
+	}
+	if got != "123456" {
+		t.Errorf("OTP() = %q, want %q", got, "123456")
+	}
+	if !strings.Contains(stderr.String(), "MFA code for profile prod") {
+		t.Errorf("stderr = %q, want the prompt", stderr.String())
+	}
+}
+
+func TestTokenCodeOTPSource(t *testing.T) {
+	tests := []struct {
+		name  string
+		code  string
+		stdin string
+		want  string
+	}{
+		{name: "flag", code: "123456", want: "123456"},
+		{name: "stdin", code: "-", stdin: "654321\n", want: "654321"},
+		{name: "grouped on stdin", code: "-", stdin: "654 321\r\n", want: "654321"},
+		{name: "stdin without newline", code: "-", stdin: "654321", want: "654321"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := &tokenCodeOTPSource{code: tt.code, stdin: strings.NewReader(tt.stdin)}
+
+			got, err := source.OTP(context.Background(), "arn:aws:iam::123456789012:mfa/alice")
+			if err != nil {
+				t.Fatalf("OTP() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("OTP() = %q, want %q", got, tt.want)
+			}
+			if _, err := source.OTP(context.Background(), "arn:aws:iam::123456789012:mfa/alice"); err == nil {
+				t.Error("second OTP() error = nil, want error")
+			}
+		})
+	}
+}
+
+func TestTokenCodeOTPSource_MalformedStdin(t *testing.T) {
+	for _, stdin := range []string{"", "\n", "12345\n", "123 45a\n"} {
+		source := &tokenCodeOTPSource{code: "-", stdin: strings.NewReader(stdin)}
+		if _, err := source.OTP(context.Background(), "arn:aws:iam::123456789012:mfa/alice"); err == nil {
+			t.Errorf("OTP() with stdin %q error = nil, want error", stdin)
+		}
+	}
+}
+
+func TestReadDigits(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    string
+		wantErr error
+	}{
+		{name: "auto-submit at six digits", input: "1234567", want: "123456"},
+		{name: "grouped paste", input: "123 456", want: "123456"},
+		{name: "dashed paste", input: "123-456", want: "123456"},
+		{name: "enter", input: "1234\r", want: "1234"},
+		{name: "backspace", input: "12\x7f3456\r", want: "13456"},
+		{name: "ctrl-c", input: "12\x03", wantErr: errPromptAborted},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readDigits(strings.NewReader(tt.input))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("readDigits() error = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("readDigits() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadCode_Line(t *testing.T) {
+	r := strings.NewReader(" 123 456 \n654-321\n")
+
+	for _, want := range []string{"123456", "654321"} {
+		got, err := readCode(context.Background(), r, io.Discard)
+		if err != nil {
+			t.Fatalf("readCode() error = %v", err)
+		}
+		if got != want {
+			t.Errorf("readCode() = %q, want %q", got, want)
+		}
+	}
+}
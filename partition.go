@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+)
+
+// partitionRegions maps each supported partition to the region used when
+// only the partition is known.
+var partitionRegions = map[string]string{
+	"aws":        "us-east-1",
+	"aws-us-gov": "us-gov-west-1",
+	"aws-cn":     "cn-north-1",
+}
+
+func partitionForRegion(region string) string {
+	switch {
+	case strings.HasPrefix(region, "us-gov-"):
+		return "aws-us-gov"
+	case strings.HasPrefix(region, "cn-"):
+		return "aws-cn"
+	default:
+		return "aws"
+	}
+}
+
+// resolvePartition reconciles an explicit partition with the region. When
+// the region is empty, the partition's default region is returned.
+func resolvePartition(partition, region string) (string, string, error) {
+	if partition == "" {
+		return partitionForRegion(region), region, nil
+	}
+	defaultRegion, ok := partitionRegions[partition]
+	if !ok {
+		return "", "", fmt.Errorf("unknown partition %q", partition)
+	}
+	if region == "" {
+		return partition, defaultRegion, nil
+	}
+	if p := partitionForRegion(region); p != partition {
+		return "", "", fmt.Errorf("region %s belongs to partition %s, not %s", region, p, partition)
+	}
+	return partition, region, nil
+}
+
+// validateARNPartition reports ARNs from another partition, which STS
+// otherwise rejects with a confusing signature error. Values that are not
+// ARNs, such as hardware MFA serial numbers, are accepted.
+func validateARNPartition(name, value, partition string) error {
+	if !arn.IsARN(value) {
+		return nil
+	}
+	a, err := arn.Parse(value)
+	if err != nil {
+		return fmt.Errorf("invalid %s %q: %w", name, value, err)
+	}
+	if a.Partition != partition {
+		return fmt.Errorf("%s %s is in partition %s, but the region is in partition %s", name, value, a.Partition, partition)
+	}
+	return nil
+}
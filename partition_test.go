@@ -0,0 +1,60 @@
+package main
+
+import "testing"
+
+func TestResolvePartition(t *testing.T) {
+	tests := []struct {
+		name          string
+		partition     string
+		region        string
+		wantPartition string
+		wantRegion    string
+		wantErr       bool
+	}{
+		{name: "commercial region", region: "ap-northeast-1", wantPartition: "aws", wantRegion: "ap-northeast-1"},
+		{name: "govcloud region", region: "us-gov-west-1", wantPartition: "aws-us-gov", wantRegion: "us-gov-west-1"},
+		{name: "china region", region: "cn-northwest-1", wantPartition: "aws-cn", wantRegion: "cn-northwest-1"},
+		{name: "partition without region", partition: "aws-cn", wantPartition: "aws-cn", wantRegion: "cn-north-1"},
+		{name: "matching partition", partition: "aws-us-gov", region: "us-gov-east-1", wantPartition: "aws-us-gov", wantRegion: "us-gov-east-1"},
+		{name: "mismatched partition", partition: "aws-cn", region: "us-east-1", wantErr: true},
+		{name: "unknown partition", partition: "aws-iso", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			partition, region, err := resolvePartition(tt.partition, tt.region)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if partition != tt.wantPartition || region != tt.wantRegion {
+				t.Errorf("resolvePartition(%q, %q) = (%q, %q), want (%q, %q)", tt.partition, tt.region, partition, region, tt.wantPartition, tt.wantRegion)
+			}
+		})
+	}
+}
+
+func TestValidateARNPartition(t *testing.T) {
+	tests := []struct {
+		name      string
+		value     string
+		partition string
+		wantErr   bool
+	}{
+		{name: "same partition", value: "arn:aws-us-gov:iam::123456789012:mfa/user", partition: "aws-us-gov"},
+		{name: "different partition", value: "arn:aws:iam::123456789012:mfa/user", partition: "aws-us-gov", wantErr: true},
+		{name: "hardware serial", value: "GAHT12345678", partition: "aws-cn"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateARNPartition("mfa_serial", tt.value, tt.partition)
+			if tt.wantErr != (err != nil) {
+				t.Errorf("validateARNPartition(%q, %q) error = %v, wantErr %v", tt.value, tt.partition, err, tt.wantErr)
+			}
+		})
+	}
+}
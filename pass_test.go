@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeFakePassCLI writes a shell script that prints entry, standing in for
+// pass show.
+func writeFakePassCLI(t *testing.T, entry string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "pass")
+	script := "#!/bin/sh\n[ \"$1\" = show ] || exit 1\ncat <<'EOF'\n" + entry + "\nEOF\n"
+	if err := os.WriteFile(path, []byte(script), 0700); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestPassCredentialSource(t *testing.T) {
+	tests := []struct {
+		name       string
+		entry      string
+		secretKey  string
+		wantKey    string
+		wantSecret string
+	}{
+		{
+			name:       "fields",
+			entry:      "ignored\nAccess key ID: AKIA\nSecret access key: secret",
+			secretKey:  "Secret access key",
+			wantKey:    "AKIA",
+			wantSecret: "secret",
+		},
+		{
+			name:       "password line",
+			entry:      "secret\nAccess key ID: AKIA\nurl: https://console.aws.amazon.com",
+			secretKey:  "password",
+			wantKey:    "AKIA",
+			wantSecret: "secret",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := &passCredentialSource{
+				cliPath: writeFakePassCLI(t, tt.entry),
+				OpAwsItem: OpAwsItem{
+					Item:                 "aws/iam-user",
+					AccessKeyIDField:     "Access key ID",
+					SecretAccessKeyField: tt.secretKey,
+				},
+			}
+
+			creds, err := source.Retrieve(context.Background())
+			if err != nil {
+				t.Fatalf("Retrieve() error = %v", err)
+			}
+			if creds.AccessKeyID != tt.wantKey || creds.SecretAccessKey != tt.wantSecret {
+				t.Errorf("Retrieve() = %q, %q, want %q, %q", creds.AccessKeyID, creds.SecretAccessKey, tt.wantKey, tt.wantSecret)
+			}
+		})
+	}
+}
+
+func TestPassCredentialSource_TOTP(t *testing.T) {
+	tests := []struct {
+		name  string
+		entry string
+	}{
+		{name: "pass-otp", entry: "secret\notpauth://totp/aws?secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"},
+		{name: "gopass", entry: "secret\ntotp: GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := &passCredentialSource{
+				cliPath:   writeFakePassCLI(t, tt.entry),
+				OpAwsItem: OpAwsItem{Item: "aws/iam-user"},
+				now:       func() time.Time { return time.Unix(59, 0) },
+			}
+
+			got, err := source.totp(context.Background(), "")
+			if err != nil {
+				t.Fatalf("totp() error = %v", err)
+			}
+			if got != "287082" {
+				t.Errorf("totp() = %q, want %q", got, "287082")
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// pinentryOTPSource delegates the prompt to a pinentry program, as gpg-agent
+// does, so curses and GUI pinentries set up for the desktop keyring are
+// reused. It speaks the subset of the Assuan protocol needed for GETPIN.
+type pinentryOTPSource struct {
+	cliPath string
+	label   mfaLabel
+}
+
+func (s *pinentryOTPSource) OTP(ctx context.Context, serial string) (string, error) {
+	cmd := exec.CommandContext(ctx, s.cliPath)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return "", err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return "", err
+	}
+	if err := cmd.Start(); err != nil {
+		return "", fmt.Errorf("failed to start pinentry: %w", err)
+	}
+	defer func() {
+		_ = stdin.Close()
+		_ = cmd.Wait()
+	}()
+
+	conn := &assuanConn{w: stdin, r: bufio.NewReader(stdout)}
+	if _, err := conn.response(); err != nil {
+		return "", err
+	}
+
+	commands := []string{
+		"SETTITLE " + assuanEscape(dialogTitle),
+		"SETDESC " + assuanEscape(s.label.prompt(serial)),
+		"SETPROMPT " + assuanEscape("MFA code:"),
+	}
+	// Curses pinentries draw on the terminal rather than on our pipes.
+	if tty := os.Getenv("GPG_TTY"); tty != "" {
+		commands = append(commands, "OPTION ttyname="+assuanEscape(tty))
+	} else if f, err := os.Open("/dev/tty"); err == nil {
+		_ = f.Close()
+		commands = append(commands, "OPTION ttyname=/dev/tty")
+	}
+	if t := os.Getenv("TERM"); t != "" {
+		commands = append(commands, "OPTION ttytype="+assuanEscape(t))
+	}
+	for _, c := range commands {
+		if _, err := conn.command(c); err != nil {
+			// Options unknown to the pinentry are not fatal.
+			if strings.HasPrefix(c, "OPTION ") {
+				continue
+			}
+			return "", err
+		}
+	}
+
+	pin, err := conn.command("GETPIN")
+	if err != nil {
+		return "", err
+	}
+	_, _ = conn.command("BYE")
+	return strings.TrimSpace(pin), nil
+}
+
+type assuanConn struct {
+	w io.Writer
+	r *bufio.Reader
+}
+
+func (c *assuanConn) command(line string) (string, error) {
+	if _, err := fmt.Fprintf(c.w, "%s\n", line); err != nil {
+		return "", err
+	}
+	return c.response()
+}
+
+// response reads lines until OK or ERR, returning the decoded data lines.
+func (c *assuanConn) response() (string, error) {
+	var data strings.Builder
+	for {
+		line, err := c.r.ReadString('\n')
+		if err != nil {
+			return "", fmt.Errorf("pinentry closed the connection: %w", err)
+		}
+		line = strings.TrimRight(line, "\r\n")
+		switch {
+		case line == "OK" || strings.HasPrefix(line, "OK "):
+			return data.String(), nil
+		case strings.HasPrefix(line, "ERR "):
+			// 83886179 is GPG_ERR_CANCELED from the pinentry source.
+			if strings.Contains(line, "83886179") {
+				return "", errors.New("pinentry was cancelled")
+			}
+			return "", fmt.Errorf("pinentry: %s", strings.TrimPrefix(line, "ERR "))
+		case strings.HasPrefix(line, "D "):
+			decoded, err := url.PathUnescape(strings.TrimPrefix(line, "D "))
+			if err != nil {
+				return "", err
+			}
+			data.WriteString(decoded)
+		}
+		// Status (S) and comment (#) lines are ignored.
+	}
+}
+
+// assuanEscape percent-escapes the characters Assuan does not allow in
+// arguments.
+func assuanEscape(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A").Replace(s)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeFakePinentry writes a pinentry that answers GETPIN with getpin and
+// logs the commands it receives to the returned log file.
+func writeFakePinentry(t *testing.T, getpin string) (string, string) {
+	t.Helper()
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "log")
+	script := `#!/bin/sh
+echo "OK Pleased to meet you"
+while read -r line; do
+  echo "$line" >> ` + logPath + `
+  case "$line" in
+    GETPIN) ` + getpin + ` ;;
+    BYE) echo OK; exit 0 ;;
+    OPTION*) echo "ERR 83886254 Unknown option" ;;
+    *) echo OK ;;
+  esac
+done
+`
+	path := filepath.Join(dir, "pinentry")
+	if err := os.WriteFile(path, []byte(script), 0700); err != nil {
+		t.Fatal(err)
+	}
+	return path, logPath
+}
+
+func TestPinentryOTPSource(t *testing.T) {
+	path, logPath := writeFakePinentry(t, `echo "# comment"; echo "D 123456"; echo OK`)
+	source := &pinentryOTPSource{cliPath: path, label: mfaLabel{profile: "prod"}}
+
+	got, err := source.OTP(context.Background(), "arn:aws:iam::123456789012:mfa/alice")
+	if err != nil {
+		t.Fatalf("OTP() error = %v", err)
+	}
+	if got != "123456" {
+		t.Errorf("OTP() = %q, want %q", got, "123456")
+	}
+
+	log, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "SETDESC MFA code for profile prod (arn:aws:iam::123456789012:mfa/alice)"; !strings.Contains(string(log), want) {
+		t.Errorf("commands = %q, want containing %q", log, want)
+	}
+}
+
+func TestPinentryOTPSource_Cancelled(t *testing.T) {
+	path, _ := writeFakePinentry(t, `echo "ERR 83886179 Operation cancelled <Pinentry>"`)
+	source := &pinentryOTPSource{cliPath: path}
+
+	_, err := source.OTP(context.Background(), "arn:aws:iam::123456789012:mfa/alice")
+	if err == nil || !strings.Contains(err.Error(), "cancelled") {
+		t.Fatalf("OTP() error = %v, want cancelled", err)
+	}
+}
+
+func TestAssuanEscape(t *testing.T) {
+	if got, want := assuanEscape("100%\nsure"), "100%25%0Asure"; got != want {
+		t.Errorf("assuanEscape() = %q, want %q", got, want)
+	}
+}
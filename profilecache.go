@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+)
+
+// cachedProfile is what the helper uses of a resolved profile, down its
+// source_profile chain. Keys the config may hold are left out, so the cache
+// never holds a secret.
+type cachedProfile struct {
+	Profile         string         `json:"profile"`
+	Region          string         `json:"region,omitempty"`
+	RoleARN         string         `json:"role_arn,omitempty"`
+	ExternalID      string         `json:"external_id,omitempty"`
+	RoleSessionName string         `json:"role_session_name,omitempty"`
+	MFASerial       string         `json:"mfa_serial,omitempty"`
+	Duration        *time.Duration `json:"duration,omitempty"`
+	UseFIPSEndpoint bool           `json:"use_fips_endpoint,omitempty"`
+	Source          *cachedProfile `json:"source,omitempty"`
+}
+
+func newCachedProfile(cfg *config.SharedConfig) *cachedProfile {
+	if cfg == nil {
+		return nil
+	}
+	return &cachedProfile{
+		Profile:         cfg.Profile,
+		Region:          cfg.Region,
+		RoleARN:         cfg.RoleARN,
+		ExternalID:      cfg.ExternalID,
+		RoleSessionName: cfg.RoleSessionName,
+		MFASerial:       cfg.MFASerial,
+		Duration:        cfg.RoleDurationSeconds,
+		UseFIPSEndpoint: cfg.UseFIPSEndpoint == aws.FIPSEndpointStateEnabled,
+		Source:          newCachedProfile(cfg.Source),
+	}
+}
+
+func (p *cachedProfile) sharedConfig() *config.SharedConfig {
+	if p == nil {
+		return nil
+	}
+	cfg := &config.SharedConfig{
+		Profile:             p.Profile,
+		Region:              p.Region,
+		RoleARN:             p.RoleARN,
+		ExternalID:          p.ExternalID,
+		RoleSessionName:     p.RoleSessionName,
+		MFASerial:           p.MFASerial,
+		RoleDurationSeconds: p.Duration,
+		Source:              p.Source.sharedConfig(),
+	}
+	if p.UseFIPSEndpoint {
+		cfg.UseFIPSEndpoint = aws.FIPSEndpointStateEnabled
+	}
+	return cfg
+}
+
+// configFileStamp identifies a version of a shared config file. A file that
+// does not exist has a zero ModTime.
+type configFileStamp struct {
+	Path    string    `json:"path"`
+	ModTime time.Time `json:"mod_time"`
+	Size    int64     `json:"size"`
+}
+
+// profileCacheEntry is a resolved profile and the versions of the shared
+// config files it was resolved from.
+type profileCacheEntry struct {
+	Files   []configFileStamp `json:"files"`
+	Profile *cachedProfile    `json:"profile"`
+}
+
+// sharedConfigFiles returns the shared config and credentials files, at the
+// locations sharedConfigValue reads too.
+func sharedConfigFiles() (configFile, credentialsFile string, err error) {
+	if configFile, err = sharedConfigPath(); err != nil {
+		return "", "", err
+	}
+	if credentialsFile, err = sharedCredentialsPath(); err != nil {
+		return "", "", err
+	}
+	return configFile, credentialsFile, nil
+}
+
+// sharedConfigStamps returns the versions of paths.
+func sharedConfigStamps(paths ...string) []configFileStamp {
+	var stamps []configFileStamp
+	for _, path := range paths {
+		stamp := configFileStamp{Path: path}
+		if info, err := os.Stat(path); err == nil {
+			stamp.ModTime = info.ModTime().UTC()
+			stamp.Size = info.Size()
+		}
+		stamps = append(stamps, stamp)
+	}
+	return stamps
+}
+
+// profileCachePath returns where the resolved profile is cached in dir.
+func profileCachePath(dir, profile string) string {
+	sum := sha1.Sum([]byte(profile))
+	return filepath.Join(dir, "profiles", hex.EncodeToString(sum[:])+".json")
+}
+
+// loadSharedConfigProfile loads profile like config.LoadSharedConfigProfile,
+// but keeps the result in dir until either shared config file changes, so a
+// large config file is not parsed on every invocation.
+func loadSharedConfigProfile(ctx context.Context, dir, profile string) (config.SharedConfig, error) {
+	configFile, credentialsFile, err := sharedConfigFiles()
+	if err != nil {
+		return config.SharedConfig{}, err
+	}
+	path := profileCachePath(dir, profile)
+	stamps := sharedConfigStamps(configFile, credentialsFile)
+	if data, err := os.ReadFile(path); err == nil {
+		var entry profileCacheEntry
+		if err := json.Unmarshal(data, &entry); err != nil {
+			debugLog.Printf("ignoring the cached profile: %v", err)
+		} else if entry.Profile != nil && slices.Equal(entry.Files, stamps) {
+			debugLog.Printf("read profile %s from the cache", profile)
+			return *entry.Profile.sharedConfig(), nil
+		}
+	}
+
+	cfg, err := config.LoadSharedConfigProfile(ctx, profile, func(o *config.LoadSharedConfigOptions) {
+		o.ConfigFiles = []string{configFile}
+		o.CredentialsFiles = []string{credentialsFile}
+	})
+	if err != nil {
+		return cfg, err
+	}
+	data, err := json.Marshal(profileCacheEntry{Files: stamps, Profile: newCachedProfile(&cfg)})
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(path), 0700)
+	}
+	if err == nil {
+		err = writeFileAtomic(path, data, 0600)
+	}
+	if err != nil {
+		debugLog.Printf("failed to cache the profile: %v", err)
+	}
+	return cfg, nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadSharedConfigProfile(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "config")
+	credentialsFile := filepath.Join(dir, "credentials")
+	t.Setenv("AWS_CONFIG_FILE", configFile)
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", credentialsFile)
+
+	writeConfig := func(region string, modTime time.Time) {
+		t.Helper()
+		data := `[profile base]
+region = ` + region + `
+mfa_serial = arn:aws:iam::123456789012:mfa/alice
+
+[profile admin]
+source_profile = base
+role_arn = arn:aws:iam::123456789012:role/Admin
+duration_seconds = 1800
+`
+		if err := os.WriteFile(configFile, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(configFile, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(credentialsFile, []byte("[base]\naws_access_key_id = AKIA\naws_secret_access_key = secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	modTime := time.Date(2026, 10, 15, 9, 0, 0, 0, time.UTC)
+	writeConfig("eu-west-1", modTime)
+
+	cacheDir := t.TempDir()
+	for range 2 {
+		cfg, err := loadSharedConfigProfile(ctx, cacheDir, "admin")
+		if err != nil {
+			t.Fatalf("loadSharedConfigProfile() error = %v", err)
+		}
+		if cfg.RoleARN != "arn:aws:iam::123456789012:role/Admin" || cfg.RoleDurationSeconds == nil || *cfg.RoleDurationSeconds != 30*time.Minute {
+			t.Errorf("loadSharedConfigProfile() = %+v, want the role profile", cfg)
+		}
+		if cfg.Source == nil || cfg.Source.Region != "eu-west-1" || chainMfaSerial(&cfg) != "arn:aws:iam::123456789012:mfa/alice" {
+			t.Errorf("Source = %+v, want the base profile", cfg.Source)
+		}
+	}
+	data, err := os.ReadFile(profileCachePath(cacheDir, "admin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "secret") {
+		t.Errorf("cached profile = %s, want no keys", data)
+	}
+
+	// The cached profile is served while the config is unchanged.
+	path := profileCachePath(cacheDir, "admin")
+	if err := os.WriteFile(path, []byte(strings.Replace(string(data), "eu-west-1", "ap-south-1", 1)), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if cfg, err := loadSharedConfigProfile(ctx, cacheDir, "admin"); err != nil || cfg.Source == nil || cfg.Source.Region != "ap-south-1" {
+		t.Errorf("loadSharedConfigProfile() = %+v, %v, want the cached profile", cfg.Source, err)
+	}
+
+	// Changing the config, even to a file of the same size, is noticed.
+	writeConfig("eu-west-2", modTime.Add(time.Second))
+	cfg, err := loadSharedConfigProfile(ctx, cacheDir, "admin")
+	if err != nil {
+		t.Fatalf("loadSharedConfigProfile() error = %v", err)
+	}
+	if cfg.Source == nil || cfg.Source.Region != "eu-west-2" {
+		t.Errorf("Source = %+v, want the changed region", cfg.Source)
+	}
+
+	// Errors are not cached.
+	if _, err := loadSharedConfigProfile(ctx, cacheDir, "missing"); err == nil {
+		t.Error("loadSharedConfigProfile() error = nil, want the missing profile reported")
+	}
+	if _, err := os.Stat(profileCachePath(cacheDir, "missing")); !os.IsNotExist(err) {
+		t.Errorf("missing profile cached: %v", err)
+	}
+}
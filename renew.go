@@ -0,0 +1,40 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+)
+
+// renewingEnv is set for the process renewing a session in the background,
+// which must neither prompt nor start renewals of its own.
+const renewingEnv = "OP_AWS_CREDENTIAL_PROCESS_RENEWING"
+
+func renewing() bool {
+	return os.Getenv(renewingEnv) != ""
+}
+
+// startRenewal runs this command again, detached, to mint a session in place
+// of the cached one being served. It mints only if the cached session is
+// still due when it takes the cache lock, so concurrent renewals mint once.
+func startRenewal() error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	cmd := renewalCommand(exe, os.Args[1:])
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	debugLog.Printf("renewing in the background as process %d", cmd.Process.Pid)
+	return cmd.Process.Release()
+}
+
+// renewalCommand returns the renewal of the command exe run with args. Its
+// output is discarded; the session reaches later invocations through the
+// cache.
+func renewalCommand(exe string, args []string) *exec.Cmd {
+	cmd := exec.Command(exe, args...)
+	cmd.Env = append(os.Environ(), renewingEnv+"=1")
+	detach(cmd)
+	return cmd
+}
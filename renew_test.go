@@ -0,0 +1,34 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestRenewalCommand(t *testing.T) {
+	cmd := renewalCommand("/usr/local/bin/op-aws-credential-process", []string{"--profile", "dev", "--approve"})
+	if want := []string{"/usr/local/bin/op-aws-credential-process", "--profile", "dev", "--approve"}; !slices.Equal(cmd.Args, want) {
+		t.Errorf("Args = %q, want %q", cmd.Args, want)
+	}
+	if !slices.Contains(cmd.Env, renewingEnv+"=1") {
+		t.Errorf("Env does not set %s", renewingEnv)
+	}
+	if cmd.Stdin != nil || cmd.Stdout != nil || cmd.Stderr != nil {
+		t.Error("renewal is attached to the standard streams, want them discarded")
+	}
+	if cmd.SysProcAttr == nil {
+		t.Error("SysProcAttr = nil, want the renewal detached")
+	}
+}
+
+func TestSessionFlags_NonInteractiveWhileRenewing(t *testing.T) {
+	t.Setenv("CI", "")
+	f := &SessionFlags{}
+	if f.nonInteractive() {
+		t.Fatal("nonInteractive() = true, want false")
+	}
+	t.Setenv(renewingEnv, "1")
+	if !f.nonInteractive() {
+		t.Error("nonInteractive() = false while renewing, want true")
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+const throttlingResponse = `<ErrorResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <Error><Type>Sender</Type><Code>Throttling</Code><Message>Rate exceeded</Message></Error>
+  <RequestId>request-id</RequestId>
+</ErrorResponse>`
+
+const getSessionTokenResponse = `<GetSessionTokenResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <GetSessionTokenResult>
+    <Credentials>
+      <AccessKeyId>ASIATEST</AccessKeyId>
+      <SecretAccessKey>secret</SecretAccessKey>
+      <SessionToken>token</SessionToken>
+      <Expiration>2030-01-01T00:00:00Z</Expiration>
+    </Credentials>
+  </GetSessionTokenResult>
+  <ResponseMetadata><RequestId>request-id</RequestId></ResponseMetadata>
+</GetSessionTokenResponse>`
+
+func newThrottlingServer(t *testing.T, throttled int) (*httptest.Server, *int) {
+	t.Helper()
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Content-Type", "text/xml")
+		if calls <= throttled {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(throttlingResponse))
+			return
+		}
+		w.Write([]byte(getSessionTokenResponse))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &calls
+}
+
+func newRetryingSTSClient(endpoint string, maxAttempts int) *sts.Client {
+	return sts.New(sts.Options{
+		Region:       "us-east-1",
+		Credentials:  aws.AnonymousCredentials{},
+		BaseEndpoint: aws.String(endpoint),
+		Retryer:      newRetryer(maxAttempts, time.Millisecond),
+	})
+}
+
+func TestNewRetryer_RetriesThrottling(t *testing.T) {
+	srv, calls := newThrottlingServer(t, 2)
+	client := newRetryingSTSClient(srv.URL, 3)
+
+	out, err := client.GetSessionToken(context.Background(), &sts.GetSessionTokenInput{})
+	if err != nil {
+		t.Fatalf("GetSessionToken() error = %v", err)
+	}
+	if got := *out.Credentials.AccessKeyId; got != "ASIATEST" {
+		t.Errorf("AccessKeyId = %q, want %q", got, "ASIATEST")
+	}
+	if *calls != 3 {
+		t.Errorf("calls = %d, want 3", *calls)
+	}
+}
+
+func TestNewRetryer_GivesUpAfterMaxAttempts(t *testing.T) {
+	srv, calls := newThrottlingServer(t, 5)
+	client := newRetryingSTSClient(srv.URL, 2)
+
+	_, err := client.GetSessionToken(context.Background(), &sts.GetSessionTokenInput{})
+	var maxErr *retry.MaxAttemptsError
+	if !errors.As(err, &maxErr) {
+		t.Fatalf("GetSessionToken() error = %v, want MaxAttemptsError", err)
+	}
+	if *calls != 2 {
+		t.Errorf("calls = %d, want 2", *calls)
+	}
+}
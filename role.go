@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	ststypes "github.com/aws/aws-sdk-go-v2/service/sts/types"
+)
+
+type AssumeRoleAPIClient interface {
+	AssumeRole(ctx context.Context, params *sts.AssumeRoleInput, optFns ...func(*sts.Options)) (*sts.AssumeRoleOutput, error)
+}
+
+type SessionTagSource interface {
+	SessionTags(ctx context.Context) (map[string]string, error)
+}
+
+// RoleSource supplies the role ARN and external ID when a session is minted.
+// An empty value leaves the one on the provider in place.
+type RoleSource interface {
+	Role(ctx context.Context) (roleArn, externalID string, err error)
+}
+
+type AssumeRoleProvider struct {
+	BaseCredsProvider aws.CredentialsProvider
+	OTPSource         OTPSource
+	StsClient         AssumeRoleAPIClient
+	RoleArn           string
+	RoleSessionName   string
+	ExternalID        string
+	MfaSerial         string
+	MfaSerialSource   MfaSerialSource
+	// OTPAttempts is how many token codes are tried before giving up.
+	OTPAttempts       int
+	Duration          time.Duration
+	Tags              map[string]string
+	TagSource         SessionTagSource
+	RoleSource        RoleSource
+	TransitiveTagKeys []string
+	Policy            string
+	PolicyArns        []string
+	Now               func() time.Time
+}
+
+func (p *AssumeRoleProvider) now() time.Time {
+	if p.Now == nil {
+		return time.Now()
+	}
+	return p.Now()
+}
+
+var invalidRoleSessionNameChars = regexp.MustCompile(`[^\w+=,.@-]`)
+
+// sanitizeRoleSessionName replaces characters STS rejects in a role session
+// name and truncates it to the 64 character limit.
+func sanitizeRoleSessionName(name string) string {
+	name = invalidRoleSessionNameChars.ReplaceAllString(name, "-")
+	if len(name) > 64 {
+		name = name[:64]
+	}
+	if len(name) < 2 {
+		return ""
+	}
+	return name
+}
+
+func (p *AssumeRoleProvider) sessionParams() sessionParams {
+	params := sessionParams{
+		RoleSessionName:   p.RoleSessionName,
+		ExternalID:        p.ExternalID,
+		Policy:            p.Policy,
+		PolicyArns:        p.PolicyArns,
+		Tags:              p.Tags,
+		TransitiveTagKeys: p.TransitiveTagKeys,
+	}
+	if source, ok := p.TagSource.(*opTagSource); ok {
+		params.TagFields = source.labels
+	}
+	if source, ok := p.RoleSource.(*opRoleSource); ok {
+		params.RoleFields = []string{source.roleArnLabel, source.externalIDLabel}
+	}
+	return params
+}
+
+func (p *AssumeRoleProvider) roleSessionName() string {
+	if p.RoleSessionName != "" {
+		return p.RoleSessionName
+	}
+	return fmt.Sprintf("op-aws-credential-process-%d", p.now().UnixNano())
+}
+
+// sessionTags merges tags from TagSource with Tags, the latter taking
+// precedence, sorted by key so requests are deterministic.
+func (p *AssumeRoleProvider) sessionTags(ctx context.Context) ([]ststypes.Tag, error) {
+	merged := make(map[string]string)
+	if p.TagSource != nil {
+		tags, err := p.TagSource.SessionTags(ctx)
+		if err != nil {
+			return nil, err
+		}
+		maps.Copy(merged, tags)
+	}
+	maps.Copy(merged, p.Tags)
+
+	var tags []ststypes.Tag
+	for _, key := range slices.Sorted(maps.Keys(merged)) {
+		tags = append(tags, ststypes.Tag{Key: aws.String(key), Value: aws.String(merged[key])})
+	}
+	return tags, nil
+}
+
+func (p *AssumeRoleProvider) RetrieveStsCredentials(ctx context.Context) (*ststypes.Credentials, error) {
+	if _, err := p.BaseCredsProvider.Retrieve(ctx); err != nil {
+		return nil, err
+	}
+
+	roleArn, externalID := p.RoleArn, p.ExternalID
+	if p.RoleSource != nil {
+		arn, id, err := p.RoleSource.Role(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if arn != "" {
+			roleArn = arn
+		}
+		if id != "" {
+			externalID = id
+		}
+	}
+
+	input := &sts.AssumeRoleInput{
+		RoleArn:         aws.String(roleArn),
+		RoleSessionName: aws.String(p.roleSessionName()),
+		DurationSeconds: aws.Int32(int32(p.Duration.Seconds())),
+	}
+	if externalID != "" {
+		input.ExternalId = aws.String(externalID)
+	}
+	tags, err := p.sessionTags(ctx)
+	if err != nil {
+		return nil, err
+	}
+	input.Tags = tags
+	input.TransitiveTagKeys = p.TransitiveTagKeys
+	if p.Policy != "" {
+		input.Policy = aws.String(p.Policy)
+	}
+	for _, arn := range p.PolicyArns {
+		input.PolicyArns = append(input.PolicyArns, ststypes.PolicyDescriptorType{Arn: aws.String(arn)})
+	}
+	// MFA is not configured when assuming a role from an MFA-authenticated
+	// session, e.g. the next hop in a source_profile chain, or when the IAM
+	// user has no MFA device.
+	serial, err := resolveMfaSerial(ctx, p.MfaSerial, p.MfaSerialSource)
+	if err != nil {
+		return nil, err
+	}
+
+	var out *sts.AssumeRoleOutput
+	if serial == "" {
+		out, err = p.StsClient.AssumeRole(ctx, input)
+	} else {
+		input.SerialNumber = aws.String(serial)
+		err = withOTP(ctx, p.OTPSource, serial, p.OTPAttempts, func(code string) error {
+			input.TokenCode = aws.String(code)
+			out, err = p.StsClient.AssumeRole(ctx, input)
+			return err
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+	if out == nil || out.Credentials == nil {
+		return nil, errors.New("sts credentials were empty")
+	}
+
+	return out.Credentials, nil
+}
+
+func (p *AssumeRoleProvider) Retrieve(ctx context.Context) (aws.Credentials, error) {
+	creds, err := p.RetrieveStsCredentials(ctx)
+	if err != nil {
+		return aws.Credentials{}, err
+	}
+
+	return toAwsCredentials(creds), nil
+}
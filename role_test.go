@@ -0,0 +1,313 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+type fakeAssumeRoleClient struct {
+	output    *sts.AssumeRoleOutput
+	err       error
+	lastInput *sts.AssumeRoleInput
+}
+
+func (f *fakeAssumeRoleClient) AssumeRole(ctx context.Context, params *sts.AssumeRoleInput, optFns ...func(*sts.Options)) (*sts.AssumeRoleOutput, error) {
+	f.lastInput = params
+	return f.output, f.err
+}
+
+type fakeTagSource struct {
+	tags map[string]string
+	err  error
+}
+
+func (f *fakeTagSource) SessionTags(ctx context.Context) (map[string]string, error) {
+	return f.tags, f.err
+}
+
+type fakeRoleSource struct {
+	roleArn    string
+	externalID string
+	called     int
+}
+
+func (f *fakeRoleSource) Role(ctx context.Context) (string, string, error) {
+	f.called++
+	return f.roleArn, f.externalID, nil
+}
+
+func TestAssumeRoleProvider_Retrieve(t *testing.T) {
+	expiration := time.Now().Add(1 * time.Hour)
+	stsClient := &fakeAssumeRoleClient{
+		output: &sts.AssumeRoleOutput{Credentials: newStsCreds("ASIA", "SECRET", "TOKEN", expiration)},
+	}
+
+	provider := &AssumeRoleProvider{
+		BaseCredsProvider: &fakeCredsProvider{},
+		OTPSource:         &fakeOTPSource{otp: "123456"},
+		StsClient:         stsClient,
+		RoleArn:           "arn:aws:iam::222222222222:role/Admin",
+		RoleSessionName:   "session",
+		ExternalID:        "external-id",
+		MfaSerial:         "arn:aws:iam::123456789012:mfa/user",
+		Duration:          1 * time.Hour,
+	}
+
+	got, err := provider.Retrieve(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.AccessKeyID != "ASIA" {
+		t.Errorf("AccessKeyID = %q, want %q", got.AccessKeyID, "ASIA")
+	}
+	if !got.Expires.Equal(expiration) {
+		t.Errorf("Expires = %v, want %v", got.Expires, expiration)
+	}
+	if stsClient.lastInput == nil {
+		t.Fatal("AssumeRole was not called")
+	}
+	if got := aws.ToString(stsClient.lastInput.RoleArn); got != "arn:aws:iam::222222222222:role/Admin" {
+		t.Errorf("RoleArn = %q, want %q", got, "arn:aws:iam::222222222222:role/Admin")
+	}
+	if got := aws.ToString(stsClient.lastInput.RoleSessionName); got != "session" {
+		t.Errorf("RoleSessionName = %q, want %q", got, "session")
+	}
+	if got := aws.ToString(stsClient.lastInput.ExternalId); got != "external-id" {
+		t.Errorf("ExternalId = %q, want %q", got, "external-id")
+	}
+	if got := aws.ToString(stsClient.lastInput.SerialNumber); got != "arn:aws:iam::123456789012:mfa/user" {
+		t.Errorf("SerialNumber = %q, want %q", got, "arn:aws:iam::123456789012:mfa/user")
+	}
+	if got := aws.ToString(stsClient.lastInput.TokenCode); got != "123456" {
+		t.Errorf("TokenCode = %q, want %q", got, "123456")
+	}
+	if got := aws.ToInt32(stsClient.lastInput.DurationSeconds); got != 3600 {
+		t.Errorf("DurationSeconds = %d, want %d", got, 3600)
+	}
+}
+
+func TestAssumeRoleProvider_DefaultSessionName(t *testing.T) {
+	stsClient := &fakeAssumeRoleClient{
+		output: &sts.AssumeRoleOutput{Credentials: newStsCreds("ASIA", "SECRET", "TOKEN", time.Now().Add(1*time.Hour))},
+	}
+	provider := &AssumeRoleProvider{
+		BaseCredsProvider: &fakeCredsProvider{},
+		OTPSource:         &fakeOTPSource{otp: "123456"},
+		StsClient:         stsClient,
+		RoleArn:           "arn:aws:iam::222222222222:role/Admin",
+		MfaSerial:         "arn:aws:iam::123456789012:mfa/user",
+		Duration:          1 * time.Hour,
+		Now:               func() time.Time { return time.Unix(0, 42) },
+	}
+
+	if _, err := provider.RetrieveStsCredentials(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := aws.ToString(stsClient.lastInput.RoleSessionName); got != "op-aws-credential-process-42" {
+		t.Errorf("RoleSessionName = %q, want %q", got, "op-aws-credential-process-42")
+	}
+	if stsClient.lastInput.ExternalId != nil {
+		t.Errorf("ExternalId = %q, want nil", aws.ToString(stsClient.lastInput.ExternalId))
+	}
+}
+
+func TestAssumeRoleProvider_STSError(t *testing.T) {
+	provider := &AssumeRoleProvider{
+		BaseCredsProvider: &fakeCredsProvider{},
+		OTPSource:         &fakeOTPSource{otp: "123456"},
+		StsClient:         &fakeAssumeRoleClient{err: errors.New("STS call failed")},
+		RoleArn:           "arn:aws:iam::222222222222:role/Admin",
+		MfaSerial:         "arn:aws:iam::123456789012:mfa/user",
+		Duration:          1 * time.Hour,
+	}
+
+	_, err := provider.Retrieve(context.Background())
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+	if err.Error() != "STS call failed" {
+		t.Errorf("error = %q, want %q", err.Error(), "STS call failed")
+	}
+}
+
+func TestAssumeRoleProvider_WithoutMFA(t *testing.T) {
+	otpSource := &fakeOTPSource{otp: "123456"}
+	stsClient := &fakeAssumeRoleClient{
+		output: &sts.AssumeRoleOutput{Credentials: newStsCreds("ASIA", "SECRET", "TOKEN", time.Now().Add(1*time.Hour))},
+	}
+	provider := &AssumeRoleProvider{
+		BaseCredsProvider: &fakeCredsProvider{},
+		OTPSource:         otpSource,
+		StsClient:         stsClient,
+		RoleArn:           "arn:aws:iam::222222222222:role/Admin",
+		Duration:          1 * time.Hour,
+	}
+
+	if _, err := provider.RetrieveStsCredentials(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if otpSource.called != 0 {
+		t.Errorf("otpSource.called = %d, want 0", otpSource.called)
+	}
+	if stsClient.lastInput.SerialNumber != nil || stsClient.lastInput.TokenCode != nil {
+		t.Error("SerialNumber and TokenCode should not be set")
+	}
+}
+
+func TestAssumeRoleProvider_RoleSource(t *testing.T) {
+	stsClient := &fakeAssumeRoleClient{
+		output: &sts.AssumeRoleOutput{Credentials: newStsCreds("ASIA", "SECRET", "TOKEN", time.Now().Add(1*time.Hour))},
+	}
+	roleSource := &fakeRoleSource{roleArn: "arn:aws:iam::222222222222:role/Admin", externalID: "item-id"}
+	provider := &AssumeRoleProvider{
+		BaseCredsProvider: &fakeCredsProvider{},
+		StsClient:         stsClient,
+		ExternalID:        "flag-id",
+		RoleSource:        roleSource,
+		Duration:          1 * time.Hour,
+	}
+	cached := &CachedSessionProvider{
+		SessionProvider: provider,
+		CacheDir:        t.TempDir(),
+		Profile:         "customer-a",
+		SessionType:     opRoleSessionType,
+		ExpiryWindow:    5 * time.Minute,
+		OpAwsItem:       defaultOpAwsItem(),
+	}
+
+	for range 2 {
+		if _, err := cached.RetrieveStsCredentials(context.Background()); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if got := aws.ToString(stsClient.lastInput.RoleArn); got != "arn:aws:iam::222222222222:role/Admin" {
+		t.Errorf("RoleArn = %q, want the ARN from the role source", got)
+	}
+	if got := aws.ToString(stsClient.lastInput.ExternalId); got != "item-id" {
+		t.Errorf("ExternalId = %q, want %q", got, "item-id")
+	}
+	if roleSource.called != 1 {
+		t.Errorf("role source called %d times, want once, with the second session served from the cache", roleSource.called)
+	}
+
+	// Sessions are keyed on the labels the role is read from.
+	pathFor := func(label string) string {
+		return (&CachedSessionProvider{
+			SessionProvider: &AssumeRoleProvider{RoleSource: &opRoleSource{roleArnLabel: label}},
+			Profile:         "customer-a",
+			SessionType:     opRoleSessionType,
+		}).cachePath()
+	}
+	if pathFor("Role ARN") == pathFor("Other role ARN") {
+		t.Errorf("cachePath() = %q for both role ARN fields, want them apart", pathFor("Role ARN"))
+	}
+}
+
+func TestAssumeRoleProvider_SessionTags(t *testing.T) {
+	stsClient := &fakeAssumeRoleClient{
+		output: &sts.AssumeRoleOutput{Credentials: newStsCreds("ASIA", "SECRET", "TOKEN", time.Now().Add(1*time.Hour))},
+	}
+	provider := &AssumeRoleProvider{
+		BaseCredsProvider: &fakeCredsProvider{},
+		StsClient:         stsClient,
+		RoleArn:           "arn:aws:iam::222222222222:role/Admin",
+		Duration:          1 * time.Hour,
+		Tags:              map[string]string{"team": "platform", "env": "prod"},
+		TagSource:         &fakeTagSource{tags: map[string]string{"team": "from-item", "cost-center": "42"}},
+		TransitiveTagKeys: []string{"team"},
+	}
+
+	if _, err := provider.RetrieveStsCredentials(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := map[string]string{"cost-center": "42", "env": "prod", "team": "platform"}
+	wantOrder := []string{"cost-center", "env", "team"}
+	tags := stsClient.lastInput.Tags
+	if len(tags) != len(wantOrder) {
+		t.Fatalf("len(Tags) = %d, want %d", len(tags), len(wantOrder))
+	}
+	for i, tag := range tags {
+		key := aws.ToString(tag.Key)
+		if key != wantOrder[i] {
+			t.Errorf("Tags[%d].Key = %q, want %q", i, key, wantOrder[i])
+		}
+		if got := aws.ToString(tag.Value); got != want[key] {
+			t.Errorf("Tags[%q] = %q, want %q", key, got, want[key])
+		}
+	}
+	if len(stsClient.lastInput.TransitiveTagKeys) != 1 || stsClient.lastInput.TransitiveTagKeys[0] != "team" {
+		t.Errorf("TransitiveTagKeys = %v, want [team]", stsClient.lastInput.TransitiveTagKeys)
+	}
+}
+
+func TestAssumeRoleProvider_SessionPolicy(t *testing.T) {
+	stsClient := &fakeAssumeRoleClient{
+		output: &sts.AssumeRoleOutput{Credentials: newStsCreds("ASIA", "SECRET", "TOKEN", time.Now().Add(1*time.Hour))},
+	}
+	provider := &AssumeRoleProvider{
+		BaseCredsProvider: &fakeCredsProvider{},
+		StsClient:         stsClient,
+		RoleArn:           "arn:aws:iam::222222222222:role/Admin",
+		Duration:          1 * time.Hour,
+		Policy:            `{"Version":"2012-10-17","Statement":[]}`,
+		PolicyArns:        []string{"arn:aws:iam::aws:policy/ReadOnlyAccess"},
+	}
+
+	if _, err := provider.RetrieveStsCredentials(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := aws.ToString(stsClient.lastInput.Policy); got != provider.Policy {
+		t.Errorf("Policy = %q, want %q", got, provider.Policy)
+	}
+	if len(stsClient.lastInput.PolicyArns) != 1 || aws.ToString(stsClient.lastInput.PolicyArns[0].Arn) != "arn:aws:iam::aws:policy/ReadOnlyAccess" {
+		t.Errorf("PolicyArns = %v, want [arn:aws:iam::aws:policy/ReadOnlyAccess]", stsClient.lastInput.PolicyArns)
+	}
+}
+
+func TestAssumeRoleProvider_TagSourceError(t *testing.T) {
+	stsClient := &fakeAssumeRoleClient{}
+	provider := &AssumeRoleProvider{
+		BaseCredsProvider: &fakeCredsProvider{},
+		StsClient:         stsClient,
+		RoleArn:           "arn:aws:iam::222222222222:role/Admin",
+		Duration:          1 * time.Hour,
+		TagSource:         &fakeTagSource{err: errors.New("tag error")},
+	}
+
+	_, err := provider.RetrieveStsCredentials(context.Background())
+	if err == nil || err.Error() != "tag error" {
+		t.Errorf("error = %v, want %q", err, "tag error")
+	}
+	if stsClient.lastInput != nil {
+		t.Error("StsClient.AssumeRole should not have been called")
+	}
+}
+
+func TestSanitizeRoleSessionName(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{name: "valid", in: "alice@laptop.local", want: "alice@laptop.local"},
+		{name: "windows domain", in: `CORP\alice@desktop`, want: "CORP-alice@desktop"},
+		{name: "spaces", in: "alice smith@mac", want: "alice-smith@mac"},
+		{name: "too long", in: strings.Repeat("a", 70), want: strings.Repeat("a", 64)},
+		{name: "too short", in: "a", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sanitizeRoleSessionName(tt.in); got != tt.want {
+				t.Errorf("sanitizeRoleSessionName(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+var _ StsSessionProvider = (*AssumeRoleProvider)(nil)
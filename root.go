@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	ststypes "github.com/aws/aws-sdk-go-v2/service/sts/types"
+)
+
+type AssumeRootAPIClient interface {
+	AssumeRoot(ctx context.Context, params *sts.AssumeRootInput, optFns ...func(*sts.Options)) (*sts.AssumeRootOutput, error)
+}
+
+// AssumeRootProvider exchanges a management account session for short-lived
+// root credentials on a member account. The credentials are scoped down to
+// the task policy, which must be one of the AWS managed root-task policies.
+type AssumeRootProvider struct {
+	BaseCredsProvider aws.CredentialsProvider
+	StsClient         AssumeRootAPIClient
+	TargetPrincipal   string
+	TaskPolicyArn     string
+	Duration          time.Duration
+}
+
+func (p *AssumeRootProvider) RetrieveStsCredentials(ctx context.Context) (*ststypes.Credentials, error) {
+	if p.TaskPolicyArn == "" {
+		return nil, errors.New("assume root requires a task policy ARN")
+	}
+
+	if _, err := p.BaseCredsProvider.Retrieve(ctx); err != nil {
+		return nil, err
+	}
+
+	out, err := p.StsClient.AssumeRoot(ctx, &sts.AssumeRootInput{
+		TargetPrincipal: aws.String(p.TargetPrincipal),
+		TaskPolicyArn:   &ststypes.PolicyDescriptorType{Arn: aws.String(p.TaskPolicyArn)},
+		DurationSeconds: aws.Int32(int32(p.Duration.Seconds())),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if out == nil || out.Credentials == nil {
+		return nil, errors.New("sts credentials were empty")
+	}
+
+	return out.Credentials, nil
+}
+
+func (p *AssumeRootProvider) Retrieve(ctx context.Context) (aws.Credentials, error) {
+	creds, err := p.RetrieveStsCredentials(ctx)
+	if err != nil {
+		return aws.Credentials{}, err
+	}
+
+	return toAwsCredentials(creds), nil
+}
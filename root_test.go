@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+type fakeAssumeRootClient struct {
+	output    *sts.AssumeRootOutput
+	err       error
+	lastInput *sts.AssumeRootInput
+}
+
+func (f *fakeAssumeRootClient) AssumeRoot(ctx context.Context, params *sts.AssumeRootInput, optFns ...func(*sts.Options)) (*sts.AssumeRootOutput, error) {
+	f.lastInput = params
+	return f.output, f.err
+}
+
+func TestAssumeRootProvider_Retrieve(t *testing.T) {
+	stsClient := &fakeAssumeRootClient{
+		output: &sts.AssumeRootOutput{Credentials: newStsCreds("ASIA", "SECRET", "TOKEN", time.Now().Add(15*time.Minute))},
+	}
+	provider := &AssumeRootProvider{
+		BaseCredsProvider: &fakeCredsProvider{},
+		StsClient:         stsClient,
+		TargetPrincipal:   "222222222222",
+		TaskPolicyArn:     "arn:aws:iam::aws:policy/root-task/IAMAuditRootUserCredentials",
+		Duration:          15 * time.Minute,
+	}
+
+	got, err := provider.Retrieve(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.AccessKeyID != "ASIA" {
+		t.Errorf("AccessKeyID = %q, want %q", got.AccessKeyID, "ASIA")
+	}
+	if got := aws.ToString(stsClient.lastInput.TargetPrincipal); got != "222222222222" {
+		t.Errorf("TargetPrincipal = %q, want %q", got, "222222222222")
+	}
+	if got := aws.ToString(stsClient.lastInput.TaskPolicyArn.Arn); got != provider.TaskPolicyArn {
+		t.Errorf("TaskPolicyArn = %q, want %q", got, provider.TaskPolicyArn)
+	}
+	if got := aws.ToInt32(stsClient.lastInput.DurationSeconds); got != 900 {
+		t.Errorf("DurationSeconds = %d, want 900", got)
+	}
+}
+
+func TestAssumeRootProvider_TaskPolicyRequired(t *testing.T) {
+	baseCreds := &fakeCredsProvider{}
+	stsClient := &fakeAssumeRootClient{}
+	provider := &AssumeRootProvider{
+		BaseCredsProvider: baseCreds,
+		StsClient:         stsClient,
+		TargetPrincipal:   "222222222222",
+		Duration:          15 * time.Minute,
+	}
+
+	if _, err := provider.RetrieveStsCredentials(context.Background()); err == nil {
+		t.Fatal("expected error, got nil")
+	}
+	if baseCreds.called != 0 {
+		t.Errorf("baseCreds.called = %d, want 0", baseCreds.called)
+	}
+	if stsClient.lastInput != nil {
+		t.Error("StsClient.AssumeRoot should not have been called")
+	}
+}
+
+var _ StsSessionProvider = (*AssumeRootProvider)(nil)
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// secretServiceCredentialSource reads the key pair from the Secret Service,
+// as provided by GNOME Keyring or KWallet, with secret-tool from libsecret.
+// Item is the service attribute, and each field is the secret whose account
+// attribute is the field label, e.g. "Access key ID".
+type secretServiceCredentialSource struct {
+	cliPath string
+	OpAwsItem
+	now func() time.Time
+}
+
+// secret returns the secret with account label, and false when there is
+// none.
+func (s *secretServiceCredentialSource) secret(ctx context.Context, label string) (string, bool, error) {
+	out, err := exec.CommandContext(ctx, s.cliPath, "lookup", "service", s.Item, "account", label).Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			// secret-tool exits silently with 1 when nothing matches.
+			if exitErr.ExitCode() == 1 && len(exitErr.Stderr) == 0 {
+				return "", false, nil
+			}
+			return "", false, fmt.Errorf("failed to look up secret %s/%s: %w\n%s", s.Item, label, err, exitErr.Stderr)
+		}
+		return "", false, err
+	}
+	return strings.TrimSuffix(string(out), "\n"), true, nil
+}
+
+func (s *secretServiceCredentialSource) Retrieve(ctx context.Context) (aws.Credentials, error) {
+	return readCredentials(ctx, s)
+}
+
+func (s *secretServiceCredentialSource) awsItem() OpAwsItem {
+	return s.OpAwsItem
+}
+
+func (s *secretServiceCredentialSource) withItem(vault, item string) opItem {
+	other := *s
+	other.Item = item
+	return &other
+}
+
+// details returns nil since secret-tool looks secrets up one at a time.
+func (s *secretServiceCredentialSource) details(ctx context.Context) (*opItemDetails, error) {
+	return nil, nil
+}
+
+func (s *secretServiceCredentialSource) fields(ctx context.Context, labels ...string) (map[string]string, error) {
+	values := make(map[string]string, len(labels))
+	for _, label := range labels {
+		value, ok, err := s.secret(ctx, label)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			values[label] = value
+		}
+	}
+	return values, nil
+}
+
+// secretServiceOTPLabel is the account the MFA seed is stored under when no
+// label is given.
+const secretServiceOTPLabel = "totp"
+
+func (s *secretServiceCredentialSource) totp(ctx context.Context, label string) (string, error) {
+	seed, err := s.otpSeed(ctx, label)
+	if err != nil {
+		return "", err
+	}
+	now := s.now
+	if now == nil {
+		now = time.Now
+	}
+	return totpCode(seed, now())
+}
+
+func (s *secretServiceCredentialSource) otpSeed(ctx context.Context, label string) (string, error) {
+	if label == "" {
+		label = secretServiceOTPLabel
+	}
+	seed, ok, err := s.secret(ctx, label)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", fmt.Errorf("missing secret %s/%s", s.Item, label)
+	}
+	return seed, nil
+}
+
+func (s *secretServiceCredentialSource) read(ctx context.Context, ref string) (string, error) {
+	return "", fmt.Errorf("secret reference %s cannot be read from the Secret Service", ref)
+}
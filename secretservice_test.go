@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeFakeSecretTool writes a shell script standing in for secret-tool
+// that knows the accounts of the aws/iam-user service.
+func writeFakeSecretTool(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "secret-tool")
+	script := `#!/bin/sh
+[ "$1" = lookup ] || exit 2
+[ "$3" = aws/iam-user ] || { echo 'secret-tool: Cannot autolaunch D-Bus without X11 $DISPLAY' >&2; exit 1; }
+case "$5" in
+"Access key ID") printf AKIA ;;
+"Secret access key") printf secret ;;
+totp) printf GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ ;;
+*) exit 1 ;;
+esac
+`
+	if err := os.WriteFile(path, []byte(script), 0700); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestSecretServiceCredentialSource(t *testing.T) {
+	source := &secretServiceCredentialSource{
+		cliPath: writeFakeSecretTool(t),
+		OpAwsItem: OpAwsItem{
+			Item:                 "aws/iam-user",
+			AccessKeyIDField:     "Access key ID",
+			SecretAccessKeyField: "Secret access key",
+		},
+		now: func() time.Time { return time.Unix(59, 0) },
+	}
+	ctx := context.Background()
+
+	creds, err := source.Retrieve(ctx)
+	if err != nil {
+		t.Fatalf("Retrieve() error = %v", err)
+	}
+	if creds.AccessKeyID != "AKIA" || creds.SecretAccessKey != "secret" {
+		t.Errorf("Retrieve() = %q, %q, want %q, %q", creds.AccessKeyID, creds.SecretAccessKey, "AKIA", "secret")
+	}
+	if got, err := source.totp(ctx, ""); err != nil || got != "287082" {
+		t.Errorf("totp() = %q, %v, want %q", got, err, "287082")
+	}
+
+	values, err := source.fields(ctx, "missing")
+	if err != nil {
+		t.Fatalf("fields() error = %v", err)
+	}
+	if _, ok := values["missing"]; ok {
+		t.Errorf("fields() = %v, want the missing secret absent", values)
+	}
+	if _, err := source.withItem("", "other").fields(ctx, "Access key ID"); err == nil {
+		t.Error("fields() error = nil, want error from secret-tool")
+	}
+}
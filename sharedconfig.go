@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// sharedConfigPath returns the shared config file location, honoring
+// AWS_CONFIG_FILE like the SDK does.
+func sharedConfigPath() (string, error) {
+	if path := os.Getenv("AWS_CONFIG_FILE"); path != "" {
+		return path, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".aws", "config"), nil
+}
+
+// sharedCredentialsPath returns the shared credentials file location,
+// honoring AWS_SHARED_CREDENTIALS_FILE like the SDK does.
+func sharedCredentialsPath() (string, error) {
+	if path := os.Getenv("AWS_SHARED_CREDENTIALS_FILE"); path != "" {
+		return path, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".aws", "credentials"), nil
+}
+
+// sharedConfigValue reads key from the profile section of the shared config
+// file. It covers settings the SDK parses but does not expose, or does not
+// parse at all. A missing file or key yields an empty string.
+func sharedConfigValue(profile, key string) (string, error) {
+	path, err := sharedConfigPath()
+	if err != nil {
+		return "", err
+	}
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	sections := []string{"profile " + profile}
+	if profile == "default" {
+		sections = append(sections, "default")
+	}
+
+	var value string
+	inSection := false
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") || strings.HasPrefix(trimmed, ";") {
+			continue
+		}
+		if strings.HasPrefix(trimmed, "[") && strings.HasSuffix(trimmed, "]") {
+			name := strings.Join(strings.Fields(trimmed[1:len(trimmed)-1]), " ")
+			inSection = false
+			for _, s := range sections {
+				if name == s {
+					inSection = true
+				}
+			}
+			continue
+		}
+		// Indented lines belong to nested sub-properties such as s3 settings.
+		if !inSection || line[0] == ' ' || line[0] == '\t' {
+			continue
+		}
+		k, v, ok := strings.Cut(trimmed, "=")
+		if ok && strings.TrimSpace(k) == key {
+			// Later definitions win, matching the SDK.
+			value = strings.TrimSpace(v)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return value, nil
+}
+
+// setSharedConfigValue sets key in the profile section of the shared config
+// file, replacing its definitions or adding it at the end of the section,
+// which is created when missing. Other lines are kept as they are.
+func setSharedConfigValue(profile, key, value string) error {
+	path, err := sharedConfigPath()
+	if err != nil {
+		return err
+	}
+	mode := os.FileMode(0600)
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	section := "profile " + profile
+	if profile == "default" {
+		section = "default"
+	}
+	entry := key + " = " + value
+
+	var lines []string
+	if len(data) > 0 {
+		lines = strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	}
+	inSection, found, set := false, false, false
+	// end is the index after the last setting of the section.
+	end := -1
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "[") && strings.HasSuffix(trimmed, "]") {
+			name := strings.Join(strings.Fields(trimmed[1:len(trimmed)-1]), " ")
+			inSection = name == section || (profile == "default" && name == "profile default")
+			if inSection {
+				found, end = true, i+1
+			}
+			continue
+		}
+		if !inSection || trimmed == "" || strings.HasPrefix(trimmed, "#") || strings.HasPrefix(trimmed, ";") {
+			continue
+		}
+		end = i + 1
+		if line[0] == ' ' || line[0] == '\t' {
+			continue
+		}
+		if k, _, ok := strings.Cut(trimmed, "="); ok && strings.TrimSpace(k) == key {
+			lines[i], set = entry, true
+		}
+	}
+	switch {
+	case set:
+	case found:
+		lines = slices.Insert(lines, end, entry)
+	default:
+		if len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) != "" {
+			lines = append(lines, "")
+		}
+		lines = append(lines, "["+section+"]", entry)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return writeFileAtomic(path, []byte(strings.Join(lines, "\n")+"\n"), mode)
+}
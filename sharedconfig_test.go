@@ -0,0 +1,115 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeSharedConfig(t *testing.T, content string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+	t.Setenv("AWS_CONFIG_FILE", path)
+}
+
+func TestSharedConfigValue(t *testing.T) {
+	writeSharedConfig(t, `
+[default]
+sts_regional_endpoints = legacy
+
+[profile dev]
+region = ap-northeast-1
+# sts_regional_endpoints = legacy
+s3 =
+  sts_regional_endpoints = nested
+sts_regional_endpoints=regional
+
+[profile other]
+sts_regional_endpoints = legacy
+`)
+
+	tests := []struct {
+		profile string
+		key     string
+		want    string
+	}{
+		{profile: "default", key: "sts_regional_endpoints", want: "legacy"},
+		{profile: "dev", key: "sts_regional_endpoints", want: "regional"},
+		{profile: "dev", key: "region", want: "ap-northeast-1"},
+		{profile: "dev", key: "missing", want: ""},
+		{profile: "unknown", key: "sts_regional_endpoints", want: ""},
+	}
+	for _, tt := range tests {
+		got, err := sharedConfigValue(tt.profile, tt.key)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got != tt.want {
+			t.Errorf("sharedConfigValue(%q, %q) = %q, want %q", tt.profile, tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestSharedConfigValue_MissingFile(t *testing.T) {
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(t.TempDir(), "missing"))
+
+	got, err := sharedConfigValue("default", "region")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got != "" {
+		t.Errorf("sharedConfigValue = %q, want empty", got)
+	}
+}
+
+func TestSetSharedConfigValue(t *testing.T) {
+	writeSharedConfig(t, `[default]
+region = us-east-1
+
+[profile dev]
+# comment
+region = ap-northeast-1
+s3 =
+  max_concurrent_requests = 10
+
+[profile prod]
+credential_process = old
+`)
+
+	for _, tt := range []struct{ profile, value string }{
+		{profile: "dev", value: "op-aws-credential-process --profile dev"},
+		{profile: "prod", value: "op-aws-credential-process --profile prod"},
+		{profile: "new", value: "op-aws-credential-process --profile new"},
+	} {
+		if err := setSharedConfigValue(tt.profile, "credential_process", tt.value); err != nil {
+			t.Fatalf("setSharedConfigValue(%s) error = %v", tt.profile, err)
+		}
+	}
+
+	data, err := os.ReadFile(os.Getenv("AWS_CONFIG_FILE"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `[default]
+region = us-east-1
+
+[profile dev]
+# comment
+region = ap-northeast-1
+s3 =
+  max_concurrent_requests = 10
+credential_process = op-aws-credential-process --profile dev
+
+[profile prod]
+credential_process = op-aws-credential-process --profile prod
+
+[profile new]
+credential_process = op-aws-credential-process --profile new
+`
+	if string(data) != want {
+		t.Errorf("config =\n%s\nwant\n%s", data, want)
+	}
+}
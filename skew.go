@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+// skewThreshold is the clock offset above which a signature error is
+// attributed to clock skew. SigV4 tolerates up to five minutes.
+const skewThreshold = 4 * time.Minute
+
+// clockSkewErrorCodes are the error codes that always mean the request was
+// signed with a wrong time.
+var clockSkewErrorCodes = map[string]bool{
+	"RequestExpired":       true,
+	"RequestInTheFuture":   true,
+	"RequestTimeTooSkewed": true,
+}
+
+// signatureErrorCodes are the error codes that mean clock skew only when the
+// response Date shows an offset above skewThreshold.
+var signatureErrorCodes = map[string]bool{
+	"InvalidSignatureException": true,
+	"SignatureDoesNotMatch":     true,
+	"AuthFailure":               true,
+}
+
+// clockSkewError reports that AWS rejected a request because the local
+// clock is off. The SDK already retries such requests with a corrected
+// signing time, so this is only returned once the retries are exhausted.
+type clockSkewError struct {
+	// Skew is the server time minus the local time, or zero when the
+	// response carried no Date header.
+	Skew time.Duration
+	Err  error
+}
+
+func (e *clockSkewError) Error() string {
+	if e.Skew == 0 {
+		return fmt.Sprintf("the local clock appears to be out of sync with AWS; synchronize the system clock (e.g. enable NTP): %v", e.Err)
+	}
+	return fmt.Sprintf("the local clock is %s off from AWS; synchronize the system clock (e.g. enable NTP): %v", e.Skew.Abs().Round(time.Second), e.Err)
+}
+
+func (e *clockSkewError) Unwrap() error {
+	return e.Err
+}
+
+// detectClockSkew wraps err in a clockSkewError when it was caused by clock
+// skew, computing the offset from the Date header of the response.
+func detectClockSkew(err error, now time.Time) error {
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return err
+	}
+
+	var skew time.Duration
+	var respErr *awshttp.ResponseError
+	if errors.As(err, &respErr) && respErr.Response != nil {
+		if serverTime, parseErr := smithyhttp.ParseTime(respErr.Response.Header.Get("Date")); parseErr == nil {
+			skew = serverTime.Sub(now)
+		}
+	}
+
+	code := apiErr.ErrorCode()
+	if clockSkewErrorCodes[code] || (signatureErrorCodes[code] && skew.Abs() > skewThreshold) {
+		return &clockSkewError{Skew: skew, Err: err}
+	}
+	return err
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+const signatureDoesNotMatchResponse = `<ErrorResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <Error><Type>Sender</Type><Code>SignatureDoesNotMatch</Code><Message>Signature expired</Message></Error>
+  <RequestId>request-id</RequestId>
+</ErrorResponse>`
+
+func newSkewedServer(t *testing.T, skew time.Duration, body string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/xml")
+		w.Header().Set("Date", time.Now().Add(skew).UTC().Format(http.TimeFormat))
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestDetectClockSkew(t *testing.T) {
+	tests := []struct {
+		name     string
+		skew     time.Duration
+		body     string
+		wantSkew bool
+	}{
+		{name: "signature error with large skew", skew: time.Hour, body: signatureDoesNotMatchResponse, wantSkew: true},
+		{name: "signature error without skew", body: signatureDoesNotMatchResponse, wantSkew: false},
+		{name: "throttling with large skew", skew: -time.Hour, body: throttlingResponse, wantSkew: false},
+		{name: "request expired", body: strings.Replace(throttlingResponse, "Throttling", "RequestExpired", 1), wantSkew: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newSkewedServer(t, tt.skew, tt.body)
+			client := newRetryingSTSClient(srv.URL, 1)
+
+			_, err := client.GetSessionToken(context.Background(), &sts.GetSessionTokenInput{})
+			if err == nil {
+				t.Fatal("GetSessionToken() error = nil")
+			}
+
+			err = detectClockSkew(err, time.Now())
+			var skewErr *clockSkewError
+			if got := errors.As(err, &skewErr); got != tt.wantSkew {
+				t.Fatalf("detectClockSkew() = %v, want clock skew %v", err, tt.wantSkew)
+			}
+			if !tt.wantSkew {
+				return
+			}
+			if d := (skewErr.Skew - tt.skew).Abs(); d > 5*time.Second {
+				t.Errorf("Skew = %v, want about %v", skewErr.Skew, tt.skew)
+			}
+			if !strings.Contains(err.Error(), "synchronize the system clock") {
+				t.Errorf("Error() = %q, want a hint to synchronize the clock", err.Error())
+			}
+		})
+	}
+}
+
+func TestDetectClockSkew_NotAPIError(t *testing.T) {
+	err := errors.New("boom")
+	if got := detectClockSkew(err, time.Now()); got != err {
+		t.Errorf("detectClockSkew() = %v, want %v", got, err)
+	}
+}
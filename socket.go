@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// socketReadTimeout bounds how long a client of the OTP socket may take to
+// send the code, so one that connects and stays silent cannot hold the
+// prompt.
+const socketReadTimeout = 5 * time.Second
+
+// socketOTPSource waits for an external tool, e.g. a Raycast or Alfred script
+// or a browser extension, to push the MFA code over a unix socket:
+//
+//	printf '123456\n' | nc -U <path>
+//
+// The socket is readable only by the current user and removed afterwards.
+type socketOTPSource struct {
+	path   string
+	label  mfaLabel
+	stderr io.Writer
+}
+
+func (s *socketOTPSource) OTP(ctx context.Context, serial string) (string, error) {
+	// A socket left behind by a crashed run would make Listen fail.
+	if info, err := os.Lstat(s.path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		_ = os.Remove(s.path)
+	}
+	l, err := net.Listen("unix", s.path)
+	if err != nil {
+		return "", fmt.Errorf("failed to listen on the OTP socket: %w", err)
+	}
+	defer l.Close()
+	if err := os.Chmod(s.path, 0600); err != nil {
+		return "", err
+	}
+
+	w := s.stderr
+	if w == nil {
+		w = os.Stderr
+	}
+	_, _ = fmt.Fprintf(w, "Waiting for the %s on %s\n", s.label.prompt(serial), s.path)
+
+	stop := context.AfterFunc(ctx, func() { _ = l.Close() })
+	defer stop()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return "", ctx.Err()
+			}
+			return "", err
+		}
+		code, err := readSocketCode(conn, socketReadTimeout)
+		if err == nil {
+			return code, nil
+		}
+		debugLog.Printf("ignoring OTP socket client: %v", err)
+	}
+}
+
+// readSocketCode reads one line from conn within timeout and acknowledges it.
+func readSocketCode(conn net.Conn, timeout time.Duration) (string, error) {
+	defer conn.Close()
+	if err := conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return "", err
+	}
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	code := strings.TrimSpace(line)
+	if code == "" {
+		return "", errors.New("no code received")
+	}
+	_, _ = fmt.Fprintln(conn, "ok")
+	return code, nil
+}
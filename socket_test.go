@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// pushCode connects to the socket at path once it is listening and sends code.
+func pushCode(t *testing.T, path, code string) string {
+	t.Helper()
+	var conn net.Conn
+	var err error
+	for range 100 {
+		if conn, err = net.Dial("unix", path); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Errorf("failed to connect to the OTP socket: %v", err)
+		return ""
+	}
+	defer conn.Close()
+	_, _ = fmt.Fprint(conn, code)
+	reply, _ := io.ReadAll(conn)
+	return string(reply)
+}
+
+func TestSocketOTPSource(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "otp.sock")
+	var stderr bytes.Buffer
+	source := &socketOTPSource{path: path, label: mfaLabel{profile: "prod"}, stderr: &stderr}
+
+	replies := make(chan string, 2)
+	go func() {
+		replies <- pushCode(t, path, "\n")
+		replies <- pushCode(t, path, "123456\n")
+	}()
+
+	got, err := source.OTP(context.Background(), "arn:aws:iam::123456789012:mfa/alice")
+	if err != nil {
+		t.Fatalf("OTP() error = %v", err)
+	}
+	if got != "123456" {
+		t.Errorf("OTP() = %q, want %q", got, "123456")
+	}
+	<-replies
+	if reply := <-replies; reply != "ok\n" {
+		t.Errorf("reply = %q, want %q", reply, "ok\n")
+	}
+	if !strings.Contains(stderr.String(), path) {
+		t.Errorf("stderr = %q, want the socket path", stderr.String())
+	}
+}
+
+func TestSocketOTPSource_Cancelled(t *testing.T) {
+	source := &socketOTPSource{path: filepath.Join(t.TempDir(), "otp.sock"), stderr: io.Discard}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if _, err := source.OTP(ctx, ""); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("OTP() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestReadSocketCode_Silent(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+
+	if _, err := readSocketCode(server, 10*time.Millisecond); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("readSocketCode() error = %v, want %v", err, os.ErrDeadlineExceeded)
+	}
+}
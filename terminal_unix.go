@@ -0,0 +1,35 @@
+//go:build !windows
+
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+
+	"golang.org/x/term"
+)
+
+// openTerminal opens the controlling terminal for prompting, since stdout
+// carries the credential JSON.
+func openTerminal() (io.Reader, io.Writer, func(), error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	// Some sandboxes provide a /dev/tty that opens but is not a terminal.
+	if !term.IsTerminal(int(tty.Fd())) {
+		_ = tty.Close()
+		return nil, nil, nil, errors.New("/dev/tty is not a terminal")
+	}
+	return tty, tty, func() {
+		_ = tty.Close()
+	}, nil
+}
+
+// shellCommand runs command with the platform shell.
+func shellCommand(ctx context.Context, command string) *exec.Cmd {
+	return exec.CommandContext(ctx, "sh", "-c", command)
+}
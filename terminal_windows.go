@@ -0,0 +1,34 @@
+//go:build windows
+
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"os/exec"
+)
+
+// openTerminal opens the console for prompting, since stdout carries the
+// credential JSON. CONIN$ and CONOUT$ are the Windows counterparts of
+// /dev/tty.
+func openTerminal() (io.Reader, io.Writer, func(), error) {
+	in, err := os.OpenFile("CONIN$", os.O_RDWR, 0)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	out, err := os.OpenFile("CONOUT$", os.O_RDWR, 0)
+	if err != nil {
+		_ = in.Close()
+		return nil, nil, nil, err
+	}
+	return in, out, func() {
+		_ = in.Close()
+		_ = out.Close()
+	}, nil
+}
+
+// shellCommand runs command with the platform shell.
+func shellCommand(ctx context.Context, command string) *exec.Cmd {
+	return exec.CommandContext(ctx, "cmd", "/C", command)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTOTPKey_Code(t *testing.T) {
+	// Test vectors from RFC 6238 Appendix B.
+	tests := []struct {
+		name string
+		uri  string
+		time int64
+		want string
+	}{
+		{name: "SHA1", uri: "otpauth://totp/aws?secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ&digits=8", time: 59, want: "94287082"},
+		{name: "SHA1 later", uri: "otpauth://totp/aws?secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ&digits=8", time: 1111111109, want: "07081804"},
+		{name: "SHA256", uri: "otpauth://totp/aws?secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQGEZA&algorithm=SHA256&digits=8", time: 59, want: "46119246"},
+		{name: "six digits", uri: "otpauth://totp/aws?secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ", time: 59, want: "287082"},
+		{name: "bare secret", uri: "gezd gnbv gy3t qojq gezd gnbv gy3t qojq", time: 59, want: "287082"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := parseOTPAuth(tt.uri)
+			if err != nil {
+				t.Fatalf("parseOTPAuth() error = %v", err)
+			}
+			if got := key.code(time.Unix(tt.time, 0)); got != tt.want {
+				t.Errorf("code() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseOTPAuth_Invalid(t *testing.T) {
+	for _, uri := range []string{
+		"otpauth://hotp/aws?secret=GEZDGNBVGY3TQOJQ",
+		"otpauth://totp/aws?secret=GEZDGNBVGY3TQOJQ&algorithm=MD5",
+		"otpauth://totp/aws?secret=not-base32!",
+		"otpauth://totp/aws",
+	} {
+		if _, err := parseOTPAuth(uri); err == nil {
+			t.Errorf("parseOTPAuth(%q) error = nil, want error", uri)
+		}
+	}
+}
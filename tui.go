@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// totpPeriod is the window of the TOTP codes issued by AWS virtual MFA
+// devices.
+const totpPeriod = 30 * time.Second
+
+// errPromptAborted is returned when the user aborts the prompt with Ctrl-C.
+var errPromptAborted = errors.New("MFA prompt aborted")
+
+// tuiOTPSource is a richer terminal prompt that shows how long the current
+// TOTP window lasts and submits as soon as six digits are typed, so codes do
+// not expire while typing. Without a terminal it falls back to prompt.
+type tuiOTPSource struct {
+	prompt *ttyOTPSource
+	now    func() time.Time
+}
+
+func (s *tuiOTPSource) OTP(ctx context.Context, serial string) (string, error) {
+	r, w, closeFn, ok := s.prompt.open()
+	defer closeFn()
+	f, isFile := r.(*os.File)
+	if !ok || !isFile || !term.IsTerminal(int(f.Fd())) {
+		return s.prompt.OTP(ctx, serial)
+	}
+
+	restore, err := makeRaw(ctx, f)
+	if err != nil {
+		return "", err
+	}
+	defer restore()
+
+	now := s.now
+	if now == nil {
+		now = time.Now
+	}
+	return runTUI(ctx, r, w, s.prompt.label.prompt(serial), now)
+}
+
+func (s *tuiOTPSource) NotifyInvalidOTP(ctx context.Context, err error) {
+	s.prompt.NotifyInvalidOTP(ctx, err)
+}
+
+// runTUI reads a code key by key from r, redrawing the prompt on w with a
+// countdown of the TOTP window. Only digits are accepted; the code is
+// submitted at six digits or on Enter.
+func runTUI(ctx context.Context, r io.Reader, w io.Writer, prompt string, now func() time.Time) (string, error) {
+	keys := make(chan byte)
+	readErr := make(chan error, 1)
+	go func() {
+		buf := make([]byte, 1)
+		for {
+			if _, err := r.Read(buf); err != nil {
+				readErr <- err
+				return
+			}
+			select {
+			case keys <- buf[0]:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	var code []byte
+	for {
+		renderTUI(w, prompt, code, now())
+		select {
+		case <-ctx.Done():
+			_, _ = fmt.Fprint(w, "\r\n")
+			return "", ctx.Err()
+		case err := <-readErr:
+			_, _ = fmt.Fprint(w, "\r\n")
+			return "", err
+		case <-ticker.C:
+		case b := <-keys:
+			switch {
+			case b == 3: // Ctrl-C
+				_, _ = fmt.Fprint(w, "\r\n")
+				return "", errPromptAborted
+			case b == '\r' || b == '\n':
+				if len(code) == 6 {
+					_, _ = fmt.Fprint(w, "\r\n")
+					return string(code), nil
+				}
+			case b == 127 || b == 8: // Backspace
+				if len(code) > 0 {
+					code = code[:len(code)-1]
+				}
+			case b >= '0' && b <= '9' && len(code) < 6:
+				code = append(code, b)
+				if len(code) == 6 {
+					renderTUI(w, prompt, code, now())
+					_, _ = fmt.Fprint(w, "\r\n")
+					return string(code), nil
+				}
+			}
+		}
+	}
+}
+
+// renderTUI redraws the prompt line with the typed digits masked and the
+// seconds left in the current TOTP window.
+func renderTUI(w io.Writer, prompt string, code []byte, now time.Time) {
+	remaining := totpPeriod - time.Duration(now.UnixNano())%totpPeriod
+	masked := strings.Repeat("*", len(code)) + strings.Repeat("_", 6-len(code))
+	_, _ = fmt.Fprintf(w, "\r\x1b[K%s: %s (%ds left)", prompt, masked, int(remaining.Seconds()+0.999))
+}
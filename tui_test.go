@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunTUI(t *testing.T) {
+	now := func() time.Time { return time.Unix(1_700_000_020, 0) }
+
+	tests := []struct {
+		name    string
+		input   string
+		want    string
+		wantErr error
+	}{
+		{name: "auto-submits at six digits", input: "123456", want: "123456"},
+		{name: "ignores non-digits", input: "12a3 4-56", want: "123456"},
+		{name: "backspace", input: "1239\x7f456", want: "123456"},
+		{name: "enter before six digits is ignored", input: "123\r456", want: "123456"},
+		{name: "ctrl-c aborts", input: "12\x03", wantErr: errPromptAborted},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var w bytes.Buffer
+			got, err := runTUI(context.Background(), strings.NewReader(tt.input), &w, "MFA code for profile prod", now)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("runTUI() error = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("runTUI() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderTUI(t *testing.T) {
+	var w bytes.Buffer
+	renderTUI(&w, "MFA code for profile prod", []byte("12"), time.Unix(1_700_000_020, 0))
+
+	want := "\r\x1b[KMFA code for profile prod: **____ (20s left)"
+	if got := w.String(); got != want {
+		t.Errorf("renderTUI() = %q, want %q", got, want)
+	}
+}
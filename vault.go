@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// vaultCredentialSource reads the key pair from a HashiCorp Vault KV secret,
+// e.g. secret/aws/iam-user, whose keys are the fields. It talks to the Vault
+// HTTP API directly, so neither the vault CLI nor its SDK is needed.
+type vaultCredentialSource struct {
+	client *http.Client
+	addr   string
+	// kvVersion is the version of the KV secrets engine mounted at the first
+	// segment of the path.
+	kvVersion int
+	// login returns the token requests are made with. It is called once,
+	// on first use.
+	login func(ctx context.Context) (string, error)
+	token string
+	OpAwsItem
+	now func() time.Time
+}
+
+// newVaultLogin returns a login function for vaultCredentialSource. It logs
+// in with AppRole when roleID is set, and otherwise uses VAULT_TOKEN or the
+// ~/.vault-token file written by vault login.
+func newVaultLogin(client *http.Client, addr, roleID, secretID string) func(ctx context.Context) (string, error) {
+	return func(ctx context.Context) (string, error) {
+		if roleID != "" {
+			var out struct {
+				Auth struct {
+					ClientToken string `json:"client_token"`
+				} `json:"auth"`
+			}
+			body, err := json.Marshal(map[string]string{"role_id": roleID, "secret_id": secretID})
+			if err != nil {
+				return "", err
+			}
+			if err := vaultRequest(ctx, client, http.MethodPost, addr+"/v1/auth/approle/login", "", bytes.NewReader(body), &out); err != nil {
+				return "", fmt.Errorf("vault approle login failed: %w", err)
+			}
+			return out.Auth.ClientToken, nil
+		}
+		if token := os.Getenv("VAULT_TOKEN"); token != "" {
+			return token, nil
+		}
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		data, err := os.ReadFile(filepath.Join(home, ".vault-token"))
+		if err != nil {
+			return "", errors.New("no vault token; run vault login, set VAULT_TOKEN, or pass --vault-role-id")
+		}
+		return strings.TrimSpace(string(data)), nil
+	}
+}
+
+// vaultRequest makes a Vault API request and decodes the JSON response into
+// out, surfacing the errors Vault reports.
+func vaultRequest(ctx context.Context, client *http.Client, method, url, token string, body io.Reader, out any) error {
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		var vaultErr struct {
+			Errors []string `json:"errors"`
+		}
+		_ = json.NewDecoder(resp.Body).Decode(&vaultErr)
+		if len(vaultErr.Errors) > 0 {
+			return fmt.Errorf("%s: %s", resp.Status, strings.Join(vaultErr.Errors, "; "))
+		}
+		return errors.New(resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// secret reads the secret at the path, as strings keyed by field.
+func (s *vaultCredentialSource) secret(ctx context.Context) (map[string]string, error) {
+	if s.token == "" {
+		token, err := s.login(ctx)
+		if err != nil {
+			return nil, err
+		}
+		s.token = token
+	}
+
+	path := strings.Trim(s.Item, "/")
+	if s.kvVersion == 2 {
+		// KV v2 serves the latest version under <mount>/data/<path>.
+		mount, rest, ok := strings.Cut(path, "/")
+		if !ok {
+			return nil, fmt.Errorf("vault path %q has no secret below the mount", s.Item)
+		}
+		path = mount + "/data/" + rest
+	}
+	var out struct {
+		Data json.RawMessage `json:"data"`
+	}
+	if err := vaultRequest(ctx, s.client, http.MethodGet, strings.TrimRight(s.addr, "/")+"/v1/"+path, s.token, nil, &out); err != nil {
+		return nil, fmt.Errorf("failed to read vault secret %s: %w", s.Item, err)
+	}
+	data := out.Data
+	if s.kvVersion == 2 {
+		var v2 struct {
+			Data json.RawMessage `json:"data"`
+		}
+		if err := json.Unmarshal(data, &v2); err != nil {
+			return nil, err
+		}
+		data = v2.Data
+	}
+
+	var raw map[string]any
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	fields := make(map[string]string, len(raw))
+	for key, value := range raw {
+		fields[key] = fmt.Sprint(value)
+	}
+	return fields, nil
+}
+
+func (s *vaultCredentialSource) Retrieve(ctx context.Context) (aws.Credentials, error) {
+	return readCredentials(ctx, s)
+}
+
+func (s *vaultCredentialSource) awsItem() OpAwsItem {
+	return s.OpAwsItem
+}
+
+func (s *vaultCredentialSource) withItem(vault, item string) opItem {
+	other := *s
+	other.Item = item
+	return &other
+}
+
+func (s *vaultCredentialSource) details(ctx context.Context) (*opItemDetails, error) {
+	fields, err := s.secret(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &opItemDetails{fields: fields}, nil
+}
+
+func (s *vaultCredentialSource) fields(ctx context.Context, labels ...string) (map[string]string, error) {
+	fields, err := s.secret(ctx)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]string, len(labels))
+	for _, label := range labels {
+		if value, ok := fields[label]; ok {
+			values[label] = value
+		}
+	}
+	return values, nil
+}
+
+// vaultOTPLabel is the field the MFA seed is read from when no label is
+// given.
+const vaultOTPLabel = "totp"
+
+func (s *vaultCredentialSource) totp(ctx context.Context, label string) (string, error) {
+	seed, err := s.otpSeed(ctx, label)
+	if err != nil {
+		return "", err
+	}
+	now := s.now
+	if now == nil {
+		now = time.Now
+	}
+	return totpCode(seed, now())
+}
+
+func (s *vaultCredentialSource) otpSeed(ctx context.Context, label string) (string, error) {
+	if label == "" {
+		label = vaultOTPLabel
+	}
+	fields, err := s.secret(ctx)
+	if err != nil {
+		return "", err
+	}
+	seed := fields[label]
+	if seed == "" {
+		return "", fmt.Errorf("missing one-time password field %q in vault secret %s", label, s.Item)
+	}
+	return seed, nil
+}
+
+func (s *vaultCredentialSource) read(ctx context.Context, ref string) (string, error) {
+	return "", fmt.Errorf("secret reference %s cannot be read from vault", ref)
+}
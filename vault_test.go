@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newFakeVault serves secrets by API path to requests bearing token, and
+// hands out token for the AppRole role-id/secret-id.
+func newFakeVault(t *testing.T, token string, secrets map[string]any) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/auth/approle/login" {
+			var body map[string]string
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body["role_id"] != "role-id" || body["secret_id"] != "secret-id" {
+				w.WriteHeader(http.StatusBadRequest)
+				_, _ = w.Write([]byte(`{"errors":["invalid role or secret ID"]}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"auth":{"client_token":"` + token + `"}}`))
+			return
+		}
+		if r.Header.Get("X-Vault-Token") != token {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"errors":["permission denied"]}`))
+			return
+		}
+		secret, ok := secrets[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"errors":[]}`))
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"data": secret})
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestVaultCredentialSource(t *testing.T) {
+	fields := map[string]any{
+		"access_key_id":     "AKIA",
+		"secret_access_key": "secret",
+		"totp":              "otpauth://totp/aws?secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ",
+	}
+	srv := newFakeVault(t, "s.token", map[string]any{
+		"/v1/secret/data/aws/iam-user": map[string]any{"data": fields},
+		"/v1/kv/aws/iam-user":          fields,
+	})
+
+	tests := []struct {
+		name      string
+		path      string
+		kvVersion int
+		roleID    string
+		token     string
+		wantErr   string
+	}{
+		{name: "kv v2 with token", path: "secret/aws/iam-user", kvVersion: 2, token: "s.token"},
+		{name: "kv v1 with token", path: "kv/aws/iam-user", kvVersion: 1, token: "s.token"},
+		{name: "approle", path: "secret/aws/iam-user", kvVersion: 2, roleID: "role-id"},
+		{name: "wrong token", path: "secret/aws/iam-user", kvVersion: 2, token: "s.other", wantErr: "permission denied"},
+		{name: "missing secret", path: "secret/aws/other", kvVersion: 2, token: "s.token", wantErr: "404"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("VAULT_TOKEN", tt.token)
+			source := &vaultCredentialSource{
+				client:    srv.Client(),
+				addr:      srv.URL,
+				kvVersion: tt.kvVersion,
+				login:     newVaultLogin(srv.Client(), srv.URL, tt.roleID, "secret-id"),
+				OpAwsItem: OpAwsItem{
+					Item:                 tt.path,
+					AccessKeyIDField:     "access_key_id",
+					SecretAccessKeyField: "secret_access_key",
+				},
+				now: func() time.Time { return time.Unix(59, 0) },
+			}
+
+			creds, err := source.Retrieve(context.Background())
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Retrieve() error = %v, want containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Retrieve() error = %v", err)
+			}
+			if creds.AccessKeyID != "AKIA" || creds.SecretAccessKey != "secret" {
+				t.Errorf("Retrieve() = %q, %q, want %q, %q", creds.AccessKeyID, creds.SecretAccessKey, "AKIA", "secret")
+			}
+			if got, err := source.totp(context.Background(), ""); err != nil || got != "287082" {
+				t.Errorf("totp() = %q, %v, want %q", got, err, "287082")
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	ststypes "github.com/aws/aws-sdk-go-v2/service/sts/types"
+)
+
+type AssumeRoleWithWebIdentityAPIClient interface {
+	AssumeRoleWithWebIdentity(ctx context.Context, params *sts.AssumeRoleWithWebIdentityInput, optFns ...func(*sts.Options)) (*sts.AssumeRoleWithWebIdentityOutput, error)
+}
+
+type WebIdentityTokenSource interface {
+	WebIdentityToken(ctx context.Context) (string, error)
+}
+
+// WebIdentityProvider exchanges an OIDC token for role credentials. The call
+// is unsigned, so no long-term credentials or MFA are involved.
+type WebIdentityProvider struct {
+	TokenSource     WebIdentityTokenSource
+	StsClient       AssumeRoleWithWebIdentityAPIClient
+	RoleArn         string
+	RoleSessionName string
+	Duration        time.Duration
+	Policy          string
+	PolicyArns      []string
+}
+
+func (p *WebIdentityProvider) sessionParams() sessionParams {
+	return sessionParams{RoleSessionName: p.RoleSessionName, Policy: p.Policy, PolicyArns: p.PolicyArns}
+}
+
+func (p *WebIdentityProvider) RetrieveStsCredentials(ctx context.Context) (*ststypes.Credentials, error) {
+	token, err := p.TokenSource.WebIdentityToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if token == "" {
+		return nil, errors.New("web identity token is empty")
+	}
+
+	input := &sts.AssumeRoleWithWebIdentityInput{
+		RoleArn:          aws.String(p.RoleArn),
+		RoleSessionName:  aws.String(p.RoleSessionName),
+		WebIdentityToken: aws.String(token),
+		DurationSeconds:  aws.Int32(int32(p.Duration.Seconds())),
+	}
+	if p.Policy != "" {
+		input.Policy = aws.String(p.Policy)
+	}
+	for _, arn := range p.PolicyArns {
+		input.PolicyArns = append(input.PolicyArns, ststypes.PolicyDescriptorType{Arn: aws.String(arn)})
+	}
+
+	out, err := p.StsClient.AssumeRoleWithWebIdentity(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+	if out == nil || out.Credentials == nil {
+		return nil, errors.New("sts credentials were empty")
+	}
+
+	return out.Credentials, nil
+}
+
+func (p *WebIdentityProvider) Retrieve(ctx context.Context) (aws.Credentials, error) {
+	creds, err := p.RetrieveStsCredentials(ctx)
+	if err != nil {
+		return aws.Credentials{}, err
+	}
+
+	return toAwsCredentials(creds), nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+type fakeWebIdentityTokenSource struct {
+	token string
+	err   error
+}
+
+func (f *fakeWebIdentityTokenSource) WebIdentityToken(ctx context.Context) (string, error) {
+	return f.token, f.err
+}
+
+type fakeWebIdentityClient struct {
+	output    *sts.AssumeRoleWithWebIdentityOutput
+	err       error
+	lastInput *sts.AssumeRoleWithWebIdentityInput
+}
+
+func (f *fakeWebIdentityClient) AssumeRoleWithWebIdentity(ctx context.Context, params *sts.AssumeRoleWithWebIdentityInput, optFns ...func(*sts.Options)) (*sts.AssumeRoleWithWebIdentityOutput, error) {
+	f.lastInput = params
+	return f.output, f.err
+}
+
+func TestWebIdentityProvider_Retrieve(t *testing.T) {
+	stsClient := &fakeWebIdentityClient{
+		output: &sts.AssumeRoleWithWebIdentityOutput{Credentials: newStsCreds("ASIA", "SECRET", "TOKEN", time.Now().Add(1*time.Hour))},
+	}
+	provider := &WebIdentityProvider{
+		TokenSource:     &fakeWebIdentityTokenSource{token: "eyJhbGciOi"},
+		StsClient:       stsClient,
+		RoleArn:         "arn:aws:iam::222222222222:role/CI",
+		RoleSessionName: "session",
+		Duration:        1 * time.Hour,
+	}
+
+	got, err := provider.Retrieve(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.AccessKeyID != "ASIA" {
+		t.Errorf("AccessKeyID = %q, want %q", got.AccessKeyID, "ASIA")
+	}
+	if got := aws.ToString(stsClient.lastInput.WebIdentityToken); got != "eyJhbGciOi" {
+		t.Errorf("WebIdentityToken = %q, want %q", got, "eyJhbGciOi")
+	}
+	if got := aws.ToString(stsClient.lastInput.RoleArn); got != "arn:aws:iam::222222222222:role/CI" {
+		t.Errorf("RoleArn = %q, want %q", got, "arn:aws:iam::222222222222:role/CI")
+	}
+}
+
+func TestWebIdentityProvider_TokenError(t *testing.T) {
+	stsClient := &fakeWebIdentityClient{}
+	provider := &WebIdentityProvider{
+		TokenSource: &fakeWebIdentityTokenSource{err: errors.New("token error")},
+		StsClient:   stsClient,
+		RoleArn:     "arn:aws:iam::222222222222:role/CI",
+		Duration:    1 * time.Hour,
+	}
+
+	_, err := provider.RetrieveStsCredentials(context.Background())
+	if err == nil || err.Error() != "token error" {
+		t.Errorf("error = %v, want %q", err, "token error")
+	}
+	if stsClient.lastInput != nil {
+		t.Error("StsClient.AssumeRoleWithWebIdentity should not have been called")
+	}
+}
+
+var _ StsSessionProvider = (*WebIdentityProvider)(nil)
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+type processCmd struct {
+	SessionFlags `embed:""`
+	Approve      bool `help:"Ask on the terminal before serving cached credentials, showing the requesting process."`
+}
+
+func (c *processCmd) Run() error {
+	ctx := context.Background()
+
+	s, err := c.resolve(ctx)
+	if err != nil {
+		return err
+	}
+	// A background renewal only refreshes the cache, so nothing it mints
+	// is handed out without the approval of the invocation serving it.
+	if renewing() {
+		_, err := s.creds.Retrieve(ctx)
+		return err
+	}
+	if !c.Approve {
+		return writeCredentials(ctx, s.creds)
+	}
+	if c.nonInteractive() {
+		return errors.New("--approve cannot be used in non-interactive mode")
+	}
+
+	creds := aws.NewCredentialsCache(s.creds)
+	if _, err := creds.Retrieve(ctx); err != nil {
+		return err
+	}
+	// Entering an MFA code in this run already approves the request.
+	if prompted, ok := s.builder.otpSource.(*promptedOTPSource); !ok || !prompted.prompted {
+		if err := approve(&ttyOTPSource{label: mfaLabel{profile: c.Profile}}, os.Getppid()); err != nil {
+			return err
+		}
+	}
+	return writeCredentials(ctx, creds)
+}
+
+type whoamiCmd struct {
+	SessionFlags `embed:""`
+}
+
+func (c *whoamiCmd) Run() error {
+	ctx := context.Background()
+
+	s, err := c.resolve(ctx)
+	if err != nil {
+		return err
+	}
+	return whoami(ctx, s.builder.newSTSClient(aws.NewCredentialsCache(s.creds)), os.Stdout)
+}
+
+type GetCallerIdentityAPIClient interface {
+	GetCallerIdentity(ctx context.Context, params *sts.GetCallerIdentityInput, optFns ...func(*sts.Options)) (*sts.GetCallerIdentityOutput, error)
+}
+
+// whoami writes the account, ARN, and user ID the credentials of client
+// resolve to.
+func whoami(ctx context.Context, client GetCallerIdentityAPIClient, w io.Writer) error {
+	out, err := client.GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+	if err != nil {
+		return err
+	}
+	if out == nil {
+		return errors.New("sts caller identity was empty")
+	}
+
+	_, err = fmt.Fprintf(w, "Account: %s\nArn:     %s\nUserId:  %s\n",
+		aws.ToString(out.Account), aws.ToString(out.Arn), aws.ToString(out.UserId))
+	return err
+}
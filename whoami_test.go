@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+type fakeGetCallerIdentityClient struct {
+	output *sts.GetCallerIdentityOutput
+	err    error
+}
+
+func (f *fakeGetCallerIdentityClient) GetCallerIdentity(ctx context.Context, params *sts.GetCallerIdentityInput, optFns ...func(*sts.Options)) (*sts.GetCallerIdentityOutput, error) {
+	return f.output, f.err
+}
+
+func TestWhoami(t *testing.T) {
+	client := &fakeGetCallerIdentityClient{
+		output: &sts.GetCallerIdentityOutput{
+			Account: aws.String("123456789012"),
+			Arn:     aws.String("arn:aws:iam::123456789012:user/alice"),
+			UserId:  aws.String("AIDAEXAMPLE"),
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := whoami(context.Background(), client, &buf); err != nil {
+		t.Fatalf("whoami() error = %v", err)
+	}
+
+	want := "Account: 123456789012\nArn:     arn:aws:iam::123456789012:user/alice\nUserId:  AIDAEXAMPLE\n"
+	if got := buf.String(); got != want {
+		t.Errorf("whoami() output = %q, want %q", got, want)
+	}
+}
+
+func TestWhoami_Error(t *testing.T) {
+	client := &fakeGetCallerIdentityClient{err: errors.New("access denied")}
+
+	var buf bytes.Buffer
+	if err := whoami(context.Background(), client, &buf); err == nil {
+		t.Fatal("whoami() error = nil, want error")
+	}
+	if buf.Len() != 0 {
+		t.Errorf("whoami() output = %q, want empty", buf.String())
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// wincredCredentialSource reads the key pair from a generic credential in
+// Windows Credential Manager, such as one added with
+//
+//	cmdkey /generic:aws/iam-user /user:AKIA... /pass
+//
+// Item is the credential's target name. Its user name is the access key ID
+// and its password the secret access key. The MFA seed, if any, is the
+// password of the <target>/totp credential.
+type wincredCredentialSource struct {
+	// lookup returns the user name and password of the generic credential
+	// target, and false when there is none.
+	lookup func(target string) (user, password string, ok bool, err error)
+	OpAwsItem
+	now func() time.Time
+}
+
+func (s *wincredCredentialSource) credential(target string) (user, password string, err error) {
+	user, password, ok, err := s.lookup(target)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read credential %s: %w", target, err)
+	}
+	if !ok {
+		return "", "", fmt.Errorf("no credential %s in Credential Manager", target)
+	}
+	return user, password, nil
+}
+
+func (s *wincredCredentialSource) Retrieve(ctx context.Context) (aws.Credentials, error) {
+	return readCredentials(ctx, s)
+}
+
+func (s *wincredCredentialSource) awsItem() OpAwsItem {
+	return s.OpAwsItem
+}
+
+func (s *wincredCredentialSource) withItem(vault, item string) opItem {
+	other := *s
+	other.Item = item
+	return &other
+}
+
+// details presents the credential as the access key ID and secret access key
+// fields.
+func (s *wincredCredentialSource) details(ctx context.Context) (*opItemDetails, error) {
+	user, password, err := s.credential(s.Item)
+	if err != nil {
+		return nil, err
+	}
+	return &opItemDetails{fields: map[string]string{
+		s.AccessKeyIDField:     user,
+		s.SecretAccessKeyField: password,
+	}}, nil
+}
+
+func (s *wincredCredentialSource) fields(ctx context.Context, labels ...string) (map[string]string, error) {
+	details, err := s.details(ctx)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]string, len(labels))
+	for _, label := range labels {
+		if value, ok := details.fields[label]; ok {
+			values[label] = value
+		}
+	}
+	return values, nil
+}
+
+// wincredOTPLabel is the target suffix of the credential holding the MFA
+// seed when no label is given.
+const wincredOTPLabel = "totp"
+
+func (s *wincredCredentialSource) totp(ctx context.Context, label string) (string, error) {
+	seed, err := s.otpSeed(ctx, label)
+	if err != nil {
+		return "", err
+	}
+	now := s.now
+	if now == nil {
+		now = time.Now
+	}
+	return totpCode(seed, now())
+}
+
+func (s *wincredCredentialSource) otpSeed(ctx context.Context, label string) (string, error) {
+	if label == "" {
+		label = wincredOTPLabel
+	}
+	_, seed, err := s.credential(s.Item + "/" + label)
+	return seed, err
+}
+
+func (s *wincredCredentialSource) read(ctx context.Context, ref string) (string, error) {
+	return "", fmt.Errorf("secret reference %s cannot be read from Credential Manager", ref)
+}
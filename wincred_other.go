@@ -0,0 +1,20 @@
+//go:build !windows
+
+package main
+
+import "errors"
+
+// readWincred fails outside Windows, which has no Credential Manager.
+func readWincred(target string) (user, password string, ok bool, err error) {
+	return "", "", false, errors.New("the Credential Manager is only available on Windows")
+}
+
+// writeWincred fails outside Windows, which has no Credential Manager.
+func writeWincred(target, user, password string) error {
+	return errors.New("the Credential Manager is only available on Windows")
+}
+
+// deleteWincred fails outside Windows, which has no Credential Manager.
+func deleteWincred(target string) error {
+	return errors.New("the Credential Manager is only available on Windows")
+}
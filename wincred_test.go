@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestWincredCredentialSource(t *testing.T) {
+	credentials := map[string][2]string{
+		"aws/iam-user":      {"AKIA", "secret"},
+		"aws/iam-user/totp": {"", "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"},
+	}
+	source := &wincredCredentialSource{
+		lookup: func(target string) (string, string, bool, error) {
+			cred, ok := credentials[target]
+			return cred[0], cred[1], ok, nil
+		},
+		OpAwsItem: OpAwsItem{
+			Item:                 "aws/iam-user",
+			AccessKeyIDField:     "Access key ID",
+			SecretAccessKeyField: "Secret access key",
+		},
+		now: func() time.Time { return time.Unix(59, 0) },
+	}
+	ctx := context.Background()
+
+	creds, err := source.Retrieve(ctx)
+	if err != nil {
+		t.Fatalf("Retrieve() error = %v", err)
+	}
+	if creds.AccessKeyID != "AKIA" || creds.SecretAccessKey != "secret" {
+		t.Errorf("Retrieve() = %q, %q, want %q, %q", creds.AccessKeyID, creds.SecretAccessKey, "AKIA", "secret")
+	}
+	if got, err := source.totp(ctx, ""); err != nil || got != "287082" {
+		t.Errorf("totp() = %q, %v, want %q", got, err, "287082")
+	}
+	if _, err := source.withItem("", "aws/other").Retrieve(ctx); err == nil {
+		t.Error("Retrieve() error = nil, want error for a missing credential")
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// yubikeyOTPSource reads the MFA code from an OATH account on a YubiKey
+// through ykman. ykman's own messages, such as the touch prompt, are passed
+// through to the user.
+type yubikeyOTPSource struct {
+	cliPath string
+	account string
+	// serial selects the YubiKey when several are connected.
+	serial  string
+	timeout time.Duration
+	prompt  *ttyOTPSource
+}
+
+func (s *yubikeyOTPSource) args() []string {
+	var args []string
+	if s.serial != "" {
+		args = append(args, "--device", s.serial)
+	}
+	return append(args, "oath", "accounts", "code", "--single", s.account)
+}
+
+func (s *yubikeyOTPSource) OTP(ctx context.Context, serial string) (string, error) {
+	if s.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.timeout)
+		defer cancel()
+	}
+
+	_, w, closeFn, _ := s.prompt.open()
+	defer closeFn()
+
+	cmd := exec.CommandContext(ctx, s.cliPath, s.args()...)
+	var stderr bytes.Buffer
+	cmd.Stderr = io.MultiWriter(w, &stderr)
+	out, err := cmd.Output()
+	if ctx.Err() == context.DeadlineExceeded {
+		return "", fmt.Errorf("ykman timed out after %s; was the YubiKey touched?", s.timeout)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read OATH code from YubiKey: %w\n%s", err, stderr.Bytes())
+	}
+
+	code := strings.TrimSpace(string(out))
+	if code == "" {
+		return "", errors.New("ykman printed no code")
+	}
+	return code, nil
+}
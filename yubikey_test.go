@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestYubikeyOTPSource_Args(t *testing.T) {
+	tests := []struct {
+		name   string
+		source *yubikeyOTPSource
+		want   []string
+	}{
+		{
+			name:   "account",
+			source: &yubikeyOTPSource{account: "aws:alice"},
+			want:   []string{"oath", "accounts", "code", "--single", "aws:alice"},
+		},
+		{
+			name:   "serial",
+			source: &yubikeyOTPSource{account: "aws:alice", serial: "12345678"},
+			want:   []string{"--device", "12345678", "oath", "accounts", "code", "--single", "aws:alice"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.source.args(); !slices.Equal(got, tt.want) {
+				t.Errorf("args() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func writeFakeYkman(t *testing.T, script string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "ykman")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+script+"\n"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestYubikeyOTPSource_OTP(t *testing.T) {
+	if f, err := os.OpenFile("/dev/tty", os.O_RDWR, 0); err == nil {
+		_ = f.Close()
+		t.Skip("a controlling terminal is available")
+	}
+
+	tests := []struct {
+		name       string
+		script     string
+		want       string
+		wantErr    string
+		wantPrompt string
+	}{
+		{name: "code", script: "echo 'Touch your YubiKey...' >&2; echo 123456", want: "123456", wantPrompt: "Touch your YubiKey"},
+		{name: "failure", script: "echo 'No YubiKey detected!' >&2; exit 2", wantErr: "No YubiKey detected!"},
+		{name: "timeout", script: "exec sleep 5", wantErr: "timed out"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stderr bytes.Buffer
+			source := &yubikeyOTPSource{
+				cliPath: writeFakeYkman(t, tt.script),
+				account: "aws",
+				timeout: 100 * time.Millisecond,
+				prompt:  &ttyOTPSource{stderr: &stderr},
+			}
+
+			got, err := source.OTP(context.Background(), "arn:aws:iam::123456789012:mfa/alice")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("OTP() error = %v, want containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("OTP() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("OTP() = %q, want %q", got, tt.want)
+			}
+			if !strings.Contains(stderr.String(), tt.wantPrompt) {
+				t.Errorf("stderr = %q, want containing %q", stderr.String(), tt.wantPrompt)
+			}
+		})
+	}
+}